@@ -0,0 +1,144 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"messageApi/internal/types"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// apiKeyColumns are the columns selected for an APIKey, in the order scanAPIKey expects them
+const apiKeyColumns = "id, tenant, name, prefix, hash, scopes, created_at, revoked_at"
+
+// CreateAPIKey will INSERT the APIKey into the database for its tenant
+func (d *database) CreateAPIKey(ctx context.Context, key types.APIKey) (types.APIKey, error) {
+	key.CreatedAt = now()
+	args := pgx.NamedArgs{
+		"tenant":     key.Tenant,
+		"name":       key.Name,
+		"prefix":     key.Prefix,
+		"hash":       key.Hash,
+		"scopes":     joinScopes(key.Scopes),
+		"created_at": key.CreatedAt,
+	}
+
+	err := d.conn.QueryRow(ctx, "INSERT INTO public.api_keys (tenant, name, prefix, hash, scopes, created_at) VALUES(@tenant, @name, @prefix, @hash, @scopes, @created_at) RETURNING id", args).Scan(&key.Id)
+	if err != nil {
+		return types.APIKey{}, wrapError(err)
+	}
+
+	return key, nil
+}
+
+// GetAPIKey returns the APIKey with the prefix from the database in any tenant, including revoked keys
+// Keys are looked up before the tenant of the request is known, as the key decides it
+func (d *database) GetAPIKey(ctx context.Context, prefix string) (types.APIKey, error) {
+	row := d.conn.QueryRow(ctx, "SELECT "+apiKeyColumns+" FROM public.api_keys WHERE prefix = @prefix", pgx.NamedArgs{"prefix": prefix})
+
+	key, err := scanAPIKey(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return types.APIKey{}, apiKeyNotFoundError(prefix)
+	} else if err != nil {
+		return types.APIKey{}, wrapError(err)
+	}
+
+	return key, nil
+}
+
+// ListAPIKeys returns every APIKey for the tenant in the database ordered by id
+func (d *database) ListAPIKeys(ctx context.Context) ([]types.APIKey, error) {
+	rows, err := d.conn.Query(ctx, "SELECT "+apiKeyColumns+" FROM public.api_keys WHERE tenant = @tenant ORDER BY id", pgx.NamedArgs{"tenant": types.TenantFromContext(ctx)})
+	if err != nil {
+		return []types.APIKey{}, wrapError(err)
+	}
+	defer rows.Close()
+
+	keys := []types.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return []types.APIKey{}, wrapError(err)
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return []types.APIKey{}, wrapError(err)
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey marks the APIKey for the tenant as revoked, keeping the original time when it was already revoked
+func (d *database) RevokeAPIKey(ctx context.Context, id int) (types.APIKey, error) {
+	args := pgx.NamedArgs{"id": id, "tenant": types.TenantFromContext(ctx), "revoked_at": now()}
+	row := d.conn.QueryRow(ctx, "UPDATE public.api_keys SET revoked_at = COALESCE(revoked_at, @revoked_at) WHERE id = @id AND tenant = @tenant RETURNING "+apiKeyColumns, args)
+
+	key, err := scanAPIKey(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return types.APIKey{}, apiKeyIdNotFoundError(id)
+	} else if err != nil {
+		return types.APIKey{}, wrapError(err)
+	}
+
+	return key, nil
+}
+
+// scanAPIKey scans the apiKeyColumns of a row from either database into an APIKey
+func scanAPIKey(row scanner) (types.APIKey, error) {
+	var key types.APIKey
+	var scopes string
+
+	if err := row.Scan(&key.Id, &key.Tenant, &key.Name, &key.Prefix, &key.Hash, &scopes, &key.CreatedAt, &key.RevokedAt); err != nil {
+		return types.APIKey{}, err
+	}
+	key.Scopes = splitScopes(scopes)
+	key.CreatedAt = key.CreatedAt.UTC()
+	if key.RevokedAt != nil {
+		revokedAt := key.RevokedAt.UTC()
+		key.RevokedAt = &revokedAt
+	}
+
+	return key, nil
+}
+
+// joinScopes stores the scopes as a comma separated list so every database can hold them in a text column
+func joinScopes(scopes []types.Scope) string {
+	values := make([]string, len(scopes))
+	for i, scope := range scopes {
+		values[i] = string(scope)
+	}
+
+	return strings.Join(values, ",")
+}
+
+// splitScopes reads scopes stored by joinScopes
+func splitScopes(value string) []types.Scope {
+	scopes := []types.Scope{}
+	for _, scope := range strings.Split(value, ",") {
+		if scope != "" {
+			scopes = append(scopes, types.Scope(scope))
+		}
+	}
+
+	return scopes
+}
+
+// now returns the current time in UTC, truncated to the microsecond precision every database can store
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// apiKeyNotFoundError returns the error used when no APIKey exists for the prefix
+func apiKeyNotFoundError(prefix string) error {
+	return fmt.Errorf("api key %q %w", prefix, types.ErrNotFound)
+}
+
+// apiKeyIdNotFoundError returns the error used when no APIKey exists for the id
+func apiKeyIdNotFoundError(id int) error {
+	return fmt.Errorf("api key %d %w", id, types.ErrNotFound)
+}
@@ -0,0 +1,198 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"messageApi/internal/types"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// messageWriter makes the changes to Messages in a batch one at a time
+type messageWriter interface {
+	CreateMessage(context.Context, types.Message) (types.Message, error)
+	UpdateMessage(context.Context, types.Message) (types.Message, error)
+	DeleteMessage(context.Context, int, int) error
+}
+
+// applyOperations makes each change in the batch in order, returning a types.BatchError for the first that fails
+// The caller is responsible for undoing the changes already made when an error is returned
+func applyOperations(ctx context.Context, writer messageWriter, ops []types.BatchOperation) ([]types.Message, error) {
+	msgs := make([]types.Message, len(ops))
+
+	for i, op := range ops {
+		var err error
+
+		switch op.Op {
+		case types.BatchCreate:
+			msgs[i], err = writer.CreateMessage(ctx, op.Message)
+		case types.BatchUpdate:
+			msgs[i], err = writer.UpdateMessage(ctx, op.Message)
+		case types.BatchDelete:
+			msgs[i], err = types.Message{Id: op.Id}, writer.DeleteMessage(ctx, op.Id, op.Version)
+		default:
+			err = unsupportedOperationError(op.Op)
+		}
+
+		if err != nil {
+			return nil, &types.BatchError{Index: i, Err: err}
+		}
+	}
+
+	return msgs, nil
+}
+
+// ApplyBatch makes every change in the batch in a single transaction, so either all of them are made or none are
+// Creates are stored with COPY and the updates and deletes are sent together in a pgx.Batch, so even a large batch
+// only takes a few round trips. A types.BatchError is returned for the first change that fails
+func (d *database) ApplyBatch(ctx context.Context, ops []types.BatchOperation) ([]types.Message, error) {
+	creates, changes := []int{}, []int{}
+	for i, op := range ops {
+		switch op.Op {
+		case types.BatchCreate:
+			creates = append(creates, i)
+		case types.BatchUpdate, types.BatchDelete:
+			changes = append(changes, i)
+		default:
+			return nil, &types.BatchError{Index: i, Err: unsupportedOperationError(op.Op)}
+		}
+	}
+
+	msgs := make([]types.Message, len(ops))
+	err := d.runTx(ctx, func(tx pgx.Tx) error {
+		// COPY does not say which row failed, so the creates are inserted one at a time to find it
+		err := commitTx(ctx, tx.Begin, func(tx pgx.Tx) error { return copyMessages(ctx, tx, ops, creates, msgs) })
+		if isSerializationFailure(err) {
+			return err
+		} else if err != nil {
+			if err := d.insertMessages(ctx, tx, ops, creates, msgs); err != nil {
+				return err
+			}
+		}
+
+		return sendChanges(ctx, tx, ops, changes, msgs)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return msgs, nil
+}
+
+// copyMessages stores the Messages created by the operations at the indexes with COPY, setting each stored Message in msgs
+// COPY cannot return the ids it assigns, so they are taken from the id sequence first
+func copyMessages(ctx context.Context, tx pgx.Tx, ops []types.BatchOperation, indexes []int, msgs []types.Message) error {
+	if len(indexes) == 0 {
+		return nil
+	}
+
+	rows, err := tx.Query(ctx, "SELECT nextval(pg_get_serial_sequence('public.messages', 'id')) FROM generate_series(1, @count)", pgx.NamedArgs{"count": len(indexes)})
+	if err != nil {
+		return wrapError(err)
+	}
+
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return wrapError(err)
+	}
+
+	tenant := types.TenantFromContext(ctx)
+	columns := []string{"id", "message", "ispalindrome", "palindromemode", "owner", "tenant"}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"public", "messages"}, columns, pgx.CopyFromSlice(len(indexes), func(i int) ([]any, error) {
+		msg := ops[indexes[i]].Message
+		return []any{ids[i], msg.Message, msg.IsPalindrome, string(msg.PalindromeMode), msg.Owner, tenant}, nil
+	}))
+	if err != nil {
+		return wrapError(err)
+	}
+
+	for i, index := range indexes {
+		msg := ops[index].Message
+		msg.Id = ids[i]
+		msg.Version = 1
+		msgs[index] = msg
+	}
+
+	return nil
+}
+
+// insertMessages stores the Messages created by the operations at the indexes one at a time, setting each stored
+// Message in msgs. A types.BatchError is returned for the first create that fails
+func (d *database) insertMessages(ctx context.Context, tx pgx.Tx, ops []types.BatchOperation, indexes []int, msgs []types.Message) error {
+	writer := &database{config: d.config, pool: d.pool, conn: tx, migrator: d.migrator, logger: d.logger}
+
+	for _, index := range indexes {
+		msg, err := writer.CreateMessage(ctx, ops[index].Message)
+		if err != nil {
+			return &types.BatchError{Index: index, Err: err}
+		}
+		msgs[index] = msg
+	}
+
+	return nil
+}
+
+// sendChanges sends the updates and deletes made by the operations at the indexes in a single pgx.Batch, setting each
+// updated Message in msgs. A types.BatchError is returned for the first change that fails
+func sendChanges(ctx context.Context, tx pgx.Tx, ops []types.BatchOperation, indexes []int, msgs []types.Message) error {
+	if len(indexes) == 0 {
+		return nil
+	}
+
+	batch := &pgx.Batch{}
+	for _, index := range indexes {
+		op := ops[index]
+		if op.Op == types.BatchDelete {
+			query, args := deleteMessageQuery(ctx, op.Id, op.Version)
+			batch.Queue(query, args)
+		} else {
+			query, args := updateMessageQuery(ctx, op.Message)
+			batch.Queue(query, args)
+		}
+	}
+
+	results := tx.SendBatch(ctx, batch)
+	for _, index := range indexes {
+		op := ops[index]
+
+		changed, err := readChange(results, op, &msgs[index])
+		if err == nil && changed {
+			continue
+		}
+
+		// the results must be closed before the transaction can be used to find out why nothing was changed
+		results.Close()
+		if err != nil {
+			err = wrapError(err)
+		} else {
+			err = staleOrMissing(ctx, func(ctx context.Context, id int) (types.Message, error) { return getMessage(ctx, tx, id) }, op.Id, op.Version)
+		}
+
+		return &types.BatchError{Index: index, Err: err}
+	}
+
+	return wrapError(results.Close())
+}
+
+// readChange reads the result of an update or delete sent in a batch into msg, returning false when nothing was changed
+func readChange(results pgx.BatchResults, op types.BatchOperation, msg *types.Message) (bool, error) {
+	if op.Op == types.BatchDelete {
+		*msg = types.Message{Id: op.Id}
+		cmd, err := results.Exec()
+		return cmd.RowsAffected() > 0, err
+	}
+
+	*msg = op.Message
+	err := results.QueryRow().Scan(&msg.Owner, &msg.Version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// unsupportedOperationError returns the error used for a change in a batch that is not a create, update or delete
+func unsupportedOperationError(op types.BatchOp) error {
+	return &types.ValidationError{Field: "op", Message: fmt.Sprintf("operation %q is not supported", op)}
+}
@@ -0,0 +1,77 @@
+package database_test
+
+import (
+	"context"
+	"fmt"
+	"messageApi/internal/database"
+	"messageApi/internal/database/databasetest"
+	"messageApi/internal/logging"
+	"messageApi/internal/types"
+	"os"
+	"testing"
+
+	"github.com/caarlos0/env/v11"
+	"github.com/jackc/pgx/v5"
+)
+
+// TestMemoryConformance runs the conformance suite against the in-memory data module
+func TestMemoryConformance(t *testing.T) {
+	databasetest.Run(t, func(t *testing.T) database.Database {
+		return database.NewMemoryDatabase()
+	})
+}
+
+// TestSQLiteConformance runs the conformance suite against an in-memory SQLite data module
+func TestSQLiteConformance(t *testing.T) {
+	databasetest.Run(t, func(t *testing.T) database.Database {
+		db, err := database.NewDatabase(types.Config{Db: types.DbConnection{Driver: database.DriverSQLite, Path: ":memory:"}}, logging.Discard())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		return db
+	})
+}
+
+// TestPostgresConformance runs the conformance suite against Postgres
+// It is skipped unless TEST_DB_HOST is set; the other TEST_DB_* variables match the DB_* variables used by the service
+func TestPostgresConformance(t *testing.T) {
+	if os.Getenv("TEST_DB_HOST") == "" {
+		t.Skip("TEST_DB_HOST is not set")
+	}
+
+	cfg := types.Config{}
+	if err := env.ParseWithOptions(&cfg, env.Options{Prefix: "TEST_"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Db.Driver = database.DriverPostgres
+	cfg.Db.AutoMigrate = true
+
+	databasetest.Run(t, func(t *testing.T) database.Database {
+		db, err := database.NewDatabase(cfg, logging.Discard())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		truncateMessages(t, cfg)
+
+		return db
+	})
+}
+
+// truncateMessages removes every Message, APIKey and IdempotencyRecord and resets the ids so each test starts with empty tables
+func truncateMessages(t *testing.T, cfg types.Config) {
+	ctx := context.Background()
+	url := fmt.Sprintf("postgres://%s:%s@%s:%d/%s", cfg.Db.User, cfg.Db.Password, cfg.Db.Host, cfg.Db.Port, cfg.Db.Database)
+
+	conn, err := pgx.Connect(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+
+	if _, err := conn.Exec(ctx, "TRUNCATE public.messages, public.api_keys, public.idempotency_keys RESTART IDENTITY"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,38 +2,110 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"messageApi/internal/migrations"
 	"messageApi/internal/types"
 	"strconv"
+	"strings"
 	"sync"
 
+	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
 )
 
 // Database is the interface for the data module
+// Messages are always read and written for the tenant held in the context, see types.TenantFromContext
+// ListAPIKeys and RevokeAPIKey only see the APIKeys of that tenant, while GetAPIKey finds an APIKey in any tenant
+// UpdateMessage and DeleteMessage only change a Message at the version given, or at any version when it is 0
+// GetMessages returns the stored Messages with the ids ordered by id, leaving out ids that are not stored
+// ApplyBatch makes every change in a batch or none of them, returning a types.BatchError for the change that failed, or a
+// plain error when the batch could not be run at all
+// WithTx runs a function with a Database whose changes are all committed together, see WithTx on each implementation
 type Database interface {
-	GetMessage(int) (types.Message, error)
-	ListMessages() ([]types.Message, error)
-	CreateMessage(types.Message) (types.Message, error)
-	UpdateMessage(types.Message) (types.Message, error)
-	DeleteMessage(int) error
+	GetMessage(context.Context, int) (types.Message, error)
+	GetMessages(context.Context, []int) ([]types.Message, error)
+	ListMessages(context.Context, types.ListOptions) ([]types.Message, error)
+	CreateMessage(context.Context, types.Message) (types.Message, error)
+	UpdateMessage(context.Context, types.Message) (types.Message, error)
+	DeleteMessage(context.Context, int, int) error
+	ApplyBatch(context.Context, []types.BatchOperation) ([]types.Message, error)
+	WithTx(context.Context, func(Database) error) error
+	CountMessages(context.Context) (int, error)
+	CreateAPIKey(context.Context, types.APIKey) (types.APIKey, error)
+	GetAPIKey(context.Context, string) (types.APIKey, error)
+	ListAPIKeys(context.Context) ([]types.APIKey, error)
+	RevokeAPIKey(context.Context, int) (types.APIKey, error)
+	CreateIdempotencyRecord(context.Context, types.IdempotencyRecord) error
+	GetIdempotencyRecord(context.Context, string, string) (types.IdempotencyRecord, error)
+	CompleteIdempotencyRecord(context.Context, types.IdempotencyRecord) error
+	DeleteIdempotencyRecord(context.Context, string, string) error
+	Ping(context.Context) error
+	CheckMigrations(context.Context) error
+	Close() error
 }
 
 // database is the implementation of the data module
+// Queries are run with conn, which is the connection pool itself or a transaction on it
 type database struct {
-	config types.Config
-	conn   *pgxpool.Pool
+	config   types.Config
+	pool     *pgxpool.Pool
+	conn     pgxConn
+	migrator migrations.Migrator
+	logger   *slog.Logger
 }
 
-// NewDatabase creates an instance of the data module
-func NewDatabase(cfg types.Config) (Database, error) {
-	db, err := initializeDatabase(cfg)
+// pgxConn runs queries on either the connection pool or a transaction
+type pgxConn interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// Drivers that can be used to store the data
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+	DriverMemory   = "memory"
+)
+
+// NewDatabase creates an instance of the data module using the driver from the config
+func NewDatabase(cfg types.Config, logger *slog.Logger) (Database, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	switch cfg.Db.Driver {
+	case DriverPostgres, "":
+		return newPostgresDatabase(cfg, logger)
+	case DriverSQLite:
+		return newSQLiteDatabase(cfg, logger)
+	case DriverMemory:
+		return NewMemoryDatabase(), nil
+	default:
+		return nil, fmt.Errorf("database driver %q is not supported", cfg.Db.Driver)
+	}
+}
+
+// newPostgresDatabase creates an instance of the data module backed by Postgres
+func newPostgresDatabase(cfg types.Config, logger *slog.Logger) (Database, error) {
+	db, err := initializeDatabase(cfg, logger)
 	if err != nil {
 		return nil, err
 	}
 
-	return &database{cfg, db}, nil
+	// the migrator is kept to check for pending migrations and is closed with the database, closing its sql.DB only
+	// releases the connections it borrowed and leaves the pool open
+	migrator, err := migrations.NewMigrator(stdlib.OpenDBFromPool(db), migrations.Postgres)
+	if err != nil {
+		return nil, err
+	}
+
+	return &database{config: cfg, pool: db, conn: db, migrator: migrator, logger: logger}, nil
 }
 
 // Used to verify connection pool is only initialized once
@@ -43,41 +115,102 @@ var (
 	dbErr  error
 )
 
-// initializeDatabase creates a connection pool to the database and creates the Messages table
+// initializeDatabase creates a connection pool to the database and applies migrations when enabled
 // Will only create the connection pool on first call and return the same pool for all calls
-func initializeDatabase(cfg types.Config) (*pgxpool.Pool, error) {
+func initializeDatabase(cfg types.Config, logger *slog.Logger) (*pgxpool.Pool, error) {
 	pgOnce.Do(func() {
-		dbUrl := fmt.Sprintf("postgres://%s:%s@%s:%d/%s", cfg.Db.User, cfg.Db.Password, cfg.Db.Host, cfg.Db.Port, cfg.Db.Database)
+		var poolConfig *pgxpool.Config
+		poolConfig, dbErr = pgxpool.ParseConfig(connectionUrl(cfg))
+		if dbErr != nil {
+			return
+		}
+		poolConfig.ConnConfig.Tracer = queryTracer{database: cfg.Db.Database}
 
-		dbPool, dbErr = pgxpool.New(context.Background(), dbUrl)
+		dbPool, dbErr = pgxpool.NewWithConfig(context.Background(), poolConfig)
 		if dbErr != nil {
 			return
 		}
 
-		// TODO: extract this, the API service should not be responsible for migrations
-		createTableSql := `CREATE TABLE IF NOT EXISTS public.messages (
-			id serial4 NOT NULL,
-			message varchar(100) NULL,
-			ispalindrome bool NULL,
-			CONSTRAINT messages_pk PRIMARY KEY (id)
-		);`
+		logger.Info("created database connection pool", "driver", DriverPostgres, "host", cfg.Db.Host, "database", cfg.Db.Database)
 
-		_, dbErr = dbPool.Exec(context.Background(), createTableSql)
+		if cfg.Db.AutoMigrate {
+			dbErr = migrateUp(dbPool, logger)
+		}
 	})
 
 	return dbPool, dbErr
 }
 
+// migrateUp applies any outstanding migrations using connections from the pool, closing the sql.DB wrapping the pool
+// once done which leaves the pool itself open
+func migrateUp(pool *pgxpool.Pool, logger *slog.Logger) error {
+	migrator, err := migrations.NewMigrator(stdlib.OpenDBFromPool(pool), migrations.Postgres)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	return applyMigrations(migrator, logger)
+}
+
+// applyMigrations applies any outstanding migrations, logging each one that is applied
+func applyMigrations(migrator migrations.Migrator, logger *slog.Logger) error {
+	applied, err := migrator.Up(context.Background())
+	for _, migration := range applied {
+		logger.Info("applied migration", "version", migration.Version, "name", migration.Name)
+	}
+
+	return err
+}
+
+// NewMigrator creates a Migrator with its own connection to the database configured in cfg
+func NewMigrator(cfg types.Config) (migrations.Migrator, error) {
+	switch cfg.Db.Driver {
+	case DriverMemory:
+		return nil, errors.New("the memory database driver does not use migrations")
+	case DriverSQLite:
+		db, err := openSQLite(cfg.Db.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		migrator, err := migrations.NewMigrator(db, migrations.SQLite)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		return migrator, nil
+	}
+
+	connConfig, err := pgx.ParseConfig(connectionUrl(cfg))
+	if err != nil {
+		return nil, err
+	}
+
+	return migrations.NewMigrator(stdlib.OpenDB(*connConfig), migrations.Postgres)
+}
+
+// connectionUrl builds the Postgres connection URL from the config
+func connectionUrl(cfg types.Config) string {
+	return fmt.Sprintf("postgres://%s:%s@%s:%d/%s", cfg.Db.User, cfg.Db.Password, cfg.Db.Host, cfg.Db.Port, cfg.Db.Database)
+}
+
+// messageColumns are the columns selected for a Message, in the order scanMessage expects them
+const messageColumns = "id, message, ispalindrome, palindromemode, owner, version"
+
 // CreateMessage will INSERT the Message into the database
-func (d *database) CreateMessage(msg types.Message) (types.Message, error) {
+func (d *database) CreateMessage(ctx context.Context, msg types.Message) (types.Message, error) {
 	args := pgx.NamedArgs{
-		"message":      msg.Message,
-		"ispalindrome": strconv.FormatBool(msg.IsPalindrome),
+		"message":        msg.Message,
+		"ispalindrome":   strconv.FormatBool(msg.IsPalindrome),
+		"palindromemode": string(msg.PalindromeMode),
+		"owner":          msg.Owner,
+		"tenant":         types.TenantFromContext(ctx),
 	}
 	var id int
-	err := d.conn.QueryRow(context.Background(), "INSERT INTO public.messages (message, ispalindrome) VALUES(@message, @ispalindrome) RETURNING id", args).Scan(&id)
+	err := d.conn.QueryRow(ctx, "INSERT INTO public.messages (message, ispalindrome, palindromemode, owner, tenant) VALUES(@message, @ispalindrome, @palindromemode, @owner, @tenant) RETURNING id, version", args).Scan(&id, &msg.Version)
 	if err != nil {
-		return types.Message{}, err
+		return types.Message{}, wrapError(err)
 	}
 
 	msg.Id = id
@@ -86,68 +219,294 @@ func (d *database) CreateMessage(msg types.Message) (types.Message, error) {
 }
 
 // GetMessage returns a single Message from the database
-func (d *database) GetMessage(id int) (types.Message, error) {
+func (d *database) GetMessage(ctx context.Context, id int) (types.Message, error) {
+	return getMessage(ctx, d.conn, id)
+}
+
+// querier runs queries on either the connection pool or a transaction
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// getMessage returns a single Message using the connection pool or transaction
+func getMessage(ctx context.Context, conn querier, id int) (types.Message, error) {
+	args := pgx.NamedArgs{
+		"id":     strconv.Itoa(id),
+		"tenant": types.TenantFromContext(ctx),
+	}
+
+	rows, err := conn.Query(ctx, "SELECT "+messageColumns+" FROM public.messages WHERE id = @id AND tenant = @tenant", args)
+	if err != nil {
+		return types.Message{}, wrapError(err)
+	}
+	defer rows.Close()
+
+	msg, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[types.Message])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return types.Message{}, notFoundError(id)
+	} else if err != nil {
+		return types.Message{}, wrapError(err)
+	}
+
+	return msg, nil
+}
+
+// GetMessages returns the stored Messages with the ids in a single query, leaving out ids that are not stored
+func (d *database) GetMessages(ctx context.Context, ids []int) ([]types.Message, error) {
 	args := pgx.NamedArgs{
-		"id": strconv.Itoa(id),
+		"ids":    ids,
+		"tenant": types.TenantFromContext(ctx),
 	}
 
-	rows, err := d.conn.Query(context.Background(), "SELECT id, message, ispalindrome FROM public.messages WHERE id = @id", args)
+	rows, err := d.conn.Query(ctx, "SELECT "+messageColumns+" FROM public.messages WHERE id = ANY(@ids) AND tenant = @tenant ORDER BY id", args)
 	if err != nil {
-		return types.Message{}, err
+		return []types.Message{}, wrapError(err)
 	}
 	defer rows.Close()
 
 	msgs, err := pgx.CollectRows(rows, pgx.RowToStructByName[types.Message])
-	if err != nil || len(msgs) == 0 {
-		return types.Message{}, err
+	if err != nil {
+		return []types.Message{}, wrapError(err)
 	}
 
-	return msgs[0], nil
+	return msgs, nil
 }
 
-// ListMessages returns a full list of Messages in the database
-func (d *database) ListMessages() ([]types.Message, error) {
-	rows, err := d.conn.Query(context.Background(), "SELECT id, message, ispalindrome FROM public.messages")
+// ListMessages returns a single page of Messages from the database matching the list options
+func (d *database) ListMessages(ctx context.Context, opts types.ListOptions) ([]types.Message, error) {
+	query, args := listMessagesQuery("public.messages", types.TenantFromContext(ctx), opts)
+
+	rows, err := d.conn.Query(ctx, query, args)
 	if err != nil {
-		return []types.Message{}, err
+		return []types.Message{}, wrapError(err)
 	}
 	defer rows.Close()
 
 	msgs, err := pgx.CollectRows(rows, pgx.RowToStructByName[types.Message])
 	if err != nil {
-		return []types.Message{}, err
+		return []types.Message{}, wrapError(err)
 	}
 
 	return msgs, nil
 }
 
-// UpdateMessage performs an UPDATE on an existing Message in the database
-func (d *database) UpdateMessage(msg types.Message) (types.Message, error) {
-	args := pgx.NamedArgs{
-		"id":           msg.Id,
-		"message":      msg.Message,
-		"ispalindrome": strconv.FormatBool(msg.IsPalindrome),
+// listMessagesQuery builds the keyset paginated SELECT of the tenant's Messages from the table for the list options
+// The table and sort column are always taken from a fixed set so they are safe to add to the query text
+func listMessagesQuery(table string, tenant string, opts types.ListOptions) (string, pgx.NamedArgs) {
+	column := "id"
+	if opts.Sort == types.SortByMessage {
+		column = "message"
 	}
-	cmd, err := d.conn.Exec(context.Background(), "UPDATE public.messages SET message = @message, ispalindrome = @ispalindrome WHERE id = @id", args)
-	if err != nil || cmd.RowsAffected() == 0 {
-		return types.Message{}, err
+
+	direction, comparison := "ASC", ">"
+	if opts.Order == types.OrderDesc {
+		direction, comparison = "DESC", "<"
+	}
+
+	args := pgx.NamedArgs{"tenant": tenant}
+	conditions := []string{"tenant = @tenant"}
+
+	if opts.IsPalindrome != nil {
+		conditions = append(conditions, "ispalindrome = @ispalindrome")
+		args["ispalindrome"] = *opts.IsPalindrome
+	}
+
+	if opts.Owner != "" {
+		conditions = append(conditions, "owner = @owner")
+		args["owner"] = opts.Owner
+	}
+
+	if opts.After != nil {
+		args["after_id"] = opts.After.Id
+		if column == "id" {
+			conditions = append(conditions, fmt.Sprintf("id %s @after_id", comparison))
+		} else {
+			conditions = append(conditions, fmt.Sprintf("(%s, id) %s (@after_value, @after_id)", column, comparison))
+			args["after_value"] = opts.After.Value
+		}
+	}
+
+	query := "SELECT " + messageColumns + " FROM " + table + " WHERE " + strings.Join(conditions, " AND ")
+
+	if column == "id" {
+		query += fmt.Sprintf(" ORDER BY id %s", direction)
+	} else {
+		query += fmt.Sprintf(" ORDER BY %s %s, id %s", column, direction, direction)
+	}
+
+	if opts.Limit > 0 {
+		query += " LIMIT @limit"
+		args["limit"] = opts.Limit
+	}
+
+	return query, args
+}
+
+// UpdateMessage performs an UPDATE on an existing Message in the database and increases its version
+// The owner of a Message never changes so it is not updated
+func (d *database) UpdateMessage(ctx context.Context, msg types.Message) (types.Message, error) {
+	query, args := updateMessageQuery(ctx, msg)
+
+	expected := msg.Version
+	err := d.conn.QueryRow(ctx, query, args).Scan(&msg.Owner, &msg.Version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return types.Message{}, staleOrMissing(ctx, d.GetMessage, msg.Id, expected)
+	} else if err != nil {
+		return types.Message{}, wrapError(err)
 	}
 
 	return msg, nil
 }
 
-// DeleteMessage performs a DELETE on an existing message in the database
-func (d *database) DeleteMessage(id int) error {
+// updateMessageQuery builds the UPDATE of the Message returning its owner and new version
+// The version is only matched when it is set
+func updateMessageQuery(ctx context.Context, msg types.Message) (string, pgx.NamedArgs) {
 	args := pgx.NamedArgs{
-		"id": id,
+		"id":             msg.Id,
+		"message":        msg.Message,
+		"ispalindrome":   strconv.FormatBool(msg.IsPalindrome),
+		"palindromemode": string(msg.PalindromeMode),
+		"tenant":         types.TenantFromContext(ctx),
+		"version":        msg.Version,
+	}
+	query := "UPDATE public.messages SET message = @message, ispalindrome = @ispalindrome, palindromemode = @palindromemode, version = version + 1 WHERE id = @id AND tenant = @tenant"
+	if msg.Version > 0 {
+		query += " AND version = @version"
 	}
 
-	cmd, err := d.conn.Exec(context.Background(), "DELETE FROM public.messages WHERE id = @id", args)
+	return query + " RETURNING owner, version", args
+}
+
+// DeleteMessage performs a DELETE on an existing message in the database
+func (d *database) DeleteMessage(ctx context.Context, id int, version int) error {
+	query, args := deleteMessageQuery(ctx, id, version)
+
+	cmd, err := d.conn.Exec(ctx, query, args)
 	if err != nil {
-		return err
+		return wrapError(err)
 	} else if cmd.RowsAffected() == 0 {
-		return fmt.Errorf("no record found for id %d", id)
+		return staleOrMissing(ctx, d.GetMessage, id, version)
+	}
+
+	return nil
+}
+
+// deleteMessageQuery builds the DELETE of the Message with the id, only matching the version when it is not 0
+func deleteMessageQuery(ctx context.Context, id int, version int) (string, pgx.NamedArgs) {
+	args := pgx.NamedArgs{
+		"id":      id,
+		"tenant":  types.TenantFromContext(ctx),
+		"version": version,
+	}
+	query := "DELETE FROM public.messages WHERE id = @id AND tenant = @tenant"
+	if version > 0 {
+		query += " AND version = @version"
+	}
+
+	return query, args
+}
+
+// CountMessages returns how many Messages the tenant has stored
+func (d *database) CountMessages(ctx context.Context) (int, error) {
+	var count int
+	err := d.conn.QueryRow(ctx, "SELECT count(*) FROM public.messages WHERE tenant = @tenant", pgx.NamedArgs{"tenant": types.TenantFromContext(ctx)}).Scan(&count)
+	if err != nil {
+		return 0, wrapError(err)
+	}
+
+	return count, nil
+}
+
+// Ping checks a connection to the database can be acquired and used
+func (d *database) Ping(ctx context.Context) error {
+	return wrapError(d.pool.Ping(ctx))
+}
+
+// CheckMigrations returns an error when migrations have not been applied to the database
+// It only reads the database, as it is called by every readiness probe
+func (d *database) CheckMigrations(ctx context.Context) error {
+	return checkMigrations(ctx, d.migrator)
+}
+
+// checkMigrations returns an unavailable error listing the migrations that have not been applied
+func checkMigrations(ctx context.Context, migrator migrations.Migrator) error {
+	missing, err := migrator.Pending(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: checking migrations: %v", types.ErrUnavailable, err)
+	}
+
+	pending := []string{}
+	for _, migration := range missing {
+		pending = append(pending, fmt.Sprintf("%04d_%s", migration.Version, migration.Name))
+	}
+
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: migrations have not been applied: %s", types.ErrUnavailable, strings.Join(pending, ", "))
 	}
 
 	return nil
 }
+
+// Close closes the connection pool once all acquired connections have been released
+// A Database in a transaction does not own the pool, so closing it does nothing
+func (d *database) Close() error {
+	if _, ok := d.conn.(pgx.Tx); ok {
+		return nil
+	}
+
+	if err := d.migrator.Close(); err != nil {
+		d.logger.Warn("failed to close migrator", "error", err)
+	}
+	d.pool.Close()
+	d.logger.Info("closed database connection pool")
+
+	return nil
+}
+
+// notFoundError returns the error used when no Message exists for the id
+func notFoundError(id int) error {
+	return fmt.Errorf("message %d %w", id, types.ErrNotFound)
+}
+
+// staleOrMissing returns the error for a Message that could not be changed at the version
+// A precondition error is returned when the Message still exists at another version, otherwise a not found error
+func staleOrMissing(ctx context.Context, get func(context.Context, int) (types.Message, error), id int, version int) error {
+	if version == 0 {
+		return notFoundError(id)
+	}
+
+	msg, err := get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return preconditionError(msg, version)
+}
+
+// preconditionError returns the error used when a Message is not at the version expected by the caller
+func preconditionError(msg types.Message, version int) error {
+	return fmt.Errorf("%w: message %d is at version %d, not %d", types.ErrPrecondition, msg.Id, msg.Version, version)
+}
+
+// wrapError wraps errors returned by pgx with the matching shared error so they can be identified by other modules
+// The pgx error is kept in the chain so a serialization failure can still be found when deciding to retry a transaction
+func wrapError(err error) error {
+	var pgErr *pgconn.PgError
+	var connectErr *pgconn.ConnectError
+
+	switch {
+	case errors.As(err, &pgErr):
+		switch pgErr.Code {
+		case pgerrcode.UniqueViolation, pgerrcode.SerializationFailure:
+			return fmt.Errorf("%w: %w", types.ErrConflict, err)
+		case pgerrcode.StringDataRightTruncationDataException, pgerrcode.CheckViolation, pgerrcode.NotNullViolation:
+			return fmt.Errorf("%w: %w", types.ErrValidation, err)
+		case pgerrcode.TooManyConnections, pgerrcode.CannotConnectNow, pgerrcode.AdminShutdown:
+			return fmt.Errorf("%w: %w", types.ErrUnavailable, err)
+		}
+	case errors.As(err, &connectErr), pgconn.Timeout(err), errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return fmt.Errorf("%w: %w", types.ErrUnavailable, err)
+	}
+
+	return err
+}
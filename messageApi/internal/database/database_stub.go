@@ -1,41 +1,164 @@
 package database
 
-import "messageApi/internal/types"
+import (
+	"context"
+	"messageApi/internal/types"
+)
 
 // DatabaseStub provides a stub for use in testing
 type DatabaseStub struct {
-	GetMessageResponse    types.Message
-	GetMessageError       error
-	ListMessagesResponse  []types.Message
-	ListMessagesError     error
-	CreateMessageResponse types.Message
-	CreateMessageError    error
-	UpdateMessageResponse types.Message
-	UpdateMessageError    error
-	DeleteMessageError    error
-}
-
-// CreateMessage returns static vars for use in testing
-func (d *DatabaseStub) CreateMessage(msg types.Message) (types.Message, error) {
+	GetMessageResponse       types.Message
+	GetMessageError          error
+	GetMessagesInput         []int
+	GetMessagesResponse      []types.Message
+	GetMessagesError         error
+	ListMessagesResponse     []types.Message
+	ListMessagesError        error
+	CreateMessageInput       types.Message
+	CreateMessageResponse    types.Message
+	CreateMessageError       error
+	UpdateMessageInput       types.Message
+	UpdateMessageResponse    types.Message
+	UpdateMessageError       error
+	DeleteMessageVersion     int
+	DeleteMessageError       error
+	ApplyBatchInput          []types.BatchOperation
+	ApplyBatchResponse       []types.Message
+	ApplyBatchError          error
+	WithTxCalls              int
+	WithTxError              error
+	CountMessagesResponse    int
+	CountMessagesError       error
+	CreateAPIKeyInput        types.APIKey
+	CreateAPIKeyResponse     types.APIKey
+	CreateAPIKeyError        error
+	GetAPIKeyResponse        types.APIKey
+	GetAPIKeyError           error
+	ListAPIKeysResponse      []types.APIKey
+	ListAPIKeysError         error
+	RevokeAPIKeyResponse     types.APIKey
+	RevokeAPIKeyError        error
+	CreateIdempotencyInput   types.IdempotencyRecord
+	CreateIdempotencyError   error
+	GetIdempotencyResponse   types.IdempotencyRecord
+	GetIdempotencyError      error
+	CompleteIdempotencyInput types.IdempotencyRecord
+	CompleteIdempotencyError error
+	DeleteIdempotencyError   error
+	PingError                error
+	CheckMigrationsError     error
+	CloseError               error
+}
+
+// CreateMessage records the input and returns static vars for use in testing
+func (d *DatabaseStub) CreateMessage(ctx context.Context, msg types.Message) (types.Message, error) {
+	d.CreateMessageInput = msg
 	return d.CreateMessageResponse, d.CreateMessageError
 }
 
 // GetMessage returns static vars for use in testing
-func (d *DatabaseStub) GetMessage(id int) (types.Message, error) {
+func (d *DatabaseStub) GetMessage(ctx context.Context, id int) (types.Message, error) {
 	return d.GetMessageResponse, d.GetMessageError
 }
 
+// GetMessages records the input and returns static vars for use in testing
+func (d *DatabaseStub) GetMessages(ctx context.Context, ids []int) ([]types.Message, error) {
+	d.GetMessagesInput = ids
+	return d.GetMessagesResponse, d.GetMessagesError
+}
+
 // ListMessages returns static vars for use in testing
-func (d *DatabaseStub) ListMessages() ([]types.Message, error) {
+func (d *DatabaseStub) ListMessages(ctx context.Context, opts types.ListOptions) ([]types.Message, error) {
 	return d.ListMessagesResponse, d.ListMessagesError
 }
 
-// UpdateMessage returns static vars for use in testing
-func (d *DatabaseStub) UpdateMessage(msg types.Message) (types.Message, error) {
+// UpdateMessage records the input and returns static vars for use in testing
+func (d *DatabaseStub) UpdateMessage(ctx context.Context, msg types.Message) (types.Message, error) {
+	d.UpdateMessageInput = msg
 	return d.UpdateMessageResponse, d.UpdateMessageError
 }
 
-// DeleteMessage returns static vars for use in testing
-func (d *DatabaseStub) DeleteMessage(id int) error {
+// DeleteMessage records the version and returns static vars for use in testing
+func (d *DatabaseStub) DeleteMessage(ctx context.Context, id int, version int) error {
+	d.DeleteMessageVersion = version
 	return d.DeleteMessageError
 }
+
+// ApplyBatch records the input and returns static vars for use in testing
+func (d *DatabaseStub) ApplyBatch(ctx context.Context, ops []types.BatchOperation) ([]types.Message, error) {
+	d.ApplyBatchInput = ops
+	return d.ApplyBatchResponse, d.ApplyBatchError
+}
+
+// WithTx counts the call and runs fn with the stub itself, or returns the static error without running fn
+func (d *DatabaseStub) WithTx(ctx context.Context, fn func(Database) error) error {
+	d.WithTxCalls++
+	if d.WithTxError != nil {
+		return d.WithTxError
+	}
+
+	return fn(d)
+}
+
+// CountMessages returns static vars for use in testing
+func (d *DatabaseStub) CountMessages(ctx context.Context) (int, error) {
+	return d.CountMessagesResponse, d.CountMessagesError
+}
+
+// CreateAPIKey records the input and returns static vars for use in testing
+func (d *DatabaseStub) CreateAPIKey(ctx context.Context, key types.APIKey) (types.APIKey, error) {
+	d.CreateAPIKeyInput = key
+	return d.CreateAPIKeyResponse, d.CreateAPIKeyError
+}
+
+// GetAPIKey returns static vars for use in testing
+func (d *DatabaseStub) GetAPIKey(ctx context.Context, prefix string) (types.APIKey, error) {
+	return d.GetAPIKeyResponse, d.GetAPIKeyError
+}
+
+// ListAPIKeys returns static vars for use in testing
+func (d *DatabaseStub) ListAPIKeys(ctx context.Context) ([]types.APIKey, error) {
+	return d.ListAPIKeysResponse, d.ListAPIKeysError
+}
+
+// RevokeAPIKey returns static vars for use in testing
+func (d *DatabaseStub) RevokeAPIKey(ctx context.Context, id int) (types.APIKey, error) {
+	return d.RevokeAPIKeyResponse, d.RevokeAPIKeyError
+}
+
+// CreateIdempotencyRecord records the input and returns static vars for use in testing
+func (d *DatabaseStub) CreateIdempotencyRecord(ctx context.Context, record types.IdempotencyRecord) error {
+	d.CreateIdempotencyInput = record
+	return d.CreateIdempotencyError
+}
+
+// GetIdempotencyRecord returns static vars for use in testing
+func (d *DatabaseStub) GetIdempotencyRecord(ctx context.Context, caller string, key string) (types.IdempotencyRecord, error) {
+	return d.GetIdempotencyResponse, d.GetIdempotencyError
+}
+
+// CompleteIdempotencyRecord records the input and returns static vars for use in testing
+func (d *DatabaseStub) CompleteIdempotencyRecord(ctx context.Context, record types.IdempotencyRecord) error {
+	d.CompleteIdempotencyInput = record
+	return d.CompleteIdempotencyError
+}
+
+// DeleteIdempotencyRecord returns static vars for use in testing
+func (d *DatabaseStub) DeleteIdempotencyRecord(ctx context.Context, caller string, key string) error {
+	return d.DeleteIdempotencyError
+}
+
+// Ping returns static vars for use in testing
+func (d *DatabaseStub) Ping(ctx context.Context) error {
+	return d.PingError
+}
+
+// CheckMigrations returns static vars for use in testing
+func (d *DatabaseStub) CheckMigrations(ctx context.Context) error {
+	return d.CheckMigrationsError
+}
+
+// Close returns static vars for use in testing
+func (d *DatabaseStub) Close() error {
+	return d.CloseError
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"messageApi/internal/types"
+	"testing"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestListMessagesQuery tests the default list query orders by id
+func TestListMessagesQuery(t *testing.T) {
+	query, args := listMessagesQuery("public.messages", "team-a", types.ListOptions{Limit: 10})
+
+	assert.Equal(t, "SELECT id, message, ispalindrome, palindromemode, owner, version FROM public.messages WHERE tenant = @tenant ORDER BY id ASC LIMIT @limit", query)
+	assert.Equal(t, pgx.NamedArgs{"tenant": "team-a", "limit": 10}, args)
+}
+
+// TestListMessagesQueryAfterId tests the list query starts after the cursor when sorting by id
+func TestListMessagesQueryAfterId(t *testing.T) {
+	isPalindrome := true
+	opts := types.ListOptions{Limit: 5, After: &types.Cursor{Id: 7}, Order: types.OrderDesc, IsPalindrome: &isPalindrome}
+
+	query, args := listMessagesQuery("public.messages", "team-a", opts)
+
+	assert.Equal(t, "SELECT id, message, ispalindrome, palindromemode, owner, version FROM public.messages WHERE tenant = @tenant AND ispalindrome = @ispalindrome AND id < @after_id ORDER BY id DESC LIMIT @limit", query)
+	assert.Equal(t, pgx.NamedArgs{"tenant": "team-a", "limit": 5, "after_id": 7, "ispalindrome": true}, args)
+}
+
+// TestListMessagesQueryAfterMessage tests the list query uses the message and id as the key when sorting by message
+func TestListMessagesQueryAfterMessage(t *testing.T) {
+	opts := types.ListOptions{Limit: 5, After: &types.Cursor{Id: 7, Value: "racecar"}, Sort: types.SortByMessage}
+
+	query, args := listMessagesQuery("public.messages", "team-a", opts)
+
+	assert.Equal(t, "SELECT id, message, ispalindrome, palindromemode, owner, version FROM public.messages WHERE tenant = @tenant AND (message, id) > (@after_value, @after_id) ORDER BY message ASC, id ASC LIMIT @limit", query)
+	assert.Equal(t, pgx.NamedArgs{"tenant": "team-a", "limit": 5, "after_id": 7, "after_value": "racecar"}, args)
+}
+
+// TestListMessagesQueryOwner tests the list query only returns Messages with the owner
+func TestListMessagesQueryOwner(t *testing.T) {
+	query, args := listMessagesQuery("public.messages", "team-a", types.ListOptions{Limit: 10, Owner: "apikey:1"})
+
+	assert.Equal(t, "SELECT id, message, ispalindrome, palindromemode, owner, version FROM public.messages WHERE tenant = @tenant AND owner = @owner ORDER BY id ASC LIMIT @limit", query)
+	assert.Equal(t, pgx.NamedArgs{"tenant": "team-a", "limit": 10, "owner": "apikey:1"}, args)
+}
+
+// TestWrapError tests errors from pgx are wrapped with the matching shared error
+func TestWrapError(t *testing.T) {
+	assert.True(t, errors.Is(wrapError(&pgconn.PgError{Code: pgerrcode.UniqueViolation}), types.ErrConflict))
+	assert.True(t, errors.Is(wrapError(&pgconn.PgError{Code: pgerrcode.StringDataRightTruncationDataException}), types.ErrValidation))
+	assert.True(t, errors.Is(wrapError(context.DeadlineExceeded), types.ErrUnavailable))
+
+	err := errors.New("unknown")
+	assert.Equal(t, err, wrapError(err))
+}
+
+// TestNotFoundError tests the not found error can be identified as ErrNotFound
+func TestNotFoundError(t *testing.T) {
+	err := notFoundError(1)
+
+	assert.Equal(t, "message 1 not found", err.Error())
+	assert.True(t, errors.Is(err, types.ErrNotFound))
+}
+
+// TestRetryTx tests a transaction is run again after a serialization failure, up to maxTxAttempts times
+func TestRetryTx(t *testing.T) {
+	serializationErr := wrapError(&pgconn.PgError{Code: pgerrcode.SerializationFailure})
+	assert.True(t, errors.Is(serializationErr, types.ErrConflict))
+	assert.True(t, isSerializationFailure(serializationErr))
+
+	runs := 0
+	err := retryTx(context.Background(), isSerializationFailure, func() error {
+		runs++
+		if runs == 1 {
+			return serializationErr
+		}
+		return nil
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, runs)
+
+	runs = 0
+	err = retryTx(context.Background(), isSerializationFailure, func() error {
+		runs++
+		return serializationErr
+	})
+	assert.Equal(t, serializationErr, err)
+	assert.Equal(t, maxTxAttempts, runs)
+
+	runs = 0
+	notRetryable := wrapError(&pgconn.PgError{Code: pgerrcode.UniqueViolation})
+	err = retryTx(context.Background(), isSerializationFailure, func() error {
+		runs++
+		return notRetryable
+	})
+	assert.Equal(t, notRetryable, err)
+	assert.Equal(t, 1, runs)
+}
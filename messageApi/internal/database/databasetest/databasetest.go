@@ -0,0 +1,851 @@
+// Package databasetest provides a conformance suite that every implementation of the data module must pass
+package databasetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"messageApi/internal/database"
+	"messageApi/internal/types"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Factory creates an empty instance of the data module for a single test
+type Factory func(t *testing.T) database.Database
+
+// Run runs the conformance suite against the instances of the data module created by the factory
+// Each test gets its own instance, so the factory must return a Database with no stored Messages
+func Run(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		test func(*testing.T, database.Database)
+	}{
+		{"CreateAndGet", testCreateAndGet},
+		{"IdAssignment", testIdAssignment},
+		{"Update", testUpdate},
+		{"Delete", testDelete},
+		{"Version", testVersion},
+		{"NotFound", testNotFound},
+		{"GetMessages", testGetMessages},
+		{"ListPages", testListPages},
+		{"ListSortAndOrder", testListSortAndOrder},
+		{"ListFilter", testListFilter},
+		{"Owner", testOwner},
+		{"TenantIsolation", testTenantIsolation},
+		{"CountMessages", testCountMessages},
+		{"ConcurrentWriters", testConcurrentWriters},
+		{"UnicodePayloads", testUnicodePayloads},
+		{"MessageTooLong", testMessageTooLong},
+		{"CancelledContext", testCancelledContext},
+		{"EmptyList", testEmptyList},
+		{"Ready", testReady},
+		{"APIKeys", testAPIKeys},
+		{"APIKeyTenant", testAPIKeyTenant},
+		{"APIKeyNotFound", testAPIKeyNotFound},
+		{"APIKeyDuplicatePrefix", testAPIKeyDuplicatePrefix},
+		{"IdempotencyRecords", testIdempotencyRecords},
+		{"IdempotencyExpiry", testIdempotencyExpiry},
+		{"Batch", testBatch},
+		{"BatchRollback", testBatchRollback},
+		{"BatchTenant", testBatchTenant},
+		{"TxCommit", testTxCommit},
+		{"TxRollback", testTxRollback},
+		{"TxNested", testTxNested},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.test(t, factory(t))
+		})
+	}
+}
+
+// newMessage creates a Message ready to be stored
+func newMessage(text string, isPalindrome bool) types.Message {
+	return types.Message{Message: text, IsPalindrome: isPalindrome, PalindromeMode: types.PalindromeExact, Owner: "tester"}
+}
+
+// mustCreate stores the Messages and returns them with their ids, failing the test on any error
+func mustCreate(t *testing.T, db database.Database, msgs ...types.Message) []types.Message {
+	t.Helper()
+
+	created := []types.Message{}
+	for _, msg := range msgs {
+		msg, err := db.CreateMessage(context.Background(), msg)
+		if err != nil {
+			t.Fatalf("creating message %q: %v", msg.Message, err)
+		}
+		created = append(created, msg)
+	}
+
+	return created
+}
+
+// ids returns the ids of the Messages in order
+func ids(msgs []types.Message) []int {
+	result := []int{}
+	for _, msg := range msgs {
+		result = append(result, msg.Id)
+	}
+
+	return result
+}
+
+// testCreateAndGet tests a created Message is returned with an id and can be read back unchanged
+func testCreateAndGet(t *testing.T, db database.Database) {
+	ctx := context.Background()
+
+	created, err := db.CreateMessage(ctx, newMessage("racecar", true))
+	assert.Equal(t, nil, err)
+	assert.NotZero(t, created.Id)
+	assert.Equal(t, "racecar", created.Message)
+	assert.Equal(t, true, created.IsPalindrome)
+	assert.Equal(t, types.PalindromeExact, created.PalindromeMode)
+
+	msg, err := db.GetMessage(ctx, created.Id)
+	assert.Equal(t, created, msg)
+	assert.Equal(t, nil, err)
+}
+
+// testIdAssignment tests each created Message is given a new, increasing id that is not reused after a delete
+func testIdAssignment(t *testing.T, db database.Database) {
+	msgs := mustCreate(t, db, newMessage("a", true), newMessage("b", true), newMessage("c", true))
+
+	assert.Less(t, 0, msgs[0].Id)
+	assert.Less(t, msgs[0].Id, msgs[1].Id)
+	assert.Less(t, msgs[1].Id, msgs[2].Id)
+
+	assert.Equal(t, nil, db.DeleteMessage(context.Background(), msgs[2].Id, 0))
+
+	next := mustCreate(t, db, newMessage("d", true))[0]
+	assert.Less(t, msgs[2].Id, next.Id)
+}
+
+// testUpdate tests an update replaces the stored Message and increases its version
+func testUpdate(t *testing.T, db database.Database) {
+	ctx := context.Background()
+	msg := mustCreate(t, db, newMessage("test", false))[0]
+	assert.Equal(t, 1, msg.Version)
+
+	msg.Message = "level"
+	msg.IsPalindrome = true
+	msg.PalindromeMode = types.PalindromeCaseInsensitive
+
+	updated, err := db.UpdateMessage(ctx, msg)
+	msg.Version = 2
+	assert.Equal(t, msg, updated)
+	assert.Equal(t, nil, err)
+
+	stored, err := db.GetMessage(ctx, msg.Id)
+	assert.Equal(t, msg, stored)
+	assert.Equal(t, nil, err)
+}
+
+// testDelete tests a deleted Message can no longer be read and other Messages are kept
+func testDelete(t *testing.T, db database.Database) {
+	ctx := context.Background()
+	msgs := mustCreate(t, db, newMessage("a", true), newMessage("b", true))
+
+	assert.Equal(t, nil, db.DeleteMessage(ctx, msgs[0].Id, 0))
+
+	_, err := db.GetMessage(ctx, msgs[0].Id)
+	assert.True(t, errors.Is(err, types.ErrNotFound), "expected not found, got %v", err)
+
+	kept, err := db.GetMessage(ctx, msgs[1].Id)
+	assert.Equal(t, msgs[1], kept)
+	assert.Equal(t, nil, err)
+}
+
+// testVersion tests a Message is only updated or deleted at the version given, or at any version for 0
+func testVersion(t *testing.T, db database.Database) {
+	ctx := context.Background()
+	msg := mustCreate(t, db, newMessage("test", false))[0]
+
+	updated, err := db.UpdateMessage(ctx, msg)
+	assert.Equal(t, 2, updated.Version)
+	assert.Equal(t, nil, err)
+
+	_, err = db.UpdateMessage(ctx, msg)
+	assert.True(t, errors.Is(err, types.ErrPrecondition), "update: expected precondition failure, got %v", err)
+
+	err = db.DeleteMessage(ctx, msg.Id, 1)
+	assert.True(t, errors.Is(err, types.ErrPrecondition), "delete: expected precondition failure, got %v", err)
+
+	stored, _ := db.GetMessage(ctx, msg.Id)
+	assert.Equal(t, updated, stored)
+
+	msg.Version = 0
+	updated, err = db.UpdateMessage(ctx, msg)
+	assert.Equal(t, 3, updated.Version)
+	assert.Equal(t, nil, err)
+
+	_, err = db.UpdateMessage(ctx, types.Message{Id: 404, Message: "test", PalindromeMode: types.PalindromeExact, Version: 1})
+	assert.True(t, errors.Is(err, types.ErrNotFound), "update missing: expected not found, got %v", err)
+
+	err = db.DeleteMessage(ctx, 404, 1)
+	assert.True(t, errors.Is(err, types.ErrNotFound), "delete missing: expected not found, got %v", err)
+
+	assert.Equal(t, nil, db.DeleteMessage(ctx, msg.Id, 3))
+}
+
+// testNotFound tests reading, updating and deleting a missing Message all return ErrNotFound
+func testNotFound(t *testing.T, db database.Database) {
+	ctx := context.Background()
+	missing := 404
+
+	_, err := db.GetMessage(ctx, missing)
+	assert.True(t, errors.Is(err, types.ErrNotFound), "get: expected not found, got %v", err)
+
+	_, err = db.UpdateMessage(ctx, types.Message{Id: missing, Message: "test", PalindromeMode: types.PalindromeExact})
+	assert.True(t, errors.Is(err, types.ErrNotFound), "update: expected not found, got %v", err)
+
+	err = db.DeleteMessage(ctx, missing, 0)
+	assert.True(t, errors.Is(err, types.ErrNotFound), "delete: expected not found, got %v", err)
+}
+
+// testGetMessages tests Messages are returned ordered by id for the ids that are stored in the tenant
+func testGetMessages(t *testing.T, db database.Database) {
+	ctx := context.Background()
+	created := mustCreate(t, db, newMessage("test", false), newMessage("kayak", true), newMessage("racecar", true))
+
+	other, err := db.CreateMessage(types.WithTenant(ctx, "tenant-b"), newMessage("level", true))
+	assert.Equal(t, nil, err)
+
+	msgs, err := db.GetMessages(ctx, []int{created[2].Id, created[0].Id, 404, created[0].Id, other.Id})
+	assert.Equal(t, []types.Message{created[0], created[2]}, msgs)
+	assert.Equal(t, nil, err)
+
+	msgs, err = db.GetMessages(ctx, []int{})
+	assert.Equal(t, []types.Message{}, msgs)
+	assert.Equal(t, nil, err)
+}
+
+// testListPages tests every Message is returned exactly once when following the pages
+func testListPages(t *testing.T, db database.Database) {
+	ctx := context.Background()
+	created := []types.Message{}
+	for i := 0; i < 7; i++ {
+		created = append(created, newMessage(fmt.Sprintf("message %d", i), false))
+	}
+	created = mustCreate(t, db, created...)
+
+	seen := []types.Message{}
+	opts := types.ListOptions{Limit: 3, Sort: types.SortById, Order: types.OrderAsc}
+	for {
+		page, err := db.ListMessages(ctx, opts)
+		assert.Equal(t, nil, err)
+		seen = append(seen, page...)
+
+		if len(page) < opts.Limit {
+			break
+		}
+		opts.After = &types.Cursor{Id: page[len(page)-1].Id}
+	}
+
+	assert.Equal(t, created, seen)
+}
+
+// testListSortAndOrder tests Messages are sorted by the column and direction, with the id breaking ties
+func testListSortAndOrder(t *testing.T, db database.Database) {
+	ctx := context.Background()
+	msgs := mustCreate(t, db, newMessage("b", true), newMessage("a", true), newMessage("c", true), newMessage("a", true))
+
+	list, err := db.ListMessages(ctx, types.ListOptions{Sort: types.SortById, Order: types.OrderDesc})
+	assert.Equal(t, []int{msgs[3].Id, msgs[2].Id, msgs[1].Id, msgs[0].Id}, ids(list))
+	assert.Equal(t, nil, err)
+
+	list, _ = db.ListMessages(ctx, types.ListOptions{Sort: types.SortByMessage, Order: types.OrderAsc})
+	assert.Equal(t, []int{msgs[1].Id, msgs[3].Id, msgs[0].Id, msgs[2].Id}, ids(list))
+
+	list, _ = db.ListMessages(ctx, types.ListOptions{Sort: types.SortByMessage, Order: types.OrderDesc})
+	assert.Equal(t, []int{msgs[2].Id, msgs[0].Id, msgs[3].Id, msgs[1].Id}, ids(list))
+
+	after := &types.Cursor{Id: msgs[1].Id, Value: "a"}
+	list, _ = db.ListMessages(ctx, types.ListOptions{Sort: types.SortByMessage, Order: types.OrderAsc, After: after})
+	assert.Equal(t, []int{msgs[3].Id, msgs[0].Id, msgs[2].Id}, ids(list))
+
+	after = &types.Cursor{Id: msgs[2].Id}
+	list, _ = db.ListMessages(ctx, types.ListOptions{Sort: types.SortById, Order: types.OrderDesc, After: after, Limit: 1})
+	assert.Equal(t, []int{msgs[1].Id}, ids(list))
+}
+
+// testListFilter tests only Messages matching the palindrome filter are returned
+func testListFilter(t *testing.T, db database.Database) {
+	ctx := context.Background()
+	msgs := mustCreate(t, db, newMessage("racecar", true), newMessage("test", false), newMessage("level", true))
+
+	isPalindrome := true
+	list, err := db.ListMessages(ctx, types.ListOptions{Sort: types.SortById, Order: types.OrderAsc, IsPalindrome: &isPalindrome})
+	assert.Equal(t, []int{msgs[0].Id, msgs[2].Id}, ids(list))
+	assert.Equal(t, nil, err)
+
+	isPalindrome = false
+	list, _ = db.ListMessages(ctx, types.ListOptions{Sort: types.SortById, Order: types.OrderAsc, IsPalindrome: &isPalindrome})
+	assert.Equal(t, []int{msgs[1].Id}, ids(list))
+}
+
+// testOwner tests the owner of a Message is stored, kept when it is updated and can be used to filter lists
+func testOwner(t *testing.T, db database.Database) {
+	ctx := context.Background()
+	other := newMessage("level", true)
+	other.Owner = "other"
+	msgs := mustCreate(t, db, newMessage("racecar", true), other, newMessage("test", false))
+
+	stored, err := db.GetMessage(ctx, msgs[1].Id)
+	assert.Equal(t, "other", stored.Owner)
+	assert.Equal(t, nil, err)
+
+	update := msgs[1]
+	update.Message = "refer"
+	update.Owner = ""
+	updated, err := db.UpdateMessage(ctx, update)
+	assert.Equal(t, "other", updated.Owner)
+	assert.Equal(t, nil, err)
+
+	stored, _ = db.GetMessage(ctx, msgs[1].Id)
+	assert.Equal(t, "other", stored.Owner)
+
+	list, err := db.ListMessages(ctx, types.ListOptions{Sort: types.SortById, Order: types.OrderAsc, Owner: "tester"})
+	assert.Equal(t, []int{msgs[0].Id, msgs[2].Id}, ids(list))
+	assert.Equal(t, nil, err)
+
+	isPalindrome := true
+	list, _ = db.ListMessages(ctx, types.ListOptions{Sort: types.SortById, Order: types.OrderAsc, Owner: "tester", IsPalindrome: &isPalindrome})
+	assert.Equal(t, []int{msgs[0].Id}, ids(list))
+
+	list, _ = db.ListMessages(ctx, types.ListOptions{Sort: types.SortById, Order: types.OrderAsc, Owner: "nobody"})
+	assert.Equal(t, []int{}, ids(list))
+}
+
+// testTenantIsolation tests Messages stored for one tenant cannot be read, listed, updated or deleted by another
+func testTenantIsolation(t *testing.T, db database.Database) {
+	teamA := types.WithTenant(context.Background(), "team-a")
+	teamB := types.WithTenant(context.Background(), "team-b")
+
+	msgA, err := db.CreateMessage(teamA, newMessage("racecar", true))
+	assert.Equal(t, nil, err)
+	msgB, err := db.CreateMessage(teamB, newMessage("level", true))
+	assert.Equal(t, nil, err)
+	msgDefault := mustCreate(t, db, newMessage("test", false))[0]
+
+	_, err = db.GetMessage(teamB, msgA.Id)
+	assert.True(t, errors.Is(err, types.ErrNotFound))
+
+	_, err = db.GetMessage(context.Background(), msgA.Id)
+	assert.True(t, errors.Is(err, types.ErrNotFound))
+
+	stored, err := db.GetMessage(teamA, msgA.Id)
+	assert.Equal(t, msgA, stored)
+	assert.Equal(t, nil, err)
+
+	opts := types.ListOptions{Sort: types.SortById, Order: types.OrderAsc}
+	list, _ := db.ListMessages(teamA, opts)
+	assert.Equal(t, []int{msgA.Id}, ids(list))
+
+	list, _ = db.ListMessages(teamB, opts)
+	assert.Equal(t, []int{msgB.Id}, ids(list))
+
+	list, _ = db.ListMessages(context.Background(), opts)
+	assert.Equal(t, []int{msgDefault.Id}, ids(list))
+
+	update := msgA
+	update.Message = "refer"
+	_, err = db.UpdateMessage(teamB, update)
+	assert.True(t, errors.Is(err, types.ErrNotFound))
+
+	err = db.DeleteMessage(teamB, msgA.Id, 0)
+	assert.True(t, errors.Is(err, types.ErrNotFound))
+
+	stored, _ = db.GetMessage(teamA, msgA.Id)
+	assert.Equal(t, msgA, stored)
+}
+
+// testCountMessages tests only the Messages of the tenant in the context are counted
+func testCountMessages(t *testing.T, db database.Database) {
+	teamA := types.WithTenant(context.Background(), "team-a")
+
+	count, err := db.CountMessages(teamA)
+	assert.Equal(t, 0, count)
+	assert.Equal(t, nil, err)
+
+	created, _ := db.CreateMessage(teamA, newMessage("racecar", true))
+	db.CreateMessage(teamA, newMessage("level", true))
+	mustCreate(t, db, newMessage("test", false))
+
+	count, _ = db.CountMessages(teamA)
+	assert.Equal(t, 2, count)
+
+	count, _ = db.CountMessages(context.Background())
+	assert.Equal(t, 1, count)
+
+	db.DeleteMessage(teamA, created.Id, 0)
+	count, _ = db.CountMessages(teamA)
+	assert.Equal(t, 1, count)
+}
+
+// testConcurrentWriters tests Messages created concurrently are all stored with unique ids
+func testConcurrentWriters(t *testing.T, db database.Database) {
+	ctx := context.Background()
+	writers, perWriter := 10, 10
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*perWriter)
+
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				if _, err := db.CreateMessage(ctx, newMessage(fmt.Sprintf("writer %d message %d", w, i), false)); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("concurrent create failed: %v", err)
+	}
+
+	list, err := db.ListMessages(ctx, types.ListOptions{Sort: types.SortById, Order: types.OrderAsc})
+	assert.Equal(t, nil, err)
+	assert.Len(t, list, writers*perWriter)
+
+	unique := map[int]bool{}
+	for _, msg := range list {
+		unique[msg.Id] = true
+	}
+	assert.Len(t, unique, writers*perWriter)
+}
+
+// testUnicodePayloads tests multi-byte Messages are stored without changes and limited by characters rather than bytes
+func testUnicodePayloads(t *testing.T, db database.Database) {
+	ctx := context.Background()
+	payloads := []string{
+		"été",
+		"日本語のメッセージ",
+		"👨‍👩‍👧 family",
+		"été",
+		"مرحبا بالعالم",
+		strings.Repeat("語", types.MaxMessageLength),
+	}
+
+	for _, payload := range payloads {
+		created, err := db.CreateMessage(ctx, newMessage(payload, false))
+		if !assert.Equal(t, nil, err, payload) {
+			continue
+		}
+
+		msg, err := db.GetMessage(ctx, created.Id)
+		assert.Equal(t, payload, msg.Message)
+		assert.Equal(t, nil, err)
+	}
+}
+
+// testMessageTooLong tests Messages longer than the column can hold are rejected as invalid
+func testMessageTooLong(t *testing.T, db database.Database) {
+	ctx := context.Background()
+
+	_, err := db.CreateMessage(ctx, newMessage(strings.Repeat("é", types.MaxMessageLength+1), false))
+	assert.True(t, errors.Is(err, types.ErrValidation), "create: expected validation error, got %v", err)
+
+	msg := mustCreate(t, db, newMessage("test", false))[0]
+	msg.Message = strings.Repeat("a", types.MaxMessageLength+1)
+
+	_, err = db.UpdateMessage(ctx, msg)
+	assert.True(t, errors.Is(err, types.ErrValidation), "update: expected validation error, got %v", err)
+}
+
+// testCancelledContext tests a cancelled context stops the call with an unavailable error
+func testCancelledContext(t *testing.T, db database.Database) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := db.ListMessages(ctx, types.ListOptions{})
+	assert.True(t, errors.Is(err, types.ErrUnavailable), "list: expected unavailable, got %v", err)
+
+	_, err = db.CreateMessage(ctx, newMessage("test", false))
+	assert.True(t, errors.Is(err, types.ErrUnavailable), "create: expected unavailable, got %v", err)
+
+	_, err = db.CountMessages(ctx)
+	assert.True(t, errors.Is(err, types.ErrUnavailable), "count: expected unavailable, got %v", err)
+
+	err = db.Ping(ctx)
+	assert.True(t, errors.Is(err, types.ErrUnavailable), "ping: expected unavailable, got %v", err)
+}
+
+// testEmptyList tests listing an empty database returns an empty slice rather than nil
+func testEmptyList(t *testing.T, db database.Database) {
+	list, err := db.ListMessages(context.Background(), types.ListOptions{})
+
+	assert.NotNil(t, list)
+	assert.Empty(t, list)
+	assert.Equal(t, nil, err)
+}
+
+// testReady tests a migrated database can be pinged and reports no pending migrations
+func testReady(t *testing.T, db database.Database) {
+	ctx := context.Background()
+
+	assert.Equal(t, nil, db.Ping(ctx))
+	assert.Equal(t, nil, db.CheckMigrations(ctx))
+}
+
+// newAPIKey creates an APIKey that has not been stored
+func newAPIKey(prefix string, scopes ...types.Scope) types.APIKey {
+	return types.APIKey{Tenant: types.DefaultTenant, Name: "key " + prefix, Prefix: prefix, Hash: strings.Repeat("a", 64), Scopes: scopes}
+}
+
+// testAPIKeys tests APIKeys can be created, found by prefix, listed and revoked
+func testAPIKeys(t *testing.T, db database.Database) {
+	ctx := context.Background()
+	before := time.Now().Add(-time.Second)
+
+	first, err := db.CreateAPIKey(ctx, newAPIKey("first", types.ScopeRead, types.ScopeWrite))
+	assert.Equal(t, nil, err)
+	assert.NotZero(t, first.Id)
+	assert.True(t, first.CreatedAt.After(before), "created at %v", first.CreatedAt)
+
+	second, err := db.CreateAPIKey(ctx, newAPIKey("second", types.ScopeAdmin))
+	assert.Equal(t, nil, err)
+	assert.Greater(t, second.Id, first.Id)
+
+	found, err := db.GetAPIKey(ctx, "first")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, first, found)
+
+	keys, err := db.ListAPIKeys(ctx)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []types.APIKey{first, second}, keys)
+
+	revoked, err := db.RevokeAPIKey(ctx, first.Id)
+	assert.Equal(t, nil, err)
+	assert.NotNil(t, revoked.RevokedAt)
+	assert.Equal(t, []types.Scope{types.ScopeRead, types.ScopeWrite}, revoked.Scopes)
+
+	again, err := db.RevokeAPIKey(ctx, first.Id)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, revoked, again)
+
+	found, _ = db.GetAPIKey(ctx, "first")
+	assert.Equal(t, revoked, found)
+}
+
+// testAPIKeyTenant tests APIKeys are found by prefix in any tenant but only listed and revoked in their own tenant
+func testAPIKeyTenant(t *testing.T, db database.Database) {
+	ctx := context.Background()
+	other := types.WithTenant(ctx, "team-b")
+
+	key := newAPIKey("team-b", types.ScopeRead)
+	key.Tenant = "team-b"
+	created, err := db.CreateAPIKey(ctx, key)
+	assert.Equal(t, nil, err)
+
+	found, err := db.GetAPIKey(ctx, "team-b")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "team-b", found.Tenant)
+
+	keys, _ := db.ListAPIKeys(ctx)
+	assert.Equal(t, []types.APIKey{}, keys)
+
+	_, err = db.RevokeAPIKey(ctx, created.Id)
+	assert.True(t, errors.Is(err, types.ErrNotFound), "revoke: expected not found, got %v", err)
+
+	keys, _ = db.ListAPIKeys(other)
+	assert.Equal(t, []types.APIKey{created}, keys)
+
+	revoked, err := db.RevokeAPIKey(other, created.Id)
+	assert.Equal(t, nil, err)
+	assert.NotNil(t, revoked.RevokedAt)
+}
+
+// testAPIKeyNotFound tests finding or revoking a missing APIKey returns a not found error
+func testAPIKeyNotFound(t *testing.T, db database.Database) {
+	ctx := context.Background()
+
+	_, err := db.GetAPIKey(ctx, "missing")
+	assert.True(t, errors.Is(err, types.ErrNotFound), "get: expected not found, got %v", err)
+
+	_, err = db.RevokeAPIKey(ctx, 1000)
+	assert.True(t, errors.Is(err, types.ErrNotFound), "revoke: expected not found, got %v", err)
+}
+
+// testAPIKeyDuplicatePrefix tests a prefix can only be used by one APIKey
+func testAPIKeyDuplicatePrefix(t *testing.T, db database.Database) {
+	ctx := context.Background()
+
+	_, err := db.CreateAPIKey(ctx, newAPIKey("duplicate", types.ScopeRead))
+	assert.Equal(t, nil, err)
+
+	_, err = db.CreateAPIKey(ctx, newAPIKey("duplicate", types.ScopeRead))
+	assert.True(t, errors.Is(err, types.ErrConflict), "expected conflict, got %v", err)
+}
+
+// testIdempotencyRecords tests a key can only be reserved once per tenant and caller, and its response is stored with
+// a new expiry
+func testIdempotencyRecords(t *testing.T, db database.Database) {
+	ctx := context.Background()
+	other := types.WithTenant(ctx, "team-b")
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	record := types.IdempotencyRecord{Key: "key-1", Caller: "user-1", RequestHash: "hash", ExpiresAt: expires}
+
+	assert.Equal(t, nil, db.CreateIdempotencyRecord(ctx, record))
+
+	err := db.CreateIdempotencyRecord(ctx, record)
+	assert.True(t, errors.Is(err, types.ErrConflict), "expected conflict, got %v", err)
+
+	assert.Equal(t, nil, db.CreateIdempotencyRecord(other, record))
+	assert.Equal(t, nil, db.CreateIdempotencyRecord(ctx, types.IdempotencyRecord{Key: "key-1", Caller: "user-2", RequestHash: "hash", ExpiresAt: expires}))
+
+	pending, err := db.GetIdempotencyRecord(ctx, "user-1", "key-1")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, types.IdempotencyRecord{Key: "key-1", Caller: "user-1", RequestHash: "hash", Headers: map[string]string{}, ExpiresAt: expires}, pending)
+
+	record.Status = 201
+	record.Headers = map[string]string{"Content-Type": "application/json", "ETag": `"1"`}
+	record.Body = []byte(`{"id":1}`)
+	record.ExpiresAt = expires.Add(time.Hour)
+	assert.Equal(t, nil, db.CompleteIdempotencyRecord(ctx, record))
+
+	completed, err := db.GetIdempotencyRecord(ctx, "user-1", "key-1")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, record, completed)
+
+	stored, _ := db.GetIdempotencyRecord(other, "user-1", "key-1")
+	assert.Equal(t, 0, stored.Status)
+
+	assert.Equal(t, nil, db.DeleteIdempotencyRecord(ctx, "user-1", "key-1"))
+
+	_, err = db.GetIdempotencyRecord(ctx, "user-1", "key-1")
+	assert.True(t, errors.Is(err, types.ErrNotFound), "get: expected not found, got %v", err)
+
+	err = db.DeleteIdempotencyRecord(ctx, "user-1", "key-1")
+	assert.True(t, errors.Is(err, types.ErrNotFound), "delete: expected not found, got %v", err)
+
+	err = db.CompleteIdempotencyRecord(ctx, record)
+	assert.True(t, errors.Is(err, types.ErrNotFound), "complete: expected not found, got %v", err)
+}
+
+// testIdempotencyExpiry tests an expired key is not returned and can be reserved again
+func testIdempotencyExpiry(t *testing.T, db database.Database) {
+	ctx := context.Background()
+	record := types.IdempotencyRecord{Key: "key-1", Caller: "user-1", RequestHash: "old", ExpiresAt: time.Now().Add(-time.Minute)}
+
+	assert.Equal(t, nil, db.CreateIdempotencyRecord(ctx, record))
+
+	_, err := db.GetIdempotencyRecord(ctx, "user-1", "key-1")
+	assert.True(t, errors.Is(err, types.ErrNotFound), "expected not found, got %v", err)
+
+	record.RequestHash = "new"
+	record.ExpiresAt = time.Now().Add(time.Hour)
+	assert.Equal(t, nil, db.CreateIdempotencyRecord(ctx, record))
+
+	stored, err := db.GetIdempotencyRecord(ctx, "user-1", "key-1")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "new", stored.RequestHash)
+}
+
+// testBatch tests every create, update and delete in a batch is made and the results are returned in order
+func testBatch(t *testing.T, db database.Database) {
+	ctx := context.Background()
+	existing := mustCreate(t, db, newMessage("test", false), newMessage("kayak", true))
+
+	update := existing[0]
+	update.Message = "level"
+	update.IsPalindrome = true
+
+	ops := []types.BatchOperation{
+		{Op: types.BatchCreate, Message: newMessage("racecar", true)},
+		{Op: types.BatchUpdate, Message: update},
+		{Op: types.BatchDelete, Message: types.Message{Id: existing[1].Id, Version: 1}},
+		{Op: types.BatchCreate, Message: newMessage("civic", true)},
+	}
+
+	msgs, err := db.ApplyBatch(ctx, ops)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 4, len(msgs))
+
+	assert.Less(t, existing[1].Id, msgs[0].Id)
+	assert.Less(t, msgs[0].Id, msgs[3].Id)
+	assert.Equal(t, 1, msgs[0].Version)
+	assert.Equal(t, "tester", msgs[0].Owner)
+
+	update.Version = 2
+	assert.Equal(t, update, msgs[1])
+	assert.Equal(t, existing[1].Id, msgs[2].Id)
+
+	for _, index := range []int{0, 1, 3} {
+		stored, err := db.GetMessage(ctx, msgs[index].Id)
+		assert.Equal(t, msgs[index], stored)
+		assert.Equal(t, nil, err)
+	}
+
+	_, err = db.GetMessage(ctx, existing[1].Id)
+	assert.True(t, errors.Is(err, types.ErrNotFound), "expected not found, got %v", err)
+}
+
+// testBatchRollback tests no change in a batch is made when one of them fails and the failure is reported by position
+func testBatchRollback(t *testing.T, db database.Database) {
+	ctx := context.Background()
+	existing := mustCreate(t, db, newMessage("test", false), newMessage("kayak", true))
+
+	tests := []struct {
+		name  string
+		fail  types.BatchOperation
+		cause error
+	}{
+		{"missing", types.BatchOperation{Op: types.BatchDelete, Message: types.Message{Id: 404}}, types.ErrNotFound},
+		{"stale", types.BatchOperation{Op: types.BatchUpdate, Message: types.Message{Id: existing[1].Id, Message: "level", PalindromeMode: types.PalindromeExact, Version: 2}}, types.ErrPrecondition},
+		{"too long", types.BatchOperation{Op: types.BatchUpdate, Message: types.Message{Id: existing[1].Id, Message: strings.Repeat("a", types.MaxMessageLength+1), PalindromeMode: types.PalindromeExact}}, types.ErrValidation},
+		{"create too long", types.BatchOperation{Op: types.BatchCreate, Message: types.Message{Message: strings.Repeat("a", types.MaxMessageLength+1), PalindromeMode: types.PalindromeExact}}, types.ErrValidation},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops := []types.BatchOperation{
+				{Op: types.BatchCreate, Message: newMessage("racecar", true)},
+				{Op: types.BatchDelete, Message: types.Message{Id: existing[0].Id}},
+				tt.fail,
+			}
+
+			_, err := db.ApplyBatch(ctx, ops)
+
+			var batchErr *types.BatchError
+			assert.True(t, errors.As(err, &batchErr), "expected batch error, got %v", err)
+			if batchErr != nil {
+				assert.Equal(t, 2, batchErr.Index)
+			}
+			assert.True(t, errors.Is(err, tt.cause), "expected %v, got %v", tt.cause, err)
+
+			count, _ := db.CountMessages(ctx)
+			assert.Equal(t, 2, count)
+
+			stored, err := db.GetMessage(ctx, existing[0].Id)
+			assert.Equal(t, existing[0], stored)
+			assert.Equal(t, nil, err)
+		})
+	}
+}
+
+// testBatchTenant tests a batch only creates and changes Messages for the tenant in the context
+func testBatchTenant(t *testing.T, db database.Database) {
+	tenantA := types.WithTenant(context.Background(), "tenant-a")
+	tenantB := types.WithTenant(context.Background(), "tenant-b")
+
+	msgs, err := db.ApplyBatch(tenantA, []types.BatchOperation{{Op: types.BatchCreate, Message: newMessage("racecar", true)}})
+	assert.Equal(t, nil, err)
+
+	_, err = db.GetMessage(tenantB, msgs[0].Id)
+	assert.True(t, errors.Is(err, types.ErrNotFound), "expected not found, got %v", err)
+
+	_, err = db.ApplyBatch(tenantB, []types.BatchOperation{{Op: types.BatchDelete, Message: types.Message{Id: msgs[0].Id}}})
+	assert.True(t, errors.Is(err, types.ErrNotFound), "expected not found, got %v", err)
+
+	stored, err := db.GetMessage(tenantA, msgs[0].Id)
+	assert.Equal(t, msgs[0], stored)
+	assert.Equal(t, nil, err)
+}
+
+// testTxCommit tests the changes made in a transaction can be read in it and are stored once it commits
+func testTxCommit(t *testing.T, db database.Database) {
+	ctx := context.Background()
+	existing := mustCreate(t, db, newMessage("test", false))
+
+	var created types.Message
+	err := db.WithTx(ctx, func(tx database.Database) (err error) {
+		created, err = tx.CreateMessage(ctx, newMessage("racecar", true))
+		if err != nil {
+			return err
+		}
+
+		if err := tx.DeleteMessage(ctx, existing[0].Id, 0); err != nil {
+			return err
+		}
+
+		stored, err := tx.GetMessage(ctx, created.Id)
+		assert.Equal(t, created, stored)
+
+		count, _ := tx.CountMessages(ctx)
+		assert.Equal(t, 1, count)
+
+		return err
+	})
+	assert.Equal(t, nil, err)
+
+	stored, err := db.GetMessage(ctx, created.Id)
+	assert.Equal(t, created, stored)
+	assert.Equal(t, nil, err)
+
+	_, err = db.GetMessage(ctx, existing[0].Id)
+	assert.True(t, errors.Is(err, types.ErrNotFound), "expected not found, got %v", err)
+}
+
+// testTxRollback tests none of the changes made in a transaction are stored when it returns an error
+func testTxRollback(t *testing.T, db database.Database) {
+	ctx := context.Background()
+	existing := mustCreate(t, db, newMessage("test", false), newMessage("kayak", true))
+	cause := errors.New("audit failed")
+
+	err := db.WithTx(ctx, func(tx database.Database) error {
+		if _, err := tx.CreateMessage(ctx, newMessage("racecar", true)); err != nil {
+			return err
+		}
+
+		if _, err := tx.UpdateMessage(ctx, types.Message{Id: existing[1].Id, Message: "level", IsPalindrome: true, PalindromeMode: types.PalindromeExact}); err != nil {
+			return err
+		}
+
+		if err := tx.DeleteMessage(ctx, existing[0].Id, 0); err != nil {
+			return err
+		}
+
+		return cause
+	})
+	assert.Equal(t, cause, err)
+
+	msgs, err := db.ListMessages(ctx, types.ListOptions{})
+	assert.Equal(t, existing, msgs)
+	assert.Equal(t, nil, err)
+}
+
+// testTxNested tests a failed transaction started inside another only rolls back its own changes
+func testTxNested(t *testing.T, db database.Database) {
+	ctx := context.Background()
+
+	err := db.WithTx(ctx, func(tx database.Database) error {
+		if _, err := tx.CreateMessage(ctx, newMessage("racecar", true)); err != nil {
+			return err
+		}
+
+		err := tx.WithTx(ctx, func(inner database.Database) error {
+			if _, err := inner.CreateMessage(ctx, newMessage("kayak", true)); err != nil {
+				return err
+			}
+			return types.ErrConflict
+		})
+		assert.True(t, errors.Is(err, types.ErrConflict), "expected conflict, got %v", err)
+
+		return nil
+	})
+	assert.Equal(t, nil, err)
+
+	msgs, err := db.ListMessages(ctx, types.ListOptions{})
+	assert.Equal(t, []string{"racecar"}, messageTexts(msgs))
+	assert.Equal(t, nil, err)
+}
+
+// messageTexts returns the text of each Message in order
+func messageTexts(msgs []types.Message) []string {
+	texts := make([]string, len(msgs))
+	for i, msg := range msgs {
+		texts[i] = msg.Message
+	}
+
+	return texts
+}
@@ -0,0 +1,132 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"messageApi/internal/types"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// idempotencyColumns are the columns selected for an IdempotencyRecord, in the order scanIdempotencyRecord expects them
+const idempotencyColumns = "key, caller, request_hash, status, headers, body, expires_at"
+
+// CreateIdempotencyRecord will INSERT the IdempotencyRecord for the tenant, returning a conflict error when the key is already used
+// Expired records are removed first so their keys can be used again and the table does not keep growing
+func (d *database) CreateIdempotencyRecord(ctx context.Context, record types.IdempotencyRecord) error {
+	if _, err := d.conn.Exec(ctx, "DELETE FROM public.idempotency_keys WHERE expires_at <= @now", pgx.NamedArgs{"now": now()}); err != nil {
+		return wrapError(err)
+	}
+
+	args := pgx.NamedArgs{
+		"tenant":       types.TenantFromContext(ctx),
+		"caller":       record.Caller,
+		"key":          record.Key,
+		"request_hash": record.RequestHash,
+		"expires_at":   record.ExpiresAt,
+	}
+
+	_, err := d.conn.Exec(ctx, "INSERT INTO public.idempotency_keys (tenant, caller, key, request_hash, expires_at) VALUES(@tenant, @caller, @key, @request_hash, @expires_at)", args)
+
+	return wrapError(err)
+}
+
+// GetIdempotencyRecord returns the IdempotencyRecord for the caller's key in the tenant when it has not expired
+func (d *database) GetIdempotencyRecord(ctx context.Context, caller string, key string) (types.IdempotencyRecord, error) {
+	args := pgx.NamedArgs{
+		"tenant": types.TenantFromContext(ctx),
+		"caller": caller,
+		"key":    key,
+		"now":    now(),
+	}
+	row := d.conn.QueryRow(ctx, "SELECT "+idempotencyColumns+" FROM public.idempotency_keys WHERE tenant = @tenant AND caller = @caller AND key = @key AND expires_at > @now", args)
+
+	record, err := scanIdempotencyRecord(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return types.IdempotencyRecord{}, idempotencyKeyNotFoundError(key)
+	} else if err != nil {
+		return types.IdempotencyRecord{}, wrapError(err)
+	}
+
+	return record, nil
+}
+
+// CompleteIdempotencyRecord stores the response of the IdempotencyRecord so it can be replayed until it expires
+func (d *database) CompleteIdempotencyRecord(ctx context.Context, record types.IdempotencyRecord) error {
+	args := pgx.NamedArgs{
+		"tenant":     types.TenantFromContext(ctx),
+		"caller":     record.Caller,
+		"key":        record.Key,
+		"status":     record.Status,
+		"headers":    encodeHeaders(record.Headers),
+		"body":       record.Body,
+		"expires_at": record.ExpiresAt,
+	}
+
+	cmd, err := d.conn.Exec(ctx, "UPDATE public.idempotency_keys SET status = @status, headers = @headers, body = @body, expires_at = @expires_at WHERE tenant = @tenant AND caller = @caller AND key = @key", args)
+	if err != nil {
+		return wrapError(err)
+	} else if cmd.RowsAffected() == 0 {
+		return idempotencyKeyNotFoundError(record.Key)
+	}
+
+	return nil
+}
+
+// DeleteIdempotencyRecord removes the IdempotencyRecord for the caller's key in the tenant so the key can be used again
+func (d *database) DeleteIdempotencyRecord(ctx context.Context, caller string, key string) error {
+	args := pgx.NamedArgs{
+		"tenant": types.TenantFromContext(ctx),
+		"caller": caller,
+		"key":    key,
+	}
+
+	cmd, err := d.conn.Exec(ctx, "DELETE FROM public.idempotency_keys WHERE tenant = @tenant AND caller = @caller AND key = @key", args)
+	if err != nil {
+		return wrapError(err)
+	} else if cmd.RowsAffected() == 0 {
+		return idempotencyKeyNotFoundError(key)
+	}
+
+	return nil
+}
+
+// scanIdempotencyRecord scans the idempotencyColumns of a row from either database into an IdempotencyRecord
+func scanIdempotencyRecord(row scanner) (types.IdempotencyRecord, error) {
+	var record types.IdempotencyRecord
+	var headers string
+
+	if err := row.Scan(&record.Key, &record.Caller, &record.RequestHash, &record.Status, &headers, &record.Body, &record.ExpiresAt); err != nil {
+		return types.IdempotencyRecord{}, err
+	}
+	record.Headers = decodeHeaders(headers)
+	record.ExpiresAt = record.ExpiresAt.UTC()
+
+	return record, nil
+}
+
+// encodeHeaders stores the response headers as a JSON object so every database can hold them in a text column
+func encodeHeaders(headers map[string]string) string {
+	if len(headers) == 0 {
+		return "{}"
+	}
+
+	data, _ := json.Marshal(headers)
+
+	return string(data)
+}
+
+// decodeHeaders reads response headers stored by encodeHeaders
+func decodeHeaders(value string) map[string]string {
+	headers := map[string]string{}
+	json.Unmarshal([]byte(value), &headers)
+
+	return headers
+}
+
+// idempotencyKeyNotFoundError returns the error used when no IdempotencyRecord exists for the key
+func idempotencyKeyNotFoundError(key string) error {
+	return fmt.Errorf("idempotency key %q %w", key, types.ErrNotFound)
+}
@@ -0,0 +1,498 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"messageApi/internal/types"
+	"slices"
+	"sort"
+	"sync"
+	"unicode/utf8"
+)
+
+// memoryDatabase is an in-memory implementation of the data module for local development and testing
+// It is safe for concurrent use and matches the behavior of the Postgres implementation
+type memoryDatabase struct {
+	mu rwLocker
+	*memoryState
+}
+
+// rwLocker is implemented by sync.RWMutex, and by noLock for a memoryDatabase in a transaction that already holds the lock
+type rwLocker interface {
+	Lock()
+	Unlock()
+	RLock()
+	RUnlock()
+}
+
+// noLock is the rwLocker of a memoryDatabase in a transaction, as the transaction holds the lock until it ends
+type noLock struct{}
+
+func (noLock) Lock()    {}
+func (noLock) Unlock()  {}
+func (noLock) RLock()   {}
+func (noLock) RUnlock() {}
+
+// memoryState holds everything stored by a memoryDatabase, shared with the memoryDatabase given to a transaction
+type memoryState struct {
+	lastId    int
+	messages  map[int]types.Message
+	tenants   map[int]string
+	lastKeyId int
+	apiKeys   map[int]types.APIKey
+	records   map[idempotencyId]types.IdempotencyRecord
+}
+
+// idempotencyId identifies an IdempotencyRecord by the tenant, caller and key it was stored for
+type idempotencyId struct {
+	tenant string
+	caller string
+	key    string
+}
+
+// NewMemoryDatabase creates an empty in-memory instance of the data module
+func NewMemoryDatabase() Database {
+	return &memoryDatabase{
+		mu: &sync.RWMutex{},
+		memoryState: &memoryState{
+			messages: map[int]types.Message{},
+			tenants:  map[int]string{},
+			apiKeys:  map[int]types.APIKey{},
+			records:  map[idempotencyId]types.IdempotencyRecord{},
+		},
+	}
+}
+
+// CreateMessage stores the Message with the next id
+func (d *memoryDatabase) CreateMessage(ctx context.Context, msg types.Message) (types.Message, error) {
+	if err := contextError(ctx); err != nil {
+		return types.Message{}, err
+	}
+
+	if err := checkMessageLength(msg); err != nil {
+		return types.Message{}, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.lastId++
+	msg.Id = d.lastId
+	msg.Version = 1
+	d.messages[msg.Id] = msg
+	d.tenants[msg.Id] = types.TenantFromContext(ctx)
+
+	return msg, nil
+}
+
+// GetMessage returns a single stored Message
+func (d *memoryDatabase) GetMessage(ctx context.Context, id int) (types.Message, error) {
+	if err := contextError(ctx); err != nil {
+		return types.Message{}, err
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	msg, ok := d.lookup(ctx, id)
+	if !ok {
+		return types.Message{}, notFoundError(id)
+	}
+
+	return msg, nil
+}
+
+// GetMessages returns the stored Messages with the ids ordered by id, leaving out ids that are not stored
+func (d *memoryDatabase) GetMessages(ctx context.Context, ids []int) ([]types.Message, error) {
+	if err := contextError(ctx); err != nil {
+		return []types.Message{}, err
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	ids = slices.Clone(ids)
+	slices.Sort(ids)
+
+	msgs := []types.Message{}
+	for _, id := range slices.Compact(ids) {
+		if msg, ok := d.lookup(ctx, id); ok {
+			msgs = append(msgs, msg)
+		}
+	}
+
+	return msgs, nil
+}
+
+// ListMessages returns a single page of stored Messages matching the list options
+func (d *memoryDatabase) ListMessages(ctx context.Context, opts types.ListOptions) ([]types.Message, error) {
+	if err := contextError(ctx); err != nil {
+		return []types.Message{}, err
+	}
+
+	tenant := types.TenantFromContext(ctx)
+
+	d.mu.RLock()
+	msgs := make([]types.Message, 0, len(d.messages))
+	for id, msg := range d.messages {
+		if d.tenants[id] == tenant && (opts.IsPalindrome == nil || msg.IsPalindrome == *opts.IsPalindrome) && (opts.Owner == "" || msg.Owner == opts.Owner) {
+			msgs = append(msgs, msg)
+		}
+	}
+	d.mu.RUnlock()
+
+	less := func(a types.Message, b types.Message) bool {
+		if opts.Sort == types.SortByMessage && a.Message != b.Message {
+			return a.Message < b.Message
+		}
+		return a.Id < b.Id
+	}
+
+	if opts.Order == types.OrderDesc {
+		ascending := less
+		less = func(a types.Message, b types.Message) bool { return ascending(b, a) }
+	}
+
+	sort.Slice(msgs, func(i, j int) bool { return less(msgs[i], msgs[j]) })
+
+	if opts.After != nil {
+		after := types.Message{Id: opts.After.Id, Message: opts.After.Value}
+		start := sort.Search(len(msgs), func(i int) bool { return less(after, msgs[i]) })
+		msgs = msgs[start:]
+	}
+
+	if opts.Limit > 0 && len(msgs) > opts.Limit {
+		msgs = msgs[:opts.Limit]
+	}
+
+	return msgs, nil
+}
+
+// UpdateMessage replaces an existing stored Message, keeping its owner and increasing its version
+func (d *memoryDatabase) UpdateMessage(ctx context.Context, msg types.Message) (types.Message, error) {
+	if err := contextError(ctx); err != nil {
+		return types.Message{}, err
+	}
+
+	if err := checkMessageLength(msg); err != nil {
+		return types.Message{}, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	existing, ok := d.lookup(ctx, msg.Id)
+	if !ok {
+		return types.Message{}, notFoundError(msg.Id)
+	}
+
+	if msg.Version > 0 && msg.Version != existing.Version {
+		return types.Message{}, preconditionError(existing, msg.Version)
+	}
+
+	msg.Owner = existing.Owner
+	msg.Version = existing.Version + 1
+	d.messages[msg.Id] = msg
+
+	return msg, nil
+}
+
+// DeleteMessage removes an existing stored Message
+func (d *memoryDatabase) DeleteMessage(ctx context.Context, id int, version int) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	existing, ok := d.lookup(ctx, id)
+	if !ok {
+		return notFoundError(id)
+	}
+
+	if version > 0 && version != existing.Version {
+		return preconditionError(existing, version)
+	}
+
+	delete(d.messages, id)
+	delete(d.tenants, id)
+
+	return nil
+}
+
+// ApplyBatch makes every change in the batch in a single transaction, so either all of them are made or none are
+func (d *memoryDatabase) ApplyBatch(ctx context.Context, ops []types.BatchOperation) ([]types.Message, error) {
+	var msgs []types.Message
+	err := d.WithTx(ctx, func(tx Database) (err error) {
+		msgs, err = applyOperations(ctx, tx, ops)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return msgs, nil
+}
+
+// WithTx runs fn with a Database holding the lock until fn returns, restoring everything stored when it returns an error
+// Ids taken by creates that were undone are not used again, as with the Postgres id sequences. fn must only use the
+// Database it is given, as using the original would wait for the lock forever. Calling WithTx in fn only restores the
+// changes made by the inner function when it fails
+func (d *memoryDatabase) WithTx(ctx context.Context, fn func(Database) error) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	messages, tenants, apiKeys, records := maps.Clone(d.messages), maps.Clone(d.tenants), maps.Clone(d.apiKeys), maps.Clone(d.records)
+
+	if err := fn(&memoryDatabase{mu: noLock{}, memoryState: d.memoryState}); err != nil {
+		d.messages, d.tenants, d.apiKeys, d.records = messages, tenants, apiKeys, records
+		return err
+	}
+
+	return nil
+}
+
+// CountMessages returns how many Messages the tenant has stored
+func (d *memoryDatabase) CountMessages(ctx context.Context) (int, error) {
+	if err := contextError(ctx); err != nil {
+		return 0, err
+	}
+
+	tenant := types.TenantFromContext(ctx)
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	count := 0
+	for id := range d.messages {
+		if d.tenants[id] == tenant {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// lookup returns the stored Message with the id when it belongs to the tenant in the context
+// The lock must be held by the caller
+func (d *memoryDatabase) lookup(ctx context.Context, id int) (types.Message, bool) {
+	msg, ok := d.messages[id]
+	if !ok || d.tenants[id] != types.TenantFromContext(ctx) {
+		return types.Message{}, false
+	}
+
+	return msg, true
+}
+
+// CreateAPIKey stores the APIKey with the next id, rejecting a prefix that is already used
+func (d *memoryDatabase) CreateAPIKey(ctx context.Context, key types.APIKey) (types.APIKey, error) {
+	if err := contextError(ctx); err != nil {
+		return types.APIKey{}, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, existing := range d.apiKeys {
+		if existing.Prefix == key.Prefix {
+			return types.APIKey{}, fmt.Errorf("%w: api key prefix %q is already used", types.ErrConflict, key.Prefix)
+		}
+	}
+
+	d.lastKeyId++
+	key.Id = d.lastKeyId
+	key.CreatedAt = now()
+	key.Scopes = slices.Clone(key.Scopes)
+	d.apiKeys[key.Id] = key
+
+	return key, nil
+}
+
+// GetAPIKey returns the stored APIKey with the prefix in any tenant, including revoked keys
+func (d *memoryDatabase) GetAPIKey(ctx context.Context, prefix string) (types.APIKey, error) {
+	if err := contextError(ctx); err != nil {
+		return types.APIKey{}, err
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	for _, key := range d.apiKeys {
+		if key.Prefix == prefix {
+			return key, nil
+		}
+	}
+
+	return types.APIKey{}, apiKeyNotFoundError(prefix)
+}
+
+// ListAPIKeys returns every stored APIKey for the tenant ordered by id
+func (d *memoryDatabase) ListAPIKeys(ctx context.Context) ([]types.APIKey, error) {
+	if err := contextError(ctx); err != nil {
+		return []types.APIKey{}, err
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	tenant := types.TenantFromContext(ctx)
+	keys := []types.APIKey{}
+	for _, key := range d.apiKeys {
+		if key.Tenant == tenant {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Id < keys[j].Id })
+
+	return keys, nil
+}
+
+// RevokeAPIKey marks the stored APIKey for the tenant as revoked, keeping the original time when it was already revoked
+func (d *memoryDatabase) RevokeAPIKey(ctx context.Context, id int) (types.APIKey, error) {
+	if err := contextError(ctx); err != nil {
+		return types.APIKey{}, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key, ok := d.apiKeys[id]
+	if !ok || key.Tenant != types.TenantFromContext(ctx) {
+		return types.APIKey{}, apiKeyIdNotFoundError(id)
+	}
+
+	if key.RevokedAt == nil {
+		revokedAt := now()
+		key.RevokedAt = &revokedAt
+		d.apiKeys[id] = key
+	}
+
+	return key, nil
+}
+
+// CreateIdempotencyRecord stores the IdempotencyRecord for the tenant, returning a conflict error when the key is already used
+// Expired records are removed first so their keys can be used again
+func (d *memoryDatabase) CreateIdempotencyRecord(ctx context.Context, record types.IdempotencyRecord) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	current := now()
+	for id, existing := range d.records {
+		if !existing.ExpiresAt.After(current) {
+			delete(d.records, id)
+		}
+	}
+
+	id := idempotencyId{types.TenantFromContext(ctx), record.Caller, record.Key}
+	if _, ok := d.records[id]; ok {
+		return fmt.Errorf("%w: idempotency key %q is already used", types.ErrConflict, record.Key)
+	}
+
+	d.records[id] = types.IdempotencyRecord{Key: record.Key, Caller: record.Caller, RequestHash: record.RequestHash, Headers: map[string]string{}, ExpiresAt: record.ExpiresAt.UTC()}
+
+	return nil
+}
+
+// GetIdempotencyRecord returns the stored IdempotencyRecord for the caller's key in the tenant when it has not expired
+func (d *memoryDatabase) GetIdempotencyRecord(ctx context.Context, caller string, key string) (types.IdempotencyRecord, error) {
+	if err := contextError(ctx); err != nil {
+		return types.IdempotencyRecord{}, err
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	record, ok := d.records[idempotencyId{types.TenantFromContext(ctx), caller, key}]
+	if !ok || !record.ExpiresAt.After(now()) {
+		return types.IdempotencyRecord{}, idempotencyKeyNotFoundError(key)
+	}
+
+	return record, nil
+}
+
+// CompleteIdempotencyRecord stores the response of the IdempotencyRecord so it can be replayed until it expires
+func (d *memoryDatabase) CompleteIdempotencyRecord(ctx context.Context, record types.IdempotencyRecord) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	id := idempotencyId{types.TenantFromContext(ctx), record.Caller, record.Key}
+	existing, ok := d.records[id]
+	if !ok {
+		return idempotencyKeyNotFoundError(record.Key)
+	}
+
+	existing.Status = record.Status
+	existing.Headers = map[string]string{}
+	maps.Copy(existing.Headers, record.Headers)
+	existing.Body = slices.Clone(record.Body)
+	existing.ExpiresAt = record.ExpiresAt.UTC()
+	d.records[id] = existing
+
+	return nil
+}
+
+// DeleteIdempotencyRecord removes the stored IdempotencyRecord for the caller's key in the tenant so the key can be used again
+func (d *memoryDatabase) DeleteIdempotencyRecord(ctx context.Context, caller string, key string) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	id := idempotencyId{types.TenantFromContext(ctx), caller, key}
+	if _, ok := d.records[id]; !ok {
+		return idempotencyKeyNotFoundError(key)
+	}
+
+	delete(d.records, id)
+
+	return nil
+}
+
+// Ping checks the context is still valid as there is no connection to check
+func (d *memoryDatabase) Ping(ctx context.Context) error {
+	return contextError(ctx)
+}
+
+// CheckMigrations does nothing as Messages kept in memory have no schema
+func (d *memoryDatabase) CheckMigrations(ctx context.Context) error {
+	return nil
+}
+
+// Close does nothing as there are no connections to release
+func (d *memoryDatabase) Close() error {
+	return nil
+}
+
+// contextError returns an unavailable error when the context has been cancelled or passed its deadline
+func contextError(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%w: %v", types.ErrUnavailable, err)
+	}
+
+	return nil
+}
+
+// checkMessageLength rejects Messages longer than the database column, as Postgres would
+func checkMessageLength(msg types.Message) error {
+	if utf8.RuneCountInString(msg.Message) > types.MaxMessageLength {
+		return fmt.Errorf("%w: message is longer than %d characters", types.ErrValidation, types.MaxMessageLength)
+	}
+
+	return nil
+}
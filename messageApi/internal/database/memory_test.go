@@ -0,0 +1,144 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"messageApi/internal/logging"
+	"messageApi/internal/types"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMemoryCreateAndGetMessage tests created Messages are given increasing ids and can be retrieved
+func TestMemoryCreateAndGetMessage(t *testing.T) {
+	db := NewMemoryDatabase()
+	ctx := context.Background()
+
+	first, err := db.CreateMessage(ctx, types.Message{Message: "racecar", IsPalindrome: true})
+	assert.Equal(t, nil, err)
+	second, _ := db.CreateMessage(ctx, types.Message{Message: "test"})
+
+	assert.Equal(t, 1, first.Id)
+	assert.Equal(t, 2, second.Id)
+
+	msg, err := db.GetMessage(ctx, first.Id)
+
+	assert.Equal(t, first, msg)
+	assert.Equal(t, nil, err)
+}
+
+// TestMemoryNotFound tests a not found error is returned for ids that do not exist
+func TestMemoryNotFound(t *testing.T) {
+	db := NewMemoryDatabase()
+	ctx := context.Background()
+
+	_, err := db.GetMessage(ctx, 1)
+	assert.Equal(t, notFoundError(1), err)
+
+	_, err = db.UpdateMessage(ctx, types.Message{Id: 1, Message: "test"})
+	assert.Equal(t, notFoundError(1), err)
+
+	err = db.DeleteMessage(ctx, 1, 0)
+	assert.Equal(t, notFoundError(1), err)
+}
+
+// TestMemoryUpdateAndDeleteMessage tests stored Messages can be replaced and removed
+func TestMemoryUpdateAndDeleteMessage(t *testing.T) {
+	db := NewMemoryDatabase()
+	ctx := context.Background()
+	msg, _ := db.CreateMessage(ctx, types.Message{Message: "test"})
+
+	msg.Message = "racecar"
+	updated, err := db.UpdateMessage(ctx, msg)
+	msg.Version = 2
+	assert.Equal(t, msg, updated)
+	assert.Equal(t, nil, err)
+
+	stored, _ := db.GetMessage(ctx, msg.Id)
+	assert.Equal(t, "racecar", stored.Message)
+
+	assert.Equal(t, nil, db.DeleteMessage(ctx, msg.Id, 0))
+
+	_, err = db.GetMessage(ctx, msg.Id)
+	assert.True(t, errors.Is(err, types.ErrNotFound))
+}
+
+// TestMemoryListMessages tests Messages are filtered, sorted and paged like the Postgres query
+func TestMemoryListMessages(t *testing.T) {
+	db := NewMemoryDatabase()
+	ctx := context.Background()
+	for _, text := range []string{"b", "a", "c", "a"} {
+		db.CreateMessage(ctx, types.Message{Message: text, IsPalindrome: text != "c"})
+	}
+
+	msgs, _ := db.ListMessages(ctx, types.ListOptions{Limit: 2})
+	assert.Equal(t, []int{1, 2}, messageIds(msgs))
+
+	msgs, _ = db.ListMessages(ctx, types.ListOptions{After: &types.Cursor{Id: 2}})
+	assert.Equal(t, []int{3, 4}, messageIds(msgs))
+
+	msgs, _ = db.ListMessages(ctx, types.ListOptions{Order: types.OrderDesc, After: &types.Cursor{Id: 3}})
+	assert.Equal(t, []int{2, 1}, messageIds(msgs))
+
+	msgs, _ = db.ListMessages(ctx, types.ListOptions{Sort: types.SortByMessage})
+	assert.Equal(t, []int{2, 4, 1, 3}, messageIds(msgs))
+
+	msgs, _ = db.ListMessages(ctx, types.ListOptions{Sort: types.SortByMessage, After: &types.Cursor{Id: 2, Value: "a"}})
+	assert.Equal(t, []int{4, 1, 3}, messageIds(msgs))
+
+	isPalindrome := false
+	msgs, _ = db.ListMessages(ctx, types.ListOptions{IsPalindrome: &isPalindrome})
+	assert.Equal(t, []int{3}, messageIds(msgs))
+}
+
+// TestMemoryMessageTooLong tests Messages longer than the database column are rejected
+func TestMemoryMessageTooLong(t *testing.T) {
+	db := NewMemoryDatabase()
+
+	_, err := db.CreateMessage(context.Background(), types.Message{Message: strings.Repeat("a", types.MaxMessageLength+1)})
+
+	assert.True(t, errors.Is(err, types.ErrValidation))
+}
+
+// TestMemoryConcurrentCreates tests concurrent writers are each given a unique id
+func TestMemoryConcurrentCreates(t *testing.T) {
+	db := NewMemoryDatabase()
+	ctx := context.Background()
+	var wg sync.WaitGroup
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			db.CreateMessage(ctx, types.Message{Message: "test"})
+		}()
+	}
+	wg.Wait()
+
+	msgs, _ := db.ListMessages(ctx, types.ListOptions{})
+	assert.Len(t, msgs, 50)
+	assert.Equal(t, 50, msgs[49].Id)
+}
+
+// TestNewDatabaseDriver tests the driver from the config is used to create the data module
+func TestNewDatabaseDriver(t *testing.T) {
+	db, err := NewDatabase(types.Config{Db: types.DbConnection{Driver: DriverMemory}}, logging.Discard())
+	assert.IsType(t, &memoryDatabase{}, db)
+	assert.Equal(t, nil, err)
+
+	_, err = NewDatabase(types.Config{Db: types.DbConnection{Driver: "oracle"}}, logging.Discard())
+	assert.Equal(t, errors.New(`database driver "oracle" is not supported`), err)
+}
+
+// messageIds returns the ids of the Messages in order
+func messageIds(msgs []types.Message) []int {
+	ids := []int{}
+	for _, msg := range msgs {
+		ids = append(ids, msg.Id)
+	}
+
+	return ids
+}
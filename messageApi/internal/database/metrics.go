@@ -0,0 +1,68 @@
+package database
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Descriptions of the connection pool statistics collected from the Postgres data module
+var (
+	poolAcquiredConnsDesc = prometheus.NewDesc(
+		"messageapi_db_pool_acquired_connections",
+		"Number of connections currently acquired from the pool.",
+		nil, nil,
+	)
+	poolIdleConnsDesc = prometheus.NewDesc(
+		"messageapi_db_pool_idle_connections",
+		"Number of idle connections in the pool.",
+		nil, nil,
+	)
+	poolTotalConnsDesc = prometheus.NewDesc(
+		"messageapi_db_pool_total_connections",
+		"Number of connections in the pool, including those being constructed.",
+		nil, nil,
+	)
+	poolMaxConnsDesc = prometheus.NewDesc(
+		"messageapi_db_pool_max_connections",
+		"Maximum number of connections the pool can hold.",
+		nil, nil,
+	)
+	poolAcquireCountDesc = prometheus.NewDesc(
+		"messageapi_db_pool_acquires_total",
+		"Number of successful connection acquires from the pool.",
+		nil, nil,
+	)
+	poolEmptyAcquireCountDesc = prometheus.NewDesc(
+		"messageapi_db_pool_empty_acquires_total",
+		"Number of acquires that had to wait for a connection because the pool was empty.",
+		nil, nil,
+	)
+	poolAcquireWaitDesc = prometheus.NewDesc(
+		"messageapi_db_pool_acquire_wait_seconds_total",
+		"Total time spent waiting for successful connection acquires.",
+		nil, nil,
+	)
+)
+
+// Describe sends the descriptions of the connection pool statistics
+func (d *database) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolAcquiredConnsDesc
+	ch <- poolIdleConnsDesc
+	ch <- poolTotalConnsDesc
+	ch <- poolMaxConnsDesc
+	ch <- poolAcquireCountDesc
+	ch <- poolEmptyAcquireCountDesc
+	ch <- poolAcquireWaitDesc
+}
+
+// Collect sends the current connection pool statistics
+func (d *database) Collect(ch chan<- prometheus.Metric) {
+	stat := d.pool.Stat()
+
+	ch <- prometheus.MustNewConstMetric(poolAcquiredConnsDesc, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(poolIdleConnsDesc, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(poolTotalConnsDesc, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(poolMaxConnsDesc, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(poolAcquireCountDesc, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolEmptyAcquireCountDesc, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolAcquireWaitDesc, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+}
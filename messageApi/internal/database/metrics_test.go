@@ -0,0 +1,32 @@
+package database
+
+import (
+	"context"
+	"messageApi/internal/logging"
+	"messageApi/internal/types"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPoolMetrics tests the connection pool statistics are collected without needing a connection
+func TestPoolMetrics(t *testing.T) {
+	cfg := types.Config{Db: types.DbConnection{Host: "127.0.0.1", Port: 1, User: "user", Password: "password", Database: "messages"}}
+	pool, err := pgxpool.New(context.Background(), connectionUrl(cfg)+"?pool_max_conns=3")
+	assert.Equal(t, nil, err)
+	defer pool.Close()
+
+	db := &database{config: cfg, pool: pool, conn: pool, logger: logging.Discard()}
+
+	expected := `
+# HELP messageapi_db_pool_max_connections Maximum number of connections the pool can hold.
+# TYPE messageapi_db_pool_max_connections gauge
+messageapi_db_pool_max_connections 3
+`
+
+	assert.Equal(t, 7, testutil.CollectAndCount(db))
+	assert.Equal(t, nil, testutil.CollectAndCompare(db, strings.NewReader(expected), "messageapi_db_pool_max_connections"))
+}
@@ -0,0 +1,523 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"messageApi/internal/migrations"
+	"messageApi/internal/types"
+	"net/url"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteDatabase is the implementation of the data module backed by SQLite
+// Queries are run with conn, which is the database itself or a transaction on it
+type sqliteDatabase struct {
+	db       *sql.DB
+	conn     sqliteConn
+	migrator migrations.Migrator
+}
+
+// sqliteConn runs queries on either the database or a transaction
+type sqliteConn interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// sqliteMemoryPath is the path used to keep a SQLite database in memory
+const sqliteMemoryPath = ":memory:"
+
+// newSQLiteDatabase creates an instance of the data module backed by SQLite
+// An in-memory database always has the migrations applied as it starts empty
+func newSQLiteDatabase(cfg types.Config, logger *slog.Logger) (Database, error) {
+	db, err := openSQLite(cfg.Db.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	// the migrator is not closed as it would close the database being used
+	migrator, err := migrations.NewMigrator(db, migrations.SQLite)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if cfg.Db.AutoMigrate || cfg.Db.Path == sqliteMemoryPath {
+		if err := applyMigrations(migrator, logger); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	logger.Info("opened database", "driver", DriverSQLite, "path", cfg.Db.Path)
+
+	return &sqliteDatabase{db: db, conn: db, migrator: migrator}, nil
+}
+
+// openSQLite opens the SQLite database at the path
+// The path is escaped so characters such as ? and # are kept in the file name rather than read as options
+// An in-memory database is limited to one connection as each connection would otherwise get its own database
+func openSQLite(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_busy_timeout=5000&_foreign_keys=on", url.PathEscape(path)))
+	if err != nil {
+		return nil, err
+	}
+
+	if path == sqliteMemoryPath {
+		db.SetMaxOpenConns(1)
+		db.SetConnMaxLifetime(0)
+		db.SetConnMaxIdleTime(0)
+	}
+
+	return db, nil
+}
+
+// CreateMessage will INSERT the Message into the database
+func (d *sqliteDatabase) CreateMessage(ctx context.Context, msg types.Message) (types.Message, error) {
+	var id int
+	err := d.conn.QueryRowContext(ctx, "INSERT INTO messages (message, ispalindrome, palindromemode, owner, tenant) VALUES(@message, @ispalindrome, @palindromemode, @owner, @tenant) RETURNING id, version",
+		sql.Named("message", msg.Message),
+		sql.Named("ispalindrome", msg.IsPalindrome),
+		sql.Named("palindromemode", string(msg.PalindromeMode)),
+		sql.Named("owner", msg.Owner),
+		sql.Named("tenant", types.TenantFromContext(ctx)),
+	).Scan(&id, &msg.Version)
+	if err != nil {
+		return types.Message{}, wrapSQLiteError(err)
+	}
+
+	msg.Id = id
+
+	return msg, nil
+}
+
+// GetMessage returns a single Message from the database
+func (d *sqliteDatabase) GetMessage(ctx context.Context, id int) (types.Message, error) {
+	row := d.conn.QueryRowContext(ctx, "SELECT "+messageColumns+" FROM messages WHERE id = @id AND tenant = @tenant",
+		sql.Named("id", id),
+		sql.Named("tenant", types.TenantFromContext(ctx)),
+	)
+
+	msg, err := scanMessage(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return types.Message{}, notFoundError(id)
+	} else if err != nil {
+		return types.Message{}, wrapSQLiteError(err)
+	}
+
+	return msg, nil
+}
+
+// GetMessages returns the stored Messages with the ids in a single query, leaving out ids that are not stored
+// The ids are sent as a JSON array so any number of them can be given without reaching the SQLite variable limit
+func (d *sqliteDatabase) GetMessages(ctx context.Context, ids []int) ([]types.Message, error) {
+	idsJSON, err := json.Marshal(ids)
+	if err != nil {
+		return []types.Message{}, err
+	}
+
+	rows, err := d.conn.QueryContext(ctx, "SELECT "+messageColumns+" FROM messages WHERE id IN (SELECT value FROM json_each(@ids)) AND tenant = @tenant ORDER BY id",
+		sql.Named("ids", string(idsJSON)),
+		sql.Named("tenant", types.TenantFromContext(ctx)),
+	)
+	if err != nil {
+		return []types.Message{}, wrapSQLiteError(err)
+	}
+	defer rows.Close()
+
+	msgs := []types.Message{}
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return []types.Message{}, wrapSQLiteError(err)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	if err := rows.Err(); err != nil {
+		return []types.Message{}, wrapSQLiteError(err)
+	}
+
+	return msgs, nil
+}
+
+// ListMessages returns a single page of Messages from the database matching the list options
+func (d *sqliteDatabase) ListMessages(ctx context.Context, opts types.ListOptions) ([]types.Message, error) {
+	query, namedArgs := listMessagesQuery("messages", types.TenantFromContext(ctx), opts)
+
+	args := []any{}
+	for name, value := range namedArgs {
+		args = append(args, sql.Named(name, value))
+	}
+
+	rows, err := d.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return []types.Message{}, wrapSQLiteError(err)
+	}
+	defer rows.Close()
+
+	msgs := []types.Message{}
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return []types.Message{}, wrapSQLiteError(err)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	if err := rows.Err(); err != nil {
+		return []types.Message{}, wrapSQLiteError(err)
+	}
+
+	return msgs, nil
+}
+
+// UpdateMessage performs an UPDATE on an existing Message in the database and increases its version
+// The owner of a Message never changes so it is not updated
+func (d *sqliteDatabase) UpdateMessage(ctx context.Context, msg types.Message) (types.Message, error) {
+	query := "UPDATE messages SET message = @message, ispalindrome = @ispalindrome, palindromemode = @palindromemode, version = version + 1 WHERE id = @id AND tenant = @tenant"
+	if msg.Version > 0 {
+		query += " AND version = @version"
+	}
+
+	expected := msg.Version
+	err := d.conn.QueryRowContext(ctx, query+" RETURNING owner, version",
+		sql.Named("id", msg.Id),
+		sql.Named("tenant", types.TenantFromContext(ctx)),
+		sql.Named("message", msg.Message),
+		sql.Named("ispalindrome", msg.IsPalindrome),
+		sql.Named("palindromemode", string(msg.PalindromeMode)),
+		sql.Named("version", msg.Version),
+	).Scan(&msg.Owner, &msg.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return types.Message{}, staleOrMissing(ctx, d.GetMessage, msg.Id, expected)
+	} else if err != nil {
+		return types.Message{}, wrapSQLiteError(err)
+	}
+
+	return msg, nil
+}
+
+// DeleteMessage performs a DELETE on an existing message in the database
+func (d *sqliteDatabase) DeleteMessage(ctx context.Context, id int, version int) error {
+	query := "DELETE FROM messages WHERE id = @id AND tenant = @tenant"
+	if version > 0 {
+		query += " AND version = @version"
+	}
+
+	result, err := d.conn.ExecContext(ctx, query,
+		sql.Named("id", id),
+		sql.Named("tenant", types.TenantFromContext(ctx)),
+		sql.Named("version", version),
+	)
+	if err != nil {
+		return wrapSQLiteError(err)
+	}
+
+	if affected, err := result.RowsAffected(); err != nil {
+		return wrapSQLiteError(err)
+	} else if affected == 0 {
+		return staleOrMissing(ctx, d.GetMessage, id, version)
+	}
+
+	return nil
+}
+
+// ApplyBatch makes every change in the batch in a single transaction, so either all of them are made or none are
+func (d *sqliteDatabase) ApplyBatch(ctx context.Context, ops []types.BatchOperation) ([]types.Message, error) {
+	var msgs []types.Message
+	err := d.WithTx(ctx, func(tx Database) (err error) {
+		msgs, err = applyOperations(ctx, tx, ops)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return msgs, nil
+}
+
+// WithTx runs fn with a Database whose changes are all committed when fn returns nil, or all rolled back when it returns
+// an error. The transaction is run again when SQLite reports the database is busy, so fn must not have side effects
+// outside the Database it is given. Calling WithTx in fn uses a savepoint, so only the inner changes are rolled back
+func (d *sqliteDatabase) WithTx(ctx context.Context, fn func(Database) error) error {
+	if _, ok := d.conn.(*sql.Tx); ok {
+		return d.withSavepoint(ctx, fn)
+	}
+
+	return retryTx(ctx, isSQLiteBusy, func() error {
+		tx, err := d.db.BeginTx(ctx, nil)
+		if err != nil {
+			return wrapSQLiteError(err)
+		}
+		// rolling back does nothing once the transaction has been committed
+		defer tx.Rollback()
+
+		if err := fn(&sqliteDatabase{db: d.db, conn: tx, migrator: d.migrator}); err != nil {
+			return err
+		}
+
+		return wrapSQLiteError(tx.Commit())
+	})
+}
+
+// withSavepoint runs fn in a savepoint of the transaction the database is in, rolling back to it when fn fails
+func (d *sqliteDatabase) withSavepoint(ctx context.Context, fn func(Database) error) error {
+	if _, err := d.conn.ExecContext(ctx, "SAVEPOINT with_tx"); err != nil {
+		return wrapSQLiteError(err)
+	}
+
+	if err := fn(d); err != nil {
+		// rolling back to a savepoint keeps it open, so it is released afterwards
+		if _, rollbackErr := d.conn.ExecContext(context.WithoutCancel(ctx), "ROLLBACK TO with_tx; RELEASE with_tx"); rollbackErr != nil {
+			return errors.Join(err, wrapSQLiteError(rollbackErr))
+		}
+		return err
+	}
+
+	_, err := d.conn.ExecContext(ctx, "RELEASE with_tx")
+	return wrapSQLiteError(err)
+}
+
+// isSQLiteBusy returns true when SQLite could not lock the database for a transaction, meaning it can succeed when run again
+func isSQLiteBusy(err error) bool {
+	var sqliteErr sqlite3.Error
+
+	return errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked)
+}
+
+// CountMessages returns how many Messages the tenant has stored
+func (d *sqliteDatabase) CountMessages(ctx context.Context) (int, error) {
+	var count int
+	err := d.conn.QueryRowContext(ctx, "SELECT count(*) FROM messages WHERE tenant = @tenant", sql.Named("tenant", types.TenantFromContext(ctx))).Scan(&count)
+	if err != nil {
+		return 0, wrapSQLiteError(err)
+	}
+
+	return count, nil
+}
+
+// CreateAPIKey will INSERT the APIKey into the database for its tenant
+func (d *sqliteDatabase) CreateAPIKey(ctx context.Context, key types.APIKey) (types.APIKey, error) {
+	key.CreatedAt = now()
+
+	err := d.conn.QueryRowContext(ctx, "INSERT INTO api_keys (tenant, name, prefix, hash, scopes, created_at) VALUES(@tenant, @name, @prefix, @hash, @scopes, @created_at) RETURNING id",
+		sql.Named("tenant", key.Tenant),
+		sql.Named("name", key.Name),
+		sql.Named("prefix", key.Prefix),
+		sql.Named("hash", key.Hash),
+		sql.Named("scopes", joinScopes(key.Scopes)),
+		sql.Named("created_at", key.CreatedAt),
+	).Scan(&key.Id)
+	if err != nil {
+		return types.APIKey{}, wrapSQLiteError(err)
+	}
+
+	return key, nil
+}
+
+// GetAPIKey returns the APIKey with the prefix from the database in any tenant, including revoked keys
+// Keys are looked up before the tenant of the request is known, as the key decides it
+func (d *sqliteDatabase) GetAPIKey(ctx context.Context, prefix string) (types.APIKey, error) {
+	row := d.conn.QueryRowContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE prefix = @prefix", sql.Named("prefix", prefix))
+
+	key, err := scanAPIKey(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return types.APIKey{}, apiKeyNotFoundError(prefix)
+	} else if err != nil {
+		return types.APIKey{}, wrapSQLiteError(err)
+	}
+
+	return key, nil
+}
+
+// ListAPIKeys returns every APIKey for the tenant in the database ordered by id
+func (d *sqliteDatabase) ListAPIKeys(ctx context.Context) ([]types.APIKey, error) {
+	rows, err := d.conn.QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE tenant = @tenant ORDER BY id", sql.Named("tenant", types.TenantFromContext(ctx)))
+	if err != nil {
+		return []types.APIKey{}, wrapSQLiteError(err)
+	}
+	defer rows.Close()
+
+	keys := []types.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return []types.APIKey{}, wrapSQLiteError(err)
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return []types.APIKey{}, wrapSQLiteError(err)
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey marks the APIKey for the tenant as revoked, keeping the original time when it was already revoked
+func (d *sqliteDatabase) RevokeAPIKey(ctx context.Context, id int) (types.APIKey, error) {
+	row := d.conn.QueryRowContext(ctx, "UPDATE api_keys SET revoked_at = COALESCE(revoked_at, @revoked_at) WHERE id = @id AND tenant = @tenant RETURNING "+apiKeyColumns,
+		sql.Named("id", id),
+		sql.Named("tenant", types.TenantFromContext(ctx)),
+		sql.Named("revoked_at", now()),
+	)
+
+	key, err := scanAPIKey(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return types.APIKey{}, apiKeyIdNotFoundError(id)
+	} else if err != nil {
+		return types.APIKey{}, wrapSQLiteError(err)
+	}
+
+	return key, nil
+}
+
+// CreateIdempotencyRecord will INSERT the IdempotencyRecord for the tenant, returning a conflict error when the key is already used
+// Expired records are removed first so their keys can be used again and the table does not keep growing
+func (d *sqliteDatabase) CreateIdempotencyRecord(ctx context.Context, record types.IdempotencyRecord) error {
+	if _, err := d.conn.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= @now", sql.Named("now", now())); err != nil {
+		return wrapSQLiteError(err)
+	}
+
+	_, err := d.conn.ExecContext(ctx, "INSERT INTO idempotency_keys (tenant, caller, key, request_hash, expires_at) VALUES(@tenant, @caller, @key, @request_hash, @expires_at)",
+		sql.Named("tenant", types.TenantFromContext(ctx)),
+		sql.Named("caller", record.Caller),
+		sql.Named("key", record.Key),
+		sql.Named("request_hash", record.RequestHash),
+		sql.Named("expires_at", record.ExpiresAt.UTC()),
+	)
+
+	return wrapSQLiteError(err)
+}
+
+// GetIdempotencyRecord returns the IdempotencyRecord for the caller's key in the tenant when it has not expired
+func (d *sqliteDatabase) GetIdempotencyRecord(ctx context.Context, caller string, key string) (types.IdempotencyRecord, error) {
+	row := d.conn.QueryRowContext(ctx, "SELECT "+idempotencyColumns+" FROM idempotency_keys WHERE tenant = @tenant AND caller = @caller AND key = @key AND expires_at > @now",
+		sql.Named("tenant", types.TenantFromContext(ctx)),
+		sql.Named("caller", caller),
+		sql.Named("key", key),
+		sql.Named("now", now()),
+	)
+
+	record, err := scanIdempotencyRecord(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return types.IdempotencyRecord{}, idempotencyKeyNotFoundError(key)
+	} else if err != nil {
+		return types.IdempotencyRecord{}, wrapSQLiteError(err)
+	}
+
+	return record, nil
+}
+
+// CompleteIdempotencyRecord stores the response of the IdempotencyRecord so it can be replayed until it expires
+func (d *sqliteDatabase) CompleteIdempotencyRecord(ctx context.Context, record types.IdempotencyRecord) error {
+	result, err := d.conn.ExecContext(ctx, "UPDATE idempotency_keys SET status = @status, headers = @headers, body = @body, expires_at = @expires_at WHERE tenant = @tenant AND caller = @caller AND key = @key",
+		sql.Named("tenant", types.TenantFromContext(ctx)),
+		sql.Named("caller", record.Caller),
+		sql.Named("key", record.Key),
+		sql.Named("status", record.Status),
+		sql.Named("headers", encodeHeaders(record.Headers)),
+		sql.Named("body", record.Body),
+		sql.Named("expires_at", record.ExpiresAt.UTC()),
+	)
+	if err != nil {
+		return wrapSQLiteError(err)
+	}
+
+	if affected, err := result.RowsAffected(); err != nil {
+		return wrapSQLiteError(err)
+	} else if affected == 0 {
+		return idempotencyKeyNotFoundError(record.Key)
+	}
+
+	return nil
+}
+
+// DeleteIdempotencyRecord removes the IdempotencyRecord for the caller's key in the tenant so the key can be used again
+func (d *sqliteDatabase) DeleteIdempotencyRecord(ctx context.Context, caller string, key string) error {
+	result, err := d.conn.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE tenant = @tenant AND caller = @caller AND key = @key",
+		sql.Named("tenant", types.TenantFromContext(ctx)),
+		sql.Named("caller", caller),
+		sql.Named("key", key),
+	)
+	if err != nil {
+		return wrapSQLiteError(err)
+	}
+
+	if affected, err := result.RowsAffected(); err != nil {
+		return wrapSQLiteError(err)
+	} else if affected == 0 {
+		return idempotencyKeyNotFoundError(key)
+	}
+
+	return nil
+}
+
+// Ping checks the database can be used
+func (d *sqliteDatabase) Ping(ctx context.Context) error {
+	return wrapSQLiteError(d.db.PingContext(ctx))
+}
+
+// CheckMigrations returns an error when migrations have not been applied to the database
+// It only reads the database, as it is called by every readiness probe
+func (d *sqliteDatabase) CheckMigrations(ctx context.Context) error {
+	return checkMigrations(ctx, d.migrator)
+}
+
+// Close closes the database once all open queries have finished
+// A Database in a transaction does not own the database, so closing it does nothing
+func (d *sqliteDatabase) Close() error {
+	if _, ok := d.conn.(*sql.Tx); ok {
+		return nil
+	}
+
+	return d.db.Close()
+}
+
+// scanner is implemented by both sql.Row and sql.Rows
+type scanner interface {
+	Scan(...any) error
+}
+
+// scanMessage reads a Message from the messageColumns of a row
+func scanMessage(row scanner) (types.Message, error) {
+	var msg types.Message
+	var message sql.NullString
+	var isPalindrome sql.NullBool
+
+	if err := row.Scan(&msg.Id, &message, &isPalindrome, &msg.PalindromeMode, &msg.Owner, &msg.Version); err != nil {
+		return types.Message{}, err
+	}
+
+	msg.Message = message.String
+	msg.IsPalindrome = isPalindrome.Bool
+
+	return msg, nil
+}
+
+// wrapSQLiteError wraps errors returned by SQLite with the matching shared error so they can be identified by other modules
+func wrapSQLiteError(err error) error {
+	var sqliteErr sqlite3.Error
+
+	switch {
+	case errors.As(err, &sqliteErr):
+		switch {
+		case sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique, sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey:
+			return fmt.Errorf("%w: %w", types.ErrConflict, err)
+		case sqliteErr.ExtendedCode == sqlite3.ErrConstraintCheck, sqliteErr.ExtendedCode == sqlite3.ErrConstraintNotNull:
+			return fmt.Errorf("%w: %w", types.ErrValidation, err)
+		case sqliteErr.Code == sqlite3.ErrBusy, sqliteErr.Code == sqlite3.ErrLocked:
+			return fmt.Errorf("%w: %w", types.ErrUnavailable, err)
+		}
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return fmt.Errorf("%w: %w", types.ErrUnavailable, err)
+	}
+
+	return err
+}
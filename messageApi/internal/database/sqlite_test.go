@@ -0,0 +1,164 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"messageApi/internal/logging"
+	"messageApi/internal/types"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestSQLiteDatabase creates an in-memory SQLite instance of the data module
+func newTestSQLiteDatabase(t *testing.T) Database {
+	db, err := NewDatabase(types.Config{Db: types.DbConnection{Driver: DriverSQLite, Path: sqliteMemoryPath}}, logging.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// TestSQLiteMessageLifecycle tests Messages can be created, read, updated and deleted
+func TestSQLiteMessageLifecycle(t *testing.T) {
+	db := newTestSQLiteDatabase(t)
+	ctx := context.Background()
+
+	created, err := db.CreateMessage(ctx, types.Message{Message: "racecar", IsPalindrome: true, PalindromeMode: types.PalindromeExact})
+	assert.Equal(t, types.Message{Id: 1, Message: "racecar", IsPalindrome: true, PalindromeMode: types.PalindromeExact, Version: 1}, created)
+	assert.Equal(t, nil, err)
+
+	msg, err := db.GetMessage(ctx, created.Id)
+	assert.Equal(t, created, msg)
+	assert.Equal(t, nil, err)
+
+	created.Message = "été"
+	updated, err := db.UpdateMessage(ctx, created)
+	created.Version = 2
+	assert.Equal(t, created, updated)
+	assert.Equal(t, nil, err)
+
+	msg, _ = db.GetMessage(ctx, created.Id)
+	assert.Equal(t, "été", msg.Message)
+
+	assert.Equal(t, nil, db.DeleteMessage(ctx, created.Id, 0))
+	assert.Equal(t, notFoundError(created.Id), db.DeleteMessage(ctx, created.Id, 0))
+
+	_, err = db.GetMessage(ctx, created.Id)
+	assert.Equal(t, notFoundError(created.Id), err)
+
+	_, err = db.UpdateMessage(ctx, created)
+	assert.Equal(t, notFoundError(created.Id), err)
+}
+
+// TestSQLiteListMessages tests Messages are filtered, sorted and paged
+func TestSQLiteListMessages(t *testing.T) {
+	db := newTestSQLiteDatabase(t)
+	ctx := context.Background()
+	for _, text := range []string{"b", "a", "c", "a"} {
+		db.CreateMessage(ctx, types.Message{Message: text, IsPalindrome: text != "c", PalindromeMode: types.PalindromeExact})
+	}
+
+	msgs, err := db.ListMessages(ctx, types.ListOptions{Limit: 2})
+	assert.Equal(t, []int{1, 2}, messageIds(msgs))
+	assert.Equal(t, nil, err)
+
+	msgs, _ = db.ListMessages(ctx, types.ListOptions{Order: types.OrderDesc, After: &types.Cursor{Id: 3}})
+	assert.Equal(t, []int{2, 1}, messageIds(msgs))
+
+	msgs, _ = db.ListMessages(ctx, types.ListOptions{Sort: types.SortByMessage, After: &types.Cursor{Id: 2, Value: "a"}})
+	assert.Equal(t, []int{4, 1, 3}, messageIds(msgs))
+
+	isPalindrome := false
+	msgs, _ = db.ListMessages(ctx, types.ListOptions{IsPalindrome: &isPalindrome})
+	assert.Equal(t, []int{3}, messageIds(msgs))
+}
+
+// TestSQLiteMessageTooLong tests Messages longer than the column allows are rejected as invalid
+func TestSQLiteMessageTooLong(t *testing.T) {
+	db := newTestSQLiteDatabase(t)
+
+	_, err := db.CreateMessage(context.Background(), types.Message{Message: strings.Repeat("é", types.MaxMessageLength+1), PalindromeMode: types.PalindromeExact})
+
+	assert.True(t, errors.Is(err, types.ErrValidation))
+}
+
+// TestSQLiteMigrations tests the SQLite migrations can be applied, listed and reverted on a file database
+func TestSQLiteMigrations(t *testing.T) {
+	cfg := types.Config{Db: types.DbConnection{Driver: DriverSQLite, Path: filepath.Join(t.TempDir(), "messages.db")}}
+	ctx := context.Background()
+
+	migrator, err := NewMigrator(cfg)
+	assert.Equal(t, nil, err)
+	defer migrator.Close()
+
+	applied, err := migrator.Up(ctx)
+	assert.Equal(t, nil, err)
+	assert.NotEmpty(t, applied)
+
+	applied, err = migrator.Up(ctx)
+	assert.Empty(t, applied)
+	assert.Equal(t, nil, err)
+
+	statuses, _ := migrator.Status(ctx)
+	for _, status := range statuses {
+		assert.True(t, status.Applied, status.Name)
+	}
+
+	db, err := NewDatabase(cfg, logging.Discard())
+	assert.Equal(t, nil, err)
+	defer db.Close()
+	_, err = db.CreateMessage(ctx, types.Message{Message: "racecar", PalindromeMode: types.PalindromeExact})
+	assert.Equal(t, nil, err)
+
+	assert.Equal(t, nil, db.CheckMigrations(ctx))
+
+	for range statuses {
+		_, err := migrator.Down(ctx)
+		assert.Equal(t, nil, err)
+	}
+
+	err = db.CheckMigrations(ctx)
+	assert.True(t, errors.Is(err, types.ErrUnavailable), "expected unavailable, got %v", err)
+	assert.Contains(t, err.Error(), "0001_create_messages")
+
+	_, err = migrator.Down(ctx)
+	assert.Equal(t, errors.New("no migrations have been applied"), err)
+}
+
+// TestSQLiteCheckMigrationsReadOnly tests checking migrations reports them as pending without creating the tracking table
+func TestSQLiteCheckMigrationsReadOnly(t *testing.T) {
+	cfg := types.Config{Db: types.DbConnection{Driver: DriverSQLite, Path: filepath.Join(t.TempDir(), "messages.db")}}
+	ctx := context.Background()
+
+	db, err := NewDatabase(cfg, logging.Discard())
+	assert.Equal(t, nil, err)
+	defer db.Close()
+
+	err = db.CheckMigrations(ctx)
+	assert.True(t, errors.Is(err, types.ErrUnavailable), "expected unavailable, got %v", err)
+	assert.Contains(t, err.Error(), "0001_create_messages")
+
+	var tables int
+	db.(*sqliteDatabase).db.QueryRowContext(ctx, "SELECT count(*) FROM sqlite_master WHERE name = 'schema_migrations'").Scan(&tables)
+	assert.Equal(t, 0, tables)
+}
+
+// TestSQLitePathEscaped tests a path holding characters used in the connection options is kept as the file name
+func TestSQLitePathEscaped(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages?mode=ro#1.db")
+	db, err := NewDatabase(types.Config{Db: types.DbConnection{Driver: DriverSQLite, Path: path, AutoMigrate: true}}, logging.Discard())
+	assert.Equal(t, nil, err)
+	defer db.Close()
+
+	_, err = db.CreateMessage(context.Background(), types.Message{Message: "racecar", PalindromeMode: types.PalindromeExact})
+	assert.Equal(t, nil, err)
+
+	_, err = os.Stat(path)
+	assert.Equal(t, nil, err)
+}
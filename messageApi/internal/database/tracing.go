@@ -0,0 +1,43 @@
+package database
+
+import (
+	"context"
+
+	"messageApi/internal/tracing"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName names the tracer creating spans for queries made by the data module
+// The tracer is fetched for each span so it always comes from the current global tracer provider
+const tracerName = "messageApi/internal/database"
+
+// queryTracer is a pgx.QueryTracer that creates a span for each query sent to Postgres
+type queryTracer struct {
+	database string
+}
+
+// TraceQueryStart starts a span for the query as a child of any span in the context
+func (t queryTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, _ = tracing.Tracer(tracerName).Start(ctx, "postgres.query",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBNamespace(t.database),
+			semconv.DBQueryText(data.SQL),
+		),
+	)
+
+	return ctx
+}
+
+// TraceQueryEnd ends the span started for the query, recording any error and the rows affected
+func (t queryTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+
+	tracing.EndSpan(span, data.Err)
+}
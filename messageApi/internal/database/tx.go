@@ -0,0 +1,83 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// maxTxAttempts is how many times a transaction is run before a serialization failure is returned to the caller
+const maxTxAttempts = 3
+
+// txRetryDelay is how long to wait before running a transaction again, growing with each attempt
+var txRetryDelay = 20 * time.Millisecond
+
+// retryTx runs the transaction until it succeeds, returns an error that is not retryable or has been run maxTxAttempts times
+// The transaction must undo any changes it made before returning an error, as it will be run again from the start
+func retryTx(ctx context.Context, retryable func(error) bool, run func() error) error {
+	var err error
+
+	for attempt := 1; ; attempt++ {
+		err = run()
+		if err == nil || attempt == maxTxAttempts || !retryable(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(attempt) * txRetryDelay):
+		}
+	}
+}
+
+// isSerializationFailure returns true when Postgres aborted the transaction because of concurrent transactions,
+// meaning it can succeed when run again
+func isSerializationFailure(err error) bool {
+	var pgErr *pgconn.PgError
+
+	return errors.As(err, &pgErr) && (pgErr.Code == pgerrcode.SerializationFailure || pgErr.Code == pgerrcode.DeadlockDetected)
+}
+
+// WithTx runs fn with a Database whose changes are all committed when fn returns nil, or all rolled back when it returns
+// an error. The transaction is serializable and is run again when Postgres aborts it because of concurrent transactions,
+// so fn must not have side effects outside the Database it is given. Calling WithTx in fn uses a savepoint, so only the
+// changes made by the inner function are rolled back when it fails
+func (d *database) WithTx(ctx context.Context, fn func(Database) error) error {
+	return d.runTx(ctx, func(tx pgx.Tx) error {
+		return fn(&database{config: d.config, pool: d.pool, conn: tx, migrator: d.migrator, logger: d.logger})
+	})
+}
+
+// runTx runs fn in a transaction, or in a savepoint when the database is already in one
+func (d *database) runTx(ctx context.Context, fn func(pgx.Tx) error) error {
+	if tx, ok := d.conn.(pgx.Tx); ok {
+		return commitTx(ctx, tx.Begin, fn)
+	}
+
+	return retryTx(ctx, isSerializationFailure, func() error {
+		return commitTx(ctx, func(ctx context.Context) (pgx.Tx, error) {
+			return d.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable})
+		}, fn)
+	})
+}
+
+// commitTx begins a transaction, committing it when fn succeeds and rolling it back otherwise
+func commitTx(ctx context.Context, begin func(context.Context) (pgx.Tx, error), fn func(pgx.Tx) error) error {
+	tx, err := begin(ctx)
+	if err != nil {
+		return wrapError(err)
+	}
+	// rolling back does nothing once the transaction has been committed
+	defer tx.Rollback(context.WithoutCancel(ctx))
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return wrapError(tx.Commit(ctx))
+}
@@ -0,0 +1,88 @@
+// Package logging creates the structured logger shared by the application modules
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"messageApi/internal/types"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Formats the logger can write records in
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// NewLogger creates a logger writing records at or above the configured level in the configured format
+// Records logged with a context include its request id and trace ids
+func NewLogger(cfg types.LogConfig, w io.Writer) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: cfg.Level}
+
+	var handler slog.Handler
+	switch cfg.Format {
+	case FormatJSON, "":
+		handler = slog.NewJSONHandler(w, opts)
+	case FormatText:
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("log format %q is not supported, expected json or text", cfg.Format)
+	}
+
+	return slog.New(contextHandler{handler}), nil
+}
+
+// Discard returns a logger that drops every record, for use when logs are not wanted such as in tests
+func Discard() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError + 1}))
+}
+
+// contextHandler adds the request id and trace ids from the context to each record
+type contextHandler struct {
+	slog.Handler
+}
+
+// Handle adds the ids from the context to the record before passing it to the wrapped handler
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestId(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		record.AddAttrs(
+			slog.String("trace_id", spanContext.TraceID().String()),
+			slog.String("span_id", spanContext.SpanID().String()),
+		)
+	}
+
+	return h.Handler.Handle(ctx, record)
+}
+
+// WithAttrs returns a handler that keeps adding the ids from the context
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup returns a handler that keeps adding the ids from the context
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// requestIdKey is the context key for the request id
+type requestIdKey struct{}
+
+// WithRequestId returns a copy of the context holding the request id
+func WithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, id)
+}
+
+// RequestId returns the request id held in the context, or an empty string when there is none
+func RequestId(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey{}).(string)
+
+	return id
+}
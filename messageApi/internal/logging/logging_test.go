@@ -0,0 +1,63 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"messageApi/internal/types"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TestNewLoggerContext tests records include the request id and trace ids held in the context
+func TestNewLoggerContext(t *testing.T) {
+	var logs bytes.Buffer
+	logger, err := NewLogger(types.LogConfig{Level: slog.LevelInfo, Format: FormatJSON}, &logs)
+	assert.Equal(t, nil, err)
+
+	traceId, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanId, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceId, SpanID: spanId}))
+	ctx = WithRequestId(ctx, "test-request")
+
+	logger.With("module", "test").InfoContext(ctx, "handled request", "status", 200)
+
+	var record map[string]any
+	json.Unmarshal(logs.Bytes(), &record)
+
+	assert.Equal(t, "handled request", record["msg"])
+	assert.Equal(t, "test", record["module"])
+	assert.Equal(t, float64(200), record["status"])
+	assert.Equal(t, "test-request", record["request_id"])
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", record["trace_id"])
+	assert.Equal(t, "00f067aa0ba902b7", record["span_id"])
+}
+
+// TestNewLoggerLevel tests records below the configured level are dropped
+func TestNewLoggerLevel(t *testing.T) {
+	var logs bytes.Buffer
+	logger, _ := NewLogger(types.LogConfig{Level: slog.LevelWarn, Format: FormatText}, &logs)
+
+	logger.Info("dropped")
+	logger.Warn("kept")
+
+	assert.NotContains(t, logs.String(), "dropped")
+	assert.Contains(t, logs.String(), "level=WARN msg=kept")
+}
+
+// TestNewLoggerInvalidFormat tests an unsupported format is rejected
+func TestNewLoggerInvalidFormat(t *testing.T) {
+	logger, err := NewLogger(types.LogConfig{Format: "xml"}, &bytes.Buffer{})
+
+	assert.Nil(t, logger)
+	assert.EqualError(t, err, `log format "xml" is not supported, expected json or text`)
+}
+
+// TestRequestIdMissing tests an empty id is returned when the context holds none
+func TestRequestIdMissing(t *testing.T) {
+	assert.Equal(t, "", RequestId(context.Background()))
+}
@@ -0,0 +1,65 @@
+package migrations
+
+import (
+	"embed"
+	"fmt"
+)
+
+//go:embed postgres/*.sql
+var postgresFiles embed.FS
+
+//go:embed sqlite/*.sql
+var sqliteFiles embed.FS
+
+// Dialect represents the database specific statements needed to track and apply migrations
+type Dialect struct {
+	// Name is also the directory in the embedded files holding the migrations for the dialect
+	Name string
+	// Lock and Unlock stop multiple replicas from applying migrations at the same time
+	Lock   string
+	Unlock string
+	// Table is the name of the table used to track the applied migrations
+	Table string
+	// CreateTable creates the table used to track the applied migrations
+	CreateTable string
+	// TableExists selects whether the table used to track the applied migrations exists, without changing the database
+	TableExists string
+	// Placeholder returns the bind parameter for the nth argument of a statement
+	Placeholder func(n int) string
+	files       embed.FS
+}
+
+// lockKey is the advisory lock id held while migrations are being applied to Postgres
+const lockKey = 7_245_148_392_611_091
+
+// Postgres is the Dialect for applying migrations to a Postgres database
+var Postgres = Dialect{
+	Name:   "postgres",
+	Lock:   fmt.Sprintf("SELECT pg_advisory_lock(%d)", lockKey),
+	Unlock: fmt.Sprintf("SELECT pg_advisory_unlock(%d)", lockKey),
+	Table:  "public.schema_migrations",
+	CreateTable: `CREATE TABLE IF NOT EXISTS public.schema_migrations (
+		version int8 NOT NULL,
+		name varchar(255) NOT NULL,
+		applied_at timestamptz NOT NULL DEFAULT now(),
+		CONSTRAINT schema_migrations_pk PRIMARY KEY (version)
+	);`,
+	TableExists: "SELECT to_regclass('public.schema_migrations') IS NOT NULL",
+	Placeholder: func(n int) string { return fmt.Sprintf("$%d", n) },
+	files:       postgresFiles,
+}
+
+// SQLite is the Dialect for applying migrations to a SQLite database
+// SQLite has no advisory locks; writes to the database file are already serialized by SQLite itself
+var SQLite = Dialect{
+	Name:  "sqlite",
+	Table: "schema_migrations",
+	CreateTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER NOT NULL PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);`,
+	TableExists: "SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations')",
+	Placeholder: func(n int) string { return "?" },
+	files:       sqliteFiles,
+}
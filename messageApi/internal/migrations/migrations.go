@@ -0,0 +1,294 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Migration represents a single versioned schema change along with the statements to apply and revert it
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status represents whether a Migration has been applied to the database
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator is the interface for applying the embedded migrations to a database
+type Migrator interface {
+	Up(context.Context) ([]Migration, error)
+	Down(context.Context) (Migration, error)
+	Status(context.Context) ([]Status, error)
+	Pending(context.Context) ([]Migration, error)
+	Close() error
+}
+
+// migrator is the implementation of the Migrator
+type migrator struct {
+	db         *sql.DB
+	dialect    Dialect
+	migrations []Migration
+}
+
+// NewMigrator creates a Migrator for the database using the migrations embedded for the dialect
+// The Migrator takes ownership of the database and closes it when the Migrator is closed
+func NewMigrator(db *sql.DB, dialect Dialect) (Migrator, error) {
+	migrations, err := loadMigrations(dialect.files, dialect.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &migrator{db, dialect, migrations}, nil
+}
+
+// migrationFile matches migration file names such as 0001_create_messages.up.sql
+var migrationFile = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// loadMigrations reads the migrations in the directory and returns them ordered by version
+func loadMigrations(files fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			return nil, fmt.Errorf("unexpected file in migrations: %s", entry.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		contents, err := fs.ReadFile(files, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration version %d is used by both %s and %s", version, migration.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(contents)
+		} else {
+			migration.Down = string(contents)
+		}
+	}
+
+	migrations := []Migration{}
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both an up and down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// Up applies every migration that has not yet been applied and returns the ones that were
+func (m *migrator) Up(ctx context.Context) ([]Migration, error) {
+	applied := []Migration{}
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		versions, err := m.appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := versions[migration.Version]; ok {
+				continue
+			}
+
+			insert := fmt.Sprintf("INSERT INTO %s (version, name) VALUES (%s, %s)", m.dialect.Table, m.dialect.Placeholder(1), m.dialect.Placeholder(2))
+			if err := m.inTx(ctx, conn, migration.Up, insert, migration.Version, migration.Name); err != nil {
+				return fmt.Errorf("applying migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			applied = append(applied, migration)
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down reverts the most recently applied migration and returns it
+func (m *migrator) Down(ctx context.Context) (Migration, error) {
+	var reverted Migration
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		versions, err := m.appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := versions[migration.Version]; !ok {
+				continue
+			}
+
+			remove := fmt.Sprintf("DELETE FROM %s WHERE version = %s", m.dialect.Table, m.dialect.Placeholder(1))
+			if err := m.inTx(ctx, conn, migration.Down, remove, migration.Version); err != nil {
+				return fmt.Errorf("reverting migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			reverted = migration
+			return nil
+		}
+
+		return fmt.Errorf("no migrations have been applied")
+	})
+
+	return reverted, err
+}
+
+// Status returns every known migration along with whether it has been applied
+func (m *migrator) Status(ctx context.Context) ([]Status, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, m.dialect.CreateTable); err != nil {
+		return nil, err
+	}
+
+	versions, err := m.appliedVersions(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []Status{}
+	for _, migration := range m.migrations {
+		appliedAt, ok := versions[migration.Version]
+		statuses = append(statuses, Status{Migration: migration, Applied: ok, AppliedAt: appliedAt})
+	}
+
+	return statuses, nil
+}
+
+// Pending returns the migrations that have not been applied without changing the database, so it is safe to call often
+// Every migration is pending when the table tracking the applied migrations does not exist yet
+func (m *migrator) Pending(ctx context.Context) ([]Migration, error) {
+	var exists bool
+	if err := m.db.QueryRowContext(ctx, m.dialect.TableExists).Scan(&exists); err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return slices.Clone(m.migrations), nil
+	}
+
+	versions, err := m.appliedVersions(ctx, m.db)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := []Migration{}
+	for _, migration := range m.migrations {
+		if _, ok := versions[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+
+	return pending, nil
+}
+
+// Close closes the database used by the Migrator
+func (m *migrator) Close() error {
+	return m.db.Close()
+}
+
+// withLock runs fn on a single connection while holding the migration lock for the dialect
+// The tracking table is created first so fn can always read the applied migrations
+func (m *migrator) withLock(ctx context.Context, fn func(*sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.dialect.Lock != "" {
+		if _, err := conn.ExecContext(ctx, m.dialect.Lock); err != nil {
+			return fmt.Errorf("acquiring migration lock: %w", err)
+		}
+		defer conn.ExecContext(context.Background(), m.dialect.Unlock)
+	}
+
+	if _, err := conn.ExecContext(ctx, m.dialect.CreateTable); err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+// querier runs queries on either the database or a single connection
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// appliedVersions returns the time each applied migration was applied, keyed by version
+func (m *migrator) appliedVersions(ctx context.Context, conn querier) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("SELECT version, applied_at FROM %s", m.dialect.Table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		versions[version] = appliedAt
+	}
+
+	return versions, rows.Err()
+}
+
+// inTx runs the migration statements and the change to the tracking table in a single transaction
+func (m *migrator) inTx(ctx context.Context, conn *sql.Conn, statements string, tracking string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, statements); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, tracking, args...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
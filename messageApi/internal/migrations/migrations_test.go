@@ -0,0 +1,70 @@
+package migrations
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLoadMigrations tests migrations are read from the files and ordered by version
+func TestLoadMigrations(t *testing.T) {
+	files := fstest.MapFS{
+		"sql/0010_add_column.up.sql":     {Data: []byte("ALTER TABLE t ADD COLUMN c int;")},
+		"sql/0010_add_column.down.sql":   {Data: []byte("ALTER TABLE t DROP COLUMN c;")},
+		"sql/0002_create_table.up.sql":   {Data: []byte("CREATE TABLE t (id int);")},
+		"sql/0002_create_table.down.sql": {Data: []byte("DROP TABLE t;")},
+	}
+
+	migrations, err := loadMigrations(files, "sql")
+
+	assert.Equal(t, []Migration{
+		{Version: 2, Name: "create_table", Up: "CREATE TABLE t (id int);", Down: "DROP TABLE t;"},
+		{Version: 10, Name: "add_column", Up: "ALTER TABLE t ADD COLUMN c int;", Down: "ALTER TABLE t DROP COLUMN c;"},
+	}, migrations)
+	assert.Equal(t, nil, err)
+}
+
+// TestLoadMigrationsMissingDown tests an error is returned when a migration cannot be reverted
+func TestLoadMigrationsMissingDown(t *testing.T) {
+	files := fstest.MapFS{
+		"sql/0001_create_table.up.sql": {Data: []byte("CREATE TABLE t (id int);")},
+	}
+
+	_, err := loadMigrations(files, "sql")
+
+	assert.Equal(t, errors.New("migration 1_create_table must have both an up and down file"), err)
+}
+
+// TestLoadMigrationsDuplicateVersion tests an error is returned when two migrations share a version
+func TestLoadMigrationsDuplicateVersion(t *testing.T) {
+	files := fstest.MapFS{
+		"sql/0001_create_table.up.sql": {Data: []byte("CREATE TABLE t (id int);")},
+		"sql/0001_other_table.up.sql":  {Data: []byte("CREATE TABLE o (id int);")},
+	}
+
+	_, err := loadMigrations(files, "sql")
+
+	assert.Equal(t, errors.New("migration version 1 is used by both create_table and other_table"), err)
+}
+
+// TestLoadMigrationsUnexpectedFile tests an error is returned for files that are not migrations
+func TestLoadMigrationsUnexpectedFile(t *testing.T) {
+	files := fstest.MapFS{
+		"sql/notes.txt": {Data: []byte("")},
+	}
+
+	_, err := loadMigrations(files, "sql")
+
+	assert.Equal(t, errors.New("unexpected file in migrations: notes.txt"), err)
+}
+
+// TestPostgresMigrations tests the embedded Postgres migrations can be loaded
+func TestPostgresMigrations(t *testing.T) {
+	migrations, err := loadMigrations(Postgres.files, Postgres.Name)
+
+	assert.NotEmpty(t, migrations)
+	assert.Equal(t, 1, migrations[0].Version)
+	assert.Equal(t, nil, err)
+}
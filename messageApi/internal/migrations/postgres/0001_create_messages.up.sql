@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS public.messages (
+	id serial4 NOT NULL,
+	message varchar(100) NULL,
+	ispalindrome bool NULL,
+	CONSTRAINT messages_pk PRIMARY KEY (id)
+);
@@ -0,0 +1 @@
+ALTER TABLE public.messages ADD COLUMN IF NOT EXISTS palindromemode varchar(32) NOT NULL DEFAULT 'exact';
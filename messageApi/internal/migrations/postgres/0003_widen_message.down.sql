@@ -0,0 +1 @@
+ALTER TABLE public.messages ALTER COLUMN message TYPE varchar(100);
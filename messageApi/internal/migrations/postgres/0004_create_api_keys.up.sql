@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS public.api_keys (
+	id serial4 NOT NULL,
+	name varchar(100) NOT NULL,
+	prefix varchar(32) NOT NULL,
+	hash varchar(64) NOT NULL,
+	scopes varchar(100) NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	revoked_at timestamptz NULL,
+	CONSTRAINT api_keys_pk PRIMARY KEY (id),
+	CONSTRAINT api_keys_prefix_key UNIQUE (prefix)
+);
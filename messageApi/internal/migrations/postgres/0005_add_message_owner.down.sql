@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS public.messages_owner_id_idx;
+ALTER TABLE public.messages DROP COLUMN IF EXISTS owner;
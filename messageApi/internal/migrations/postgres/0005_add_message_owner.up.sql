@@ -0,0 +1,4 @@
+-- Messages written before ownership was tracked are given to the anonymous caller used when authentication is disabled
+ALTER TABLE public.messages ADD COLUMN IF NOT EXISTS owner varchar(255) NOT NULL DEFAULT 'anonymous';
+ALTER TABLE public.messages ALTER COLUMN owner DROP DEFAULT;
+CREATE INDEX IF NOT EXISTS messages_owner_id_idx ON public.messages (owner, id);
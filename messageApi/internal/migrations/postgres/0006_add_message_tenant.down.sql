@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS public.messages_tenant_owner_id_idx;
+DROP INDEX IF EXISTS public.messages_tenant_id_idx;
+CREATE INDEX IF NOT EXISTS messages_owner_id_idx ON public.messages (owner, id);
+ALTER TABLE public.messages DROP COLUMN IF EXISTS tenant;
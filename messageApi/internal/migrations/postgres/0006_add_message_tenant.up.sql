@@ -0,0 +1,6 @@
+-- Messages written before tenants were added belong to the default tenant
+ALTER TABLE public.messages ADD COLUMN IF NOT EXISTS tenant varchar(100) NOT NULL DEFAULT 'default';
+ALTER TABLE public.messages ALTER COLUMN tenant DROP DEFAULT;
+DROP INDEX IF EXISTS public.messages_owner_id_idx;
+CREATE INDEX IF NOT EXISTS messages_tenant_id_idx ON public.messages (tenant, id);
+CREATE INDEX IF NOT EXISTS messages_tenant_owner_id_idx ON public.messages (tenant, owner, id);
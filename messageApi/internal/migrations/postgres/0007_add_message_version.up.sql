@@ -0,0 +1,2 @@
+-- Existing Messages start at the first version, as do Messages created without a version
+ALTER TABLE public.messages ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS public.idempotency_keys (
+	tenant varchar(100) NOT NULL,
+	caller varchar(255) NOT NULL,
+	key varchar(255) NOT NULL,
+	request_hash varchar(64) NOT NULL,
+	status integer NOT NULL DEFAULT 0,
+	headers text NOT NULL DEFAULT '{}',
+	body bytea NULL,
+	expires_at timestamptz NOT NULL,
+	CONSTRAINT idempotency_keys_pk PRIMARY KEY (tenant, caller, key)
+);
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON public.idempotency_keys (expires_at);
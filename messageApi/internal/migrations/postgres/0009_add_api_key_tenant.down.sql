@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS public.api_keys_tenant_id_idx;
+ALTER TABLE public.api_keys DROP COLUMN IF EXISTS tenant;
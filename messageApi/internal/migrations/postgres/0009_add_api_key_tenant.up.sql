@@ -0,0 +1,4 @@
+-- API keys issued before tenants were added to them belong to the default tenant
+ALTER TABLE public.api_keys ADD COLUMN IF NOT EXISTS tenant varchar(100) NOT NULL DEFAULT 'default';
+ALTER TABLE public.api_keys ALTER COLUMN tenant DROP DEFAULT;
+CREATE INDEX IF NOT EXISTS api_keys_tenant_id_idx ON public.api_keys (tenant, id);
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS messages (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	message TEXT NULL CHECK (length(message) <= 100),
+	ispalindrome BOOLEAN NULL
+);
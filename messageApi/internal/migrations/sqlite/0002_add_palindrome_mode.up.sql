@@ -0,0 +1 @@
+ALTER TABLE messages ADD COLUMN palindromemode TEXT NOT NULL DEFAULT 'exact';
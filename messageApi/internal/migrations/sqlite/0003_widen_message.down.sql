@@ -0,0 +1,9 @@
+CREATE TABLE messages_old (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	message TEXT NULL CHECK (length(message) <= 100),
+	ispalindrome BOOLEAN NULL,
+	palindromemode TEXT NOT NULL DEFAULT 'exact'
+);
+INSERT INTO messages_old (id, message, ispalindrome, palindromemode) SELECT id, message, ispalindrome, palindromemode FROM messages;
+DROP TABLE messages;
+ALTER TABLE messages_old RENAME TO messages;
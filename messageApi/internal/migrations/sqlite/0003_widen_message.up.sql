@@ -0,0 +1,10 @@
+-- SQLite cannot change a CHECK constraint in place, so the table is rebuilt with the new limit
+CREATE TABLE messages_new (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	message TEXT NULL CHECK (length(message) <= 1000),
+	ispalindrome BOOLEAN NULL,
+	palindromemode TEXT NOT NULL DEFAULT 'exact'
+);
+INSERT INTO messages_new (id, message, ispalindrome, palindromemode) SELECT id, message, ispalindrome, palindromemode FROM messages;
+DROP TABLE messages;
+ALTER TABLE messages_new RENAME TO messages;
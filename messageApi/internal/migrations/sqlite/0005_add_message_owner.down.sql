@@ -0,0 +1,2 @@
+DROP INDEX messages_owner_id_idx;
+ALTER TABLE messages DROP COLUMN owner;
@@ -0,0 +1,3 @@
+-- Messages written before ownership was tracked are given to the anonymous caller used when authentication is disabled
+ALTER TABLE messages ADD COLUMN owner TEXT NOT NULL DEFAULT 'anonymous';
+CREATE INDEX messages_owner_id_idx ON messages (owner, id);
@@ -0,0 +1,4 @@
+DROP INDEX messages_tenant_owner_id_idx;
+DROP INDEX messages_tenant_id_idx;
+CREATE INDEX messages_owner_id_idx ON messages (owner, id);
+ALTER TABLE messages DROP COLUMN tenant;
@@ -0,0 +1,5 @@
+-- Messages written before tenants were added belong to the default tenant
+ALTER TABLE messages ADD COLUMN tenant TEXT NOT NULL DEFAULT 'default';
+DROP INDEX messages_owner_id_idx;
+CREATE INDEX messages_tenant_id_idx ON messages (tenant, id);
+CREATE INDEX messages_tenant_owner_id_idx ON messages (tenant, owner, id);
@@ -0,0 +1,2 @@
+-- Existing Messages start at the first version, as do Messages created without a version
+ALTER TABLE messages ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
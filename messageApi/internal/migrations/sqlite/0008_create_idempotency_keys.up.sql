@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	tenant TEXT NOT NULL,
+	caller TEXT NOT NULL,
+	key TEXT NOT NULL CHECK (length(key) <= 255),
+	request_hash TEXT NOT NULL,
+	status INTEGER NOT NULL DEFAULT 0,
+	headers TEXT NOT NULL DEFAULT '{}',
+	body BLOB NULL,
+	expires_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (tenant, caller, key)
+);
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
@@ -0,0 +1,2 @@
+DROP INDEX api_keys_tenant_id_idx;
+ALTER TABLE api_keys DROP COLUMN tenant;
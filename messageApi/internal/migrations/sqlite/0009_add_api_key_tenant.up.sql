@@ -0,0 +1,3 @@
+-- API keys issued before tenants were added to them belong to the default tenant
+ALTER TABLE api_keys ADD COLUMN tenant TEXT NOT NULL DEFAULT 'default';
+CREATE INDEX api_keys_tenant_id_idx ON api_keys (tenant, id);
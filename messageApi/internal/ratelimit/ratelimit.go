@@ -0,0 +1,152 @@
+// Package ratelimit limits how often a client can make requests using token buckets held in a Store
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit represents how many requests a client can make in each period
+// The bucket holds up to Requests tokens and refills evenly over the period, so short bursts are allowed
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// ParseLimit reads a Limit in the form <requests>/<period>, for example 10/1m, 100/s or 5/30s
+func ParseLimit(value string) (Limit, error) {
+	requests, period, ok := strings.Cut(strings.TrimSpace(value), "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("rate limit %q must be in the form <requests>/<period>", value)
+	}
+
+	count, err := strconv.Atoi(requests)
+	if err != nil || count < 1 {
+		return Limit{}, fmt.Errorf("rate limit %q must allow a positive number of requests", value)
+	}
+
+	if period != "" && (period[0] < '0' || period[0] > '9') {
+		period = "1" + period
+	}
+
+	duration, err := time.ParseDuration(period)
+	if err != nil || duration <= 0 {
+		return Limit{}, fmt.Errorf("rate limit %q must have a positive period such as 1m", value)
+	}
+
+	return Limit{Requests: count, Period: duration}, nil
+}
+
+// String returns the Limit in the form read by ParseLimit
+func (l Limit) String() string {
+	return fmt.Sprintf("%d/%s", l.Requests, l.Period)
+}
+
+// Result represents the state of a client's bucket after a request took a token from it
+// ResetAfter is how long until the bucket is full again and RetryAfter how long until a rejected request can be retried
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	ResetAfter time.Duration
+	RetryAfter time.Duration
+}
+
+// Store holds the token buckets of every client
+// The in-memory store only limits requests to a single replica; a store shared between replicas, such as one
+// backed by Redis, must take the token atomically so concurrent requests cannot both take the last token
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// bucket represents the tokens left for a client when it was last updated
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	period  time.Duration
+}
+
+// take refills the bucket for the time since it was last updated then takes a token when one is left
+func (b bucket) take(limit Limit, now time.Time) (bucket, Result) {
+	capacity := float64(limit.Requests)
+	rate := capacity / limit.Period.Seconds()
+
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(capacity, b.tokens+elapsed*rate)
+	}
+	b.updated = now
+	b.period = limit.Period
+
+	result := Result{Limit: limit.Requests}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsToDuration((1 - b.tokens) / rate)
+	}
+
+	result.Remaining = int(math.Floor(b.tokens))
+	result.ResetAfter = secondsToDuration((capacity - b.tokens) / rate)
+
+	return b, result
+}
+
+// secondsToDuration converts seconds into a Duration, rounding up to the next millisecond
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(math.Ceil(seconds*1000)) * time.Millisecond
+}
+
+// sweepInterval is how often the memory store removes buckets that have refilled
+const sweepInterval = time.Minute
+
+// memoryStore is the implementation of Store that keeps the buckets in memory
+type memoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewMemoryStore creates a Store that keeps the buckets in memory, which limits requests to a single replica
+func NewMemoryStore() Store {
+	return &memoryStore{buckets: map[string]bucket{}, now: time.Now}
+}
+
+// Take takes a token from the client's bucket, creating a full bucket for a new client
+func (s *memoryStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = bucket{tokens: float64(limit.Requests), updated: now}
+	}
+
+	b, result := b.take(limit, now)
+	s.buckets[key] = b
+
+	return result, nil
+}
+
+// sweep removes the buckets that have had a full period to refill, as they are the same as a new bucket
+// The lock must be held by the caller
+func (s *memoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, b := range s.buckets {
+		if now.Sub(b.updated) >= b.period {
+			delete(s.buckets, key)
+		}
+	}
+}
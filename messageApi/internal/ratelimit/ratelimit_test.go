@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseLimit tests limits are read in the form <requests>/<period>
+func TestParseLimit(t *testing.T) {
+	tests := map[string]Limit{
+		"10/1m":   {Requests: 10, Period: time.Minute},
+		"100/s":   {Requests: 100, Period: time.Second},
+		"5/30s":   {Requests: 5, Period: 30 * time.Second},
+		" 1/h ":   {Requests: 1, Period: time.Hour},
+		"2/1h30m": {Requests: 2, Period: 90 * time.Minute},
+	}
+
+	for value, expected := range tests {
+		limit, err := ParseLimit(value)
+		assert.Equal(t, expected, limit, value)
+		assert.Equal(t, nil, err, value)
+	}
+
+	for _, value := range []string{"", "10", "0/1m", "-1/1m", "ten/1m", "10/", "10/0s", "10/fortnight"} {
+		_, err := ParseLimit(value)
+		assert.Error(t, err, value)
+	}
+}
+
+// TestMemoryStore tests a client can burst up to the limit, is rejected until a token refills and gets a full bucket back over time
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore().(*memoryStore)
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return clock }
+	limit := Limit{Requests: 3, Period: 3 * time.Second}
+	ctx := context.Background()
+
+	for remaining := 2; remaining >= 0; remaining-- {
+		result, err := store.Take(ctx, "client", limit)
+		assert.Equal(t, Result{Allowed: true, Limit: 3, Remaining: remaining, ResetAfter: time.Duration(3-remaining) * time.Second}, result)
+		assert.Equal(t, nil, err)
+	}
+
+	result, _ := store.Take(ctx, "client", limit)
+	assert.Equal(t, Result{Allowed: false, Limit: 3, Remaining: 0, ResetAfter: 3 * time.Second, RetryAfter: time.Second}, result)
+
+	other, _ := store.Take(ctx, "other", limit)
+	assert.True(t, other.Allowed)
+
+	clock = clock.Add(500 * time.Millisecond)
+	result, _ = store.Take(ctx, "client", limit)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 500*time.Millisecond, result.RetryAfter)
+
+	clock = clock.Add(500 * time.Millisecond)
+	result, _ = store.Take(ctx, "client", limit)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+
+	clock = clock.Add(time.Hour)
+	result, _ = store.Take(ctx, "client", limit)
+	assert.Equal(t, Result{Allowed: true, Limit: 3, Remaining: 2, ResetAfter: time.Second}, result)
+}
+
+// TestMemoryStoreSweep tests buckets that have refilled are removed so idle clients do not use memory
+func TestMemoryStoreSweep(t *testing.T) {
+	store := NewMemoryStore().(*memoryStore)
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return clock }
+	ctx := context.Background()
+
+	store.Take(ctx, "idle", Limit{Requests: 1, Period: time.Second})
+	store.Take(ctx, "slow", Limit{Requests: 1, Period: time.Hour})
+	assert.Len(t, store.buckets, 2)
+
+	clock = clock.Add(sweepInterval)
+	store.Take(ctx, "new", Limit{Requests: 1, Period: time.Second})
+
+	assert.Len(t, store.buckets, 2)
+	assert.Contains(t, store.buckets, "slow")
+	assert.Contains(t, store.buckets, "new")
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"messageApi/internal/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// issueAPIKeyRequest represents the body of a request to issue an API key
+// The key is issued for the caller's tenant unless Tenant names another one
+type issueAPIKeyRequest struct {
+	Name   string        `json:"name"`
+	Scopes []types.Scope `json:"scopes"`
+	Tenant string        `json:"tenant"`
+}
+
+// addAdminRoutes adds the routes for managing API keys, which require the admin scope
+func addAdminRoutes(group *gin.RouterGroup) {
+	keys := group.Group("/admin/keys", RequireScope(types.ScopeAdmin))
+
+	keys.POST("", IssueAPIKeyHandler)
+	keys.GET("", ListAPIKeysHandler)
+	keys.DELETE("/:id", RevokeAPIKeyHandler)
+}
+
+// IssueAPIKeyHandler handles requests to issue API keys
+// The key is only included in this response so it must be stored by the caller
+func IssueAPIKeyHandler(c *gin.Context) {
+	service, ok := getService(c)
+	if !ok {
+		abortWithProblem(c, http.StatusInternalServerError, "Service unavailable")
+		return
+	}
+
+	var req issueAPIKeyRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithBindError(c, err)
+		return
+	}
+
+	if req.Tenant != "" && !isValidTenant(req.Tenant) {
+		abortWithProblem(c, http.StatusBadRequest, "Invalid tenant", FieldError{Field: "tenant", Message: fmt.Sprintf("must be 1 to %d letters, digits, - _ or .", types.MaxTenantLength)})
+		return
+	}
+
+	key, err := service.IssueAPIKey(c.Request.Context(), req.Name, req.Scopes, req.Tenant)
+	if err != nil {
+		abortWithError(c, err, "Error issuing api key")
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusCreated, key)
+}
+
+// ListAPIKeysHandler handles requests to list API keys, including revoked keys
+func ListAPIKeysHandler(c *gin.Context) {
+	service, ok := getService(c)
+	if !ok {
+		abortWithProblem(c, http.StatusInternalServerError, "Service unavailable")
+		return
+	}
+
+	keys, err := service.ListAPIKeys(c.Request.Context())
+	if err != nil {
+		abortWithError(c, err, "Error retrieving api keys")
+		return
+	}
+
+	if keys == nil {
+		keys = []types.APIKey{}
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// RevokeAPIKeyHandler handles requests to revoke API keys
+func RevokeAPIKeyHandler(c *gin.Context) {
+	service, ok := getService(c)
+	if !ok {
+		abortWithProblem(c, http.StatusInternalServerError, "Service unavailable")
+		return
+	}
+
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		abortWithProblem(c, http.StatusBadRequest, "Invalid Id", FieldError{Field: "id", Message: "must be an integer"})
+		return
+	}
+
+	key, err := service.RevokeAPIKey(c.Request.Context(), id)
+	if err != nil {
+		abortWithError(c, err, fmt.Sprintf("Failed to revoke api key with id %d", id))
+		return
+	}
+
+	c.JSON(http.StatusOK, key)
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"messageApi/internal/service"
+	"messageApi/internal/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiKeyHeader is the header callers can send their API key in instead of an Authorization header
+const apiKeyHeader = "X-API-Key"
+
+// anonymousIdentity is given to every caller when authentication is disabled
+// It cannot manage API keys so keys cannot be issued before authentication is enabled
+var anonymousIdentity = types.Identity{Subject: types.AnonymousSubject, Scopes: []types.Scope{types.ScopeRead, types.ScopeWrite}}
+
+// AuthMiddleware authenticates the caller using the configured mode and adds their Identity to the request context
+// The jwt mode loads the JWKS before returning so a missing or invalid JWKS stops the server starting
+func AuthMiddleware(cfg types.AuthConfig, service service.Service, logger *slog.Logger) (gin.HandlerFunc, error) {
+	switch cfg.Mode {
+	case types.AuthNone, "":
+		return func(c *gin.Context) {
+			c.Request = c.Request.WithContext(types.WithIdentity(c.Request.Context(), anonymousIdentity))
+			c.Next()
+		}, nil
+	case types.AuthAPIKey:
+		return apiKeyAuth(service), nil
+	case types.AuthJWT:
+		keys, err := newJWKSCache(context.Background(), cfg.JWT.JWKSURL, cfg.JWT.JWKSFile, cfg.JWT.JWKSRefreshInterval, logger)
+		if err != nil {
+			return nil, fmt.Errorf("loading JWKS: %w", err)
+		}
+
+		return jwtAuth(cfg.JWT, keys), nil
+	default:
+		return nil, fmt.Errorf("auth mode %q is not supported", cfg.Mode)
+	}
+}
+
+// apiKeyAuth authenticates callers by the API key in the X-API-Key header or an Authorization bearer token
+func apiKeyAuth(service service.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(apiKeyHeader)
+		if key == "" {
+			key = bearerToken(c)
+		}
+
+		if key == "" {
+			abortUnauthorized(c, "An API key is required")
+			return
+		}
+
+		identity, err := service.AuthenticateAPIKey(c.Request.Context(), key)
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			abortWithError(c, err, "Authentication failed")
+			return
+		}
+
+		c.Request = c.Request.WithContext(types.WithIdentity(c.Request.Context(), identity))
+		c.Next()
+	}
+}
+
+// bearerToken returns the token from an Authorization header using the Bearer scheme
+func bearerToken(c *gin.Context) string {
+	scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+
+	return strings.TrimSpace(token)
+}
+
+// abortUnauthorized writes a 401 Problem asking the caller to authenticate
+func abortUnauthorized(c *gin.Context, detail string) {
+	c.Header("WWW-Authenticate", "Bearer")
+	abortWithProblem(c, http.StatusUnauthorized, detail)
+}
+
+// RequireScope stops requests from callers that were not granted the scope
+func RequireScope(scope types.Scope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		identity, ok := types.IdentityFromContext(c.Request.Context())
+		if !ok {
+			abortUnauthorized(c, "Authentication is required")
+			return
+		}
+
+		if !identity.HasScope(scope) {
+			abortWithProblem(c, http.StatusForbidden, fmt.Sprintf("The %s scope is required", scope))
+			return
+		}
+
+		c.Next()
+	}
+}
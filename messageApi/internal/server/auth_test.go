@@ -0,0 +1,143 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"messageApi/internal/database"
+	"messageApi/internal/logging"
+	"messageApi/internal/service"
+	"messageApi/internal/types"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestAuthNoneScopes tests every caller can use the message routes but not manage keys when auth is disabled
+func TestAuthNoneScopes(t *testing.T) {
+	srv, _ := NewServer(types.Config{}, &service.ServiceStub{}, logging.Discard())
+
+	for path, status := range map[string]int{"/v1/messages/1": http.StatusOK, "/v1/admin/keys": http.StatusForbidden} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+
+		srv.(*server).api.ServeHTTP(w, req)
+
+		assert.Equal(t, status, w.Code, path)
+	}
+}
+
+// TestAuthInvalidMode tests an unsupported auth mode stops the server being created
+func TestAuthInvalidMode(t *testing.T) {
+	_, err := NewServer(types.Config{Auth: types.AuthConfig{Mode: "password"}}, &service.ServiceStub{}, logging.Discard())
+
+	assert.EqualError(t, err, `auth mode "password" is not supported`)
+}
+
+// TestAPIKeyAuth tests API keys are required and their scopes are enforced per route
+func TestAPIKeyAuth(t *testing.T) {
+	stub := &service.ServiceStub{AuthenticateResponse: types.Identity{Subject: "apikey:1", Scopes: []types.Scope{types.ScopeRead}}}
+	srv, _ := NewServer(types.Config{Auth: types.AuthConfig{Mode: types.AuthAPIKey}}, stub, logging.Discard())
+
+	tests := []struct {
+		name   string
+		method string
+		header string
+		value  string
+		status int
+	}{
+		{"missing key", "GET", "", "", http.StatusUnauthorized},
+		{"api key header", "GET", "X-API-Key", "mk_abc_secret", http.StatusOK},
+		{"bearer token", "GET", "Authorization", "Bearer mk_abc_secret", http.StatusOK},
+		{"basic auth", "GET", "Authorization", "Basic dXNlcjpwYXNz", http.StatusUnauthorized},
+		{"missing scope", "DELETE", "X-API-Key", "mk_abc_secret", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, "/v1/messages/1", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+
+			srv.(*server).api.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.status, w.Code)
+			if tt.status == http.StatusUnauthorized {
+				assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+// TestAPIKeyAuthRejected tests a key the service rejects returns 401 with the reason
+func TestAPIKeyAuthRejected(t *testing.T) {
+	stub := &service.ServiceStub{AuthenticateError: fmt.Errorf("%w: invalid api key", types.ErrUnauthorized)}
+	srv, _ := NewServer(types.Config{Auth: types.AuthConfig{Mode: types.AuthAPIKey}}, stub, logging.Discard())
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("GET", "/v1/messages/1", nil)
+	req.Header.Set("X-API-Key", "mk_abc_wrong")
+
+	srv.(*server).api.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Bearer error="invalid_token"`, w.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, problemJSON(http.StatusUnauthorized, "Authentication failed: unauthorized: invalid api key", "/v1/messages/1"), withoutRequestId(w.Body.Bytes()))
+}
+
+// withoutRequestId removes the generated request id from a problem so it can be compared
+func withoutRequestId(body []byte) string {
+	var problem Problem
+	json.Unmarshal(body, &problem)
+	problem.RequestId = ""
+
+	data, _ := json.Marshal(problem)
+
+	return string(data)
+}
+
+// TestAPIKeyLifecycle tests the bootstrap key can issue a key that works until it is revoked
+func TestAPIKeyLifecycle(t *testing.T) {
+	cfg := types.Config{Auth: types.AuthConfig{Mode: types.AuthAPIKey, BootstrapKey: "bootstrap-secret"}}
+	svc, _ := service.NewService(cfg, database.NewMemoryDatabase(), logging.Discard())
+	srv, _ := NewServer(cfg, svc, logging.Discard())
+	api := srv.(*server).api
+
+	send := func(method string, path string, key string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("X-API-Key", key)
+		api.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("POST", "/v1/admin/keys", "bootstrap-secret", `{"name":"writer","scopes":["read","write"]}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+	var issued types.APIKey
+	json.Unmarshal(w.Body.Bytes(), &issued)
+	assert.NotEmpty(t, issued.Key)
+
+	w = send("POST", "/v1/messages", issued.Key, `{"message":"racecar"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = send("GET", "/v1/admin/keys", issued.Key, "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = send("GET", "/v1/admin/keys", "bootstrap-secret", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), issued.Key)
+	assert.NotContains(t, w.Body.String(), "hash")
+
+	w = send("DELETE", fmt.Sprintf("/v1/admin/keys/%d", issued.Id), "bootstrap-secret", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"revoked_at"`)
+
+	w = send("GET", "/v1/messages", issued.Key, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
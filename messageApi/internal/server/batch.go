@@ -0,0 +1,94 @@
+package server
+
+import (
+	"fmt"
+	"messageApi/internal/types"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// batchRequest is the body of a request to create, update and delete several Messages at once
+// A transactional batch makes every change or none of them
+type batchRequest struct {
+	Transactional bool                   `json:"transactional"`
+	Operations    []types.BatchOperation `json:"operations"`
+}
+
+// batchResult is the outcome of a single operation in a batch
+// Status is the status code the operation would get if it was sent as a request on its own
+type batchResult struct {
+	Status  int            `json:"status"`
+	Id      int            `json:"id,omitempty"`
+	Message *types.Message `json:"message,omitempty"`
+	Error   *Problem       `json:"error,omitempty"`
+}
+
+// batchResponse is the body returned for a batch, holding a result for each operation in the order they were sent
+type batchResponse struct {
+	Results []batchResult `json:"results"`
+	Failed  int           `json:"failed"`
+}
+
+// requireAction only continues requests for the custom action, such as batch in /messages:batch, and 404s any other
+// gin reads the colon as the start of a path parameter, so the route holds the action in a parameter that includes it
+func requireAction(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Param("action") != ":"+action {
+			noRouteHandler(c)
+		}
+	}
+}
+
+// BatchMessagesHandler handles requests to create, update and delete several Messages at once
+// The batch succeeds even when some of its operations fail, as each operation gets its own result
+func BatchMessagesHandler(c *gin.Context) {
+	service, ok := getService(c)
+	if !ok {
+		abortWithProblem(c, http.StatusInternalServerError, "Service unavailable")
+		return
+	}
+
+	var req batchRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithBindError(c, err)
+		return
+	}
+
+	results, err := service.ExecuteBatch(c.Request.Context(), req.Operations, req.Transactional)
+	if err != nil {
+		abortWithError(c, err, "Error executing batch")
+		return
+	}
+
+	response := batchResponse{Results: make([]batchResult, len(results))}
+	for i, result := range results {
+		response.Results[i] = newBatchResult(c, i, req.Operations[i].Op, result)
+		if result.Err != nil {
+			response.Failed++
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// newBatchResult creates the result for the operation at the index from the outcome returned by the service module
+func newBatchResult(c *gin.Context, index int, op types.BatchOp, result types.BatchResult) batchResult {
+	if result.Err != nil {
+		problem := problemForError(c, result.Err, fmt.Sprintf("Error in operation %d", index))
+		if problem.Status >= http.StatusInternalServerError {
+			c.Error(fmt.Errorf("operation %d: %w", index, result.Err))
+		}
+		return batchResult{Status: problem.Status, Error: &problem}
+	}
+
+	switch op {
+	case types.BatchCreate:
+		return batchResult{Status: http.StatusCreated, Id: result.Message.Id, Message: &result.Message}
+	case types.BatchUpdate:
+		return batchResult{Status: http.StatusOK, Id: result.Message.Id, Message: &result.Message}
+	default:
+		return batchResult{Status: http.StatusOK, Id: result.Message.Id}
+	}
+}
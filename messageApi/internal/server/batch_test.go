@@ -0,0 +1,156 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"messageApi/internal/database"
+	"messageApi/internal/logging"
+	"messageApi/internal/service"
+	"messageApi/internal/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupBatchServer creates a server backed by an in-memory database holding a single Message
+func setupBatchServer(t *testing.T) *gin.Engine {
+	svc, _ := service.NewService(types.Config{}, database.NewMemoryDatabase(), logging.Discard())
+	srv, _ := NewServer(types.Config{}, svc, logging.Discard())
+	api := srv.(*server).api
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/v1/messages", strings.NewReader(`{"message": "test"}`))
+	api.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	return api
+}
+
+// sendBatch sends the body to the batch endpoint and decodes the response
+func sendBatch(api *gin.Engine, body string) (*httptest.ResponseRecorder, batchResponse) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/v1/messages:batch", strings.NewReader(body))
+	api.ServeHTTP(w, req)
+
+	var response batchResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+
+	return w, response
+}
+
+// TestBatchMessages tests each operation in a batch gets the result it would get as a request on its own
+func TestBatchMessages(t *testing.T) {
+	api := setupBatchServer(t)
+
+	w, response := sendBatch(api, `{"operations": [
+		{"op": "create", "message": "racecar"},
+		{"op": "update", "id": 1, "version": 1, "message": "level", "palindromemode": "exact"},
+		{"op": "create", "message": ""},
+		{"op": "delete", "id": 404}
+	]}`)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 2, response.Failed)
+	assert.Equal(t, 4, len(response.Results))
+
+	assert.Equal(t, http.StatusCreated, response.Results[0].Status)
+	assert.Equal(t, 2, response.Results[0].Id)
+	assert.Equal(t, &types.Message{Id: 2, Message: "racecar", IsPalindrome: true, PalindromeMode: types.PalindromeExact, Owner: "anonymous", Version: 1}, response.Results[0].Message)
+
+	assert.Equal(t, http.StatusOK, response.Results[1].Status)
+	assert.Equal(t, 2, response.Results[1].Message.Version)
+
+	assert.Equal(t, http.StatusBadRequest, response.Results[2].Status)
+	assert.Equal(t, "message", response.Results[2].Error.Errors[0].Field)
+	assert.Equal(t, "/v1/messages:batch", response.Results[2].Error.Instance)
+
+	assert.Equal(t, http.StatusNotFound, response.Results[3].Status)
+	assert.Equal(t, (*types.Message)(nil), response.Results[3].Message)
+
+	_, response = sendBatch(api, `{"operations": [{"op": "delete", "id": 2}]}`)
+	assert.Equal(t, []batchResult{{Status: http.StatusOK, Id: 2}}, response.Results)
+}
+
+// TestBatchMessagesTransactional tests no operation in a transactional batch is made when one of them fails
+func TestBatchMessagesTransactional(t *testing.T) {
+	api := setupBatchServer(t)
+
+	w, response := sendBatch(api, `{"transactional": true, "operations": [
+		{"op": "create", "message": "racecar"},
+		{"op": "update", "id": 1, "version": 2, "message": "level"}
+	]}`)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 2, response.Failed)
+	assert.Equal(t, http.StatusFailedDependency, response.Results[0].Status)
+	assert.Equal(t, http.StatusPreconditionFailed, response.Results[1].Status)
+
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/messages", nil)
+	api.ServeHTTP(w, req)
+	assert.Equal(t, 1, strings.Count(w.Body.String(), `"id"`))
+}
+
+// TestBatchMessagesInvalidRequest tests a Problem is returned when the batch as a whole cannot be handled
+func TestBatchMessagesInvalidRequest(t *testing.T) {
+	api := setupBatchServer(t)
+
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+	}{
+		{"unknown action", "/v1/messages:purge", `{"operations": [{"op": "create", "message": "racecar"}]}`, http.StatusNotFound},
+		{"invalid body", "/v1/messages:batch", `{"operations": {}}`, http.StatusBadRequest},
+		{"empty", "/v1/messages:batch", `{"operations": []}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+			api.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+		})
+	}
+}
+
+// TestBatchMessagesError tests an error is returned when the call to the service fails
+func TestBatchMessagesError(t *testing.T) {
+	service_stub := service.ServiceStub{ExecuteBatchError: errors.New("batch failed")}
+	w := httptest.NewRecorder()
+	router := setupPostRouter(&service_stub, BatchMessagesHandler)
+
+	req, _ := http.NewRequest("POST", "/", strings.NewReader(`{"transactional": true, "operations": [{"op": "create", "message": "racecar"}]}`))
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, problemJSON(http.StatusInternalServerError, "Error executing batch: an unexpected error occurred", "/"), w.Body.String())
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, true, service_stub.ExecuteBatchTransaction)
+	assert.Equal(t, []types.BatchOperation{{Op: types.BatchCreate, Message: types.Message{Message: "racecar"}}}, service_stub.ExecuteBatchInput)
+}
+
+// TestBatchMessagesServerError tests the error of an operation failing with a 5xx is logged rather than sent to the client
+func TestBatchMessagesServerError(t *testing.T) {
+	var logs bytes.Buffer
+	logger, _ := logging.NewLogger(types.LogConfig{Format: logging.FormatJSON}, &logs)
+	results := []types.BatchResult{{Err: errors.New(`relation "public.messages" does not exist`)}}
+	srv, _ := NewServer(types.Config{}, &service.ServiceStub{ExecuteBatchResponse: results}, logger)
+
+	w, response := sendBatch(srv.(*server).api, `{"operations": [{"op": "create", "message": "racecar"}]}`)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, http.StatusInternalServerError, response.Results[0].Status)
+	assert.Equal(t, "Error in operation 0: "+unexpectedErrorDetail, response.Results[0].Error.Detail)
+	assert.NotContains(t, w.Body.String(), "public.messages")
+	assert.Contains(t, logs.String(), `operation 0: relation \"public.messages\" does not exist`)
+}
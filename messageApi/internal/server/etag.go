@@ -0,0 +1,116 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// messageETag returns the strong entity tag for a Message, which changes whenever its version does
+func messageETag(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// bodyETag returns a strong entity tag for a response body, used for lists where no single version applies
+func bodyETag(body []byte) string {
+	sum := sha256.Sum256(body)
+
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// entityTags splits a conditional request header into its entity tags
+func entityTags(header string) []string {
+	tags := []string{}
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags
+}
+
+// notModified writes a 304 response when the If-None-Match header matches the entity tag
+// Tags are compared weakly as RFC 9110 requires for If-None-Match, so a W/ prefix is ignored
+func notModified(c *gin.Context, etag string) bool {
+	header := c.GetHeader("If-None-Match")
+	if header == "" {
+		return false
+	}
+
+	for _, tag := range entityTags(header) {
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			c.Header("ETag", etag)
+			c.AbortWithStatus(http.StatusNotModified)
+			return true
+		}
+	}
+
+	return false
+}
+
+// ifMatchVersion reads the version of the Message the caller expects to change from the If-Match header
+// The version is 0 when the header is missing or *, so the Message is changed at any version
+// A Problem is written when the header cannot match any version of a Message
+func ifMatchVersion(c *gin.Context) (int, bool) {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return 0, true
+	}
+
+	tags := entityTags(header)
+	if len(tags) == 1 && tags[0] == "*" {
+		return 0, true
+	}
+
+	if len(tags) != 1 {
+		abortWithProblem(c, http.StatusBadRequest, "If-Match must hold a single entity tag or *")
+		return 0, false
+	}
+
+	version, ok := parseMessageETag(tags[0])
+	if !ok {
+		abortWithProblem(c, http.StatusPreconditionFailed, fmt.Sprintf("If-Match %s does not match the message", tags[0]))
+		return 0, false
+	}
+
+	return version, true
+}
+
+// parseMessageETag reads the version from a strong entity tag returned by messageETag
+// Weak tags are rejected as If-Match uses strong comparison
+func parseMessageETag(tag string) (int, bool) {
+	value, ok := strings.CutPrefix(tag, `"`)
+	if !ok {
+		return 0, false
+	}
+
+	value, ok = strings.CutSuffix(value, `"`)
+	if !ok {
+		return 0, false
+	}
+
+	version, err := strconv.Atoi(value)
+	if err != nil || version < 1 {
+		return 0, false
+	}
+
+	return version, true
+}
+
+// RequireIfMatch stops requests without an If-Match header when required is set, so callers cannot overwrite changes they have not seen
+func RequireIfMatch(required bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if required && c.GetHeader("If-Match") == "" {
+			abortWithProblem(c, http.StatusPreconditionRequired, "An If-Match header with the ETag of the message is required")
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"messageApi/internal/database"
+	"messageApi/internal/logging"
+	"messageApi/internal/service"
+	"messageApi/internal/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestConditionalRequests tests ETags change with each update and are checked by If-Match and If-None-Match
+func TestConditionalRequests(t *testing.T) {
+	svc, _ := service.NewService(types.Config{}, database.NewMemoryDatabase(), logging.Discard())
+	srv, _ := NewServer(types.Config{}, svc, logging.Discard())
+	api := srv.(*server).api
+
+	send := func(method string, path string, header string, value string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		api.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("POST", "/v1/messages", "", "", `{"message": "racecar"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+
+	w = send("GET", "/v1/messages/1", "", "", "")
+	assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+
+	w = send("GET", "/v1/messages/1", "If-None-Match", `W/"1"`, "")
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+	assert.Equal(t, "", w.Body.String())
+
+	list := send("GET", "/v1/messages", "", "", "")
+	assert.Equal(t, http.StatusOK, list.Code)
+	assert.NotEqual(t, "", list.Header().Get("ETag"))
+	assert.Equal(t, http.StatusNotModified, send("GET", "/v1/messages", "If-None-Match", list.Header().Get("ETag"), "").Code)
+
+	w = send("POST", "/v1/messages/1", "If-Match", `"1"`, `{"message": "level"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+
+	assert.Equal(t, http.StatusOK, send("GET", "/v1/messages/1", "If-None-Match", `"1"`, "").Code)
+	assert.Equal(t, http.StatusOK, send("GET", "/v1/messages", "If-None-Match", list.Header().Get("ETag"), "").Code)
+
+	w = send("POST", "/v1/messages/1", "If-Match", `"1"`, `{"message": "kayak"}`)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	assert.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+
+	assert.Equal(t, http.StatusPreconditionFailed, send("DELETE", "/v1/messages/1", "If-Match", `"1"`, "").Code)
+	assert.Equal(t, http.StatusOK, send("DELETE", "/v1/messages/1", "If-Match", `"2"`, "").Code)
+	assert.Equal(t, http.StatusNotFound, send("DELETE", "/v1/messages/1", "If-Match", `"2"`, "").Code)
+}
+
+// TestIfMatchHeader tests the version sent to the service is read from the If-Match header
+func TestIfMatchHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		status  int
+		version int
+	}{
+		{"missing", "", http.StatusOK, 0},
+		{"any", "*", http.StatusOK, 0},
+		{"version", `"3"`, http.StatusOK, 3},
+		{"weak", `W/"3"`, http.StatusPreconditionFailed, 0},
+		{"unquoted", "3", http.StatusPreconditionFailed, 0},
+		{"unknown", `"abc"`, http.StatusPreconditionFailed, 0},
+		{"list", `"3", "4"`, http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service_stub := service.ServiceStub{}
+			router := setupDeleteRouterWithId(&service_stub, DeleteMessageHandler)
+			w := httptest.NewRecorder()
+
+			req, _ := http.NewRequest("DELETE", "/1", nil)
+			if tt.header != "" {
+				req.Header.Set("If-Match", tt.header)
+			}
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, tt.version, service_stub.DeleteMessageVersion)
+		})
+	}
+}
+
+// TestUpdateMessageIfMatch tests the version from If-Match replaces any version in the body
+func TestUpdateMessageIfMatch(t *testing.T) {
+	service_stub := service.ServiceStub{UpdateMessageResponse: types.Message{Id: 1, Message: "level", Version: 5}}
+	router := setupPostRouterWithId(&service_stub, UpdateMessageHandler)
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("POST", "/1", strings.NewReader(`{"message": "level", "version": 9}`))
+	req.Header.Set("If-Match", `"4"`)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 4, service_stub.UpdateMessageInput.Version)
+	assert.Equal(t, `"5"`, w.Header().Get("ETag"))
+}
+
+// TestRequireIfMatch tests updates and deletes without If-Match are rejected when it is required
+func TestRequireIfMatch(t *testing.T) {
+	srv, _ := NewServer(types.Config{Server: types.ServerConfig{RequireIfMatch: true}}, &service.ServiceStub{}, logging.Discard())
+	api := srv.(*server).api
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/v1/messages/1", nil)
+	api.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusPreconditionRequired, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/v1/messages/1", strings.NewReader(`{"message": "level"}`))
+	api.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusPreconditionRequired, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/v1/messages/1", nil)
+	req.Header.Set("If-Match", "*")
+	api.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/v1/messages/1", nil)
+	api.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
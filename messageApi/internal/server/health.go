@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Statuses reported by the health endpoints
+const (
+	StatusOk          = "ok"
+	StatusUnavailable = "unavailable"
+)
+
+// errShuttingDown is reported by the shutdown check once the server has started shutting down
+var errShuttingDown = errors.New("server is shutting down")
+
+// HealthReport represents the overall health of the server along with the result of each check
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// CheckResult represents the outcome of a single health check and how long it took
+type CheckResult struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// healthCheck represents a named dependency check run by /readyz
+type healthCheck struct {
+	name  string
+	check func(context.Context) error
+}
+
+// healthzHandler reports the process is alive, without checking any dependencies
+func healthzHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, HealthReport{Status: StatusOk})
+}
+
+// readyzHandler reports whether the server can handle requests, returning 503 when any check fails
+func (s *server) readyzHandler(c *gin.Context) {
+	report := runChecks(c.Request.Context(), s.config.HealthCheckTimeout, s.readinessChecks())
+
+	status := http.StatusOK
+	if report.Status != StatusOk {
+		status = http.StatusServiceUnavailable
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(status, report)
+}
+
+// readinessChecks returns the checks that must pass for the server to be ready
+func (s *server) readinessChecks() []healthCheck {
+	return []healthCheck{
+		{"database", s.service.Ping},
+		{"migrations", s.service.CheckMigrations},
+		{"shutdown", func(context.Context) error {
+			if s.shuttingDown.Load() {
+				return errShuttingDown
+			}
+			return nil
+		}},
+	}
+}
+
+// runChecks runs the checks concurrently, each limited by the timeout when it is greater than zero
+func runChecks(ctx context.Context, timeout time.Duration, checks []healthCheck) HealthReport {
+	report := HealthReport{Status: StatusOk, Checks: make(map[string]CheckResult, len(checks))}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, hc := range checks {
+		wg.Add(1)
+		go func(hc healthCheck) {
+			defer wg.Done()
+
+			checkCtx := ctx
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				checkCtx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+
+			start := time.Now()
+			err := hc.check(checkCtx)
+			result := CheckResult{Status: StatusOk, LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				result.Status = StatusUnavailable
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[hc.name] = result
+			if err != nil {
+				report.Status = StatusUnavailable
+			}
+		}(hc)
+	}
+	wg.Wait()
+
+	return report
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"messageApi/internal/types"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// idempotencyKeyHeader is the header clients send so a retried request is only handled once
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// idempotentReplayedHeader is added to responses replayed for a retried request
+const idempotentReplayedHeader = "Idempotent-Replayed"
+
+// replayedHeaders are the response headers stored with the response to a request and sent again when it is replayed
+var replayedHeaders = []string{"Content-Type", "ETag"}
+
+// recordingWriter copies the response body as it is written so it can be stored for retries
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+// Write copies the data then writes it to the response
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// WriteString copies the string then writes it to the response
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// IdempotencyMiddleware handles a request with an Idempotency-Key once per caller and replays the response to retries
+// Requests without the header are handled as normal. Responses with a 5xx status are not stored so the request can be retried
+// The body is buffered to identify the request, so it must already be limited by BodyLimitMiddleware
+func IdempotencyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, ok := c.Request.Header[idempotencyKeyHeader]
+		if !ok {
+			c.Next()
+			return
+		}
+
+		service, ok := getService(c)
+		if !ok {
+			abortWithProblem(c, http.StatusInternalServerError, "Service unavailable")
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			abortWithBodyError(c, err)
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		record, replay, err := service.BeginIdempotentRequest(c.Request.Context(), key[0], requestHash(c.Request, body))
+		if err != nil {
+			abortWithError(c, err, "Idempotency-Key cannot be used")
+			return
+		}
+
+		if replay {
+			replayResponse(c, record)
+			return
+		}
+
+		writer := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		// the response has already been sent, so it is stored even when the request context has been cancelled
+		ctx := context.WithoutCancel(c.Request.Context())
+		handled := false
+		defer func() {
+			if !handled {
+				if err := service.AbandonIdempotentRequest(ctx, key[0]); err != nil {
+					c.Error(err)
+				}
+			}
+		}()
+
+		c.Next()
+
+		if writer.Status() >= http.StatusInternalServerError {
+			return
+		}
+		handled = true
+
+		headers := map[string]string{}
+		for _, name := range replayedHeaders {
+			if value := writer.Header().Get(name); value != "" {
+				headers[name] = value
+			}
+		}
+
+		record = types.IdempotencyRecord{Key: key[0], Status: writer.Status(), Headers: headers, Body: writer.body.Bytes()}
+		if err := service.CompleteIdempotentRequest(ctx, record); err != nil {
+			c.Error(err)
+		}
+	}
+}
+
+// requestHash identifies the method, path, query, If-Match header and body of a request so a key reused for a different
+// request can be detected
+func requestHash(req *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(req.Method + " " + req.URL.Path + "?" + req.URL.RawQuery + "\n"))
+	hash.Write([]byte("If-Match: " + req.Header.Get("If-Match") + "\n"))
+	hash.Write(body)
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// replayResponse writes the stored response for a retried request and stops any remaining handlers from running
+func replayResponse(c *gin.Context, record types.IdempotencyRecord) {
+	for name, value := range record.Headers {
+		c.Header(name, value)
+	}
+	c.Header(idempotentReplayedHeader, "true")
+
+	c.Writer.WriteHeader(record.Status)
+	c.Writer.Write(record.Body)
+	c.Abort()
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"messageApi/internal/database"
+	"messageApi/internal/logging"
+	"messageApi/internal/service"
+	"messageApi/internal/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestIdempotentCreate tests a retried create returns the first response without creating another Message
+func TestIdempotentCreate(t *testing.T) {
+	db := database.NewMemoryDatabase()
+	svc, _ := service.NewService(types.Config{}, db, logging.Discard())
+	srv, _ := NewServer(types.Config{}, svc, logging.Discard())
+	api := srv.(*server).api
+
+	send := func(method string, path string, key string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(idempotencyKeyHeader, key)
+		api.ServeHTTP(w, req)
+		return w
+	}
+
+	first := send("POST", "/v1/messages", "create-1", `{"message": "racecar"}`)
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.Equal(t, "", first.Header().Get(idempotentReplayedHeader))
+
+	retry := send("POST", "/v1/messages", "create-1", `{"message": "racecar"}`)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, first.Header().Get("Content-Type"), retry.Header().Get("Content-Type"))
+	assert.Equal(t, `"1"`, retry.Header().Get("ETag"))
+	assert.Equal(t, "true", retry.Header().Get(idempotentReplayedHeader))
+
+	count, _ := db.CountMessages(context.Background())
+	assert.Equal(t, 1, count)
+
+	reused := send("POST", "/v1/messages", "create-1", `{"message": "kayak"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, reused.Code)
+	assert.Equal(t, problemContentType, reused.Header().Get("Content-Type"))
+
+	assert.Equal(t, http.StatusUnprocessableEntity, send("POST", "/v1/messages/1", "create-1", `{"message": "racecar"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, send("POST", "/v1/messages", "", `{"message": "racecar"}`).Code)
+
+	update := send("POST", "/v1/messages/1", "update-1", `{"message": "level"}`)
+	assert.Equal(t, http.StatusOK, update.Code)
+	assert.Equal(t, `"2"`, update.Header().Get("ETag"))
+
+	retry = send("POST", "/v1/messages/1", "update-1", `{"message": "level"}`)
+	assert.Equal(t, update.Body.String(), retry.Body.String())
+	assert.Equal(t, `"2"`, retry.Header().Get("ETag"))
+
+	msg, _ := db.GetMessage(context.Background(), 1)
+	assert.Equal(t, 2, msg.Version)
+}
+
+// TestIdempotentRequestFailure tests a key is released when the request fails with a 5xx so it can be retried
+func TestIdempotentRequestFailure(t *testing.T) {
+	service_stub := service.ServiceStub{CreateMessageError: errors.New("create message failed")}
+	router := gin.New()
+	router.Use(ServiceMiddleware(&service_stub))
+	router.POST("/", IdempotencyMiddleware(), CreateMessageHandler)
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("POST", "/", strings.NewReader(`{"message": "racecar"}`))
+	req.Header.Set(idempotencyKeyHeader, "create-1")
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "create-1", service_stub.BeginIdempotentInput)
+	assert.Equal(t, "create-1", service_stub.AbandonIdempotentInput)
+	assert.Equal(t, types.IdempotencyRecord{}, service_stub.CompleteIdempotentInput)
+}
+
+// TestIdempotentRequestInProgress tests a retry sent while the first request is still being handled gets a 409
+func TestIdempotentRequestInProgress(t *testing.T) {
+	service_stub := service.ServiceStub{BeginIdempotentError: service.ErrConflict}
+	router := gin.New()
+	router.Use(ServiceMiddleware(&service_stub))
+	router.POST("/", IdempotencyMiddleware(), CreateMessageHandler)
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("POST", "/", strings.NewReader(`{"message": "racecar"}`))
+	req.Header.Set(idempotencyKeyHeader, "create-1")
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "", service_stub.AbandonIdempotentInput)
+}
+
+// TestIdempotentBodyTooLarge tests a request with an Idempotency-Key and a body over the limit gets a 413 without reserving the key
+func TestIdempotentBodyTooLarge(t *testing.T) {
+	service_stub := service.ServiceStub{}
+	router := gin.New()
+	router.Use(ServiceMiddleware(&service_stub), BodyLimitMiddleware(16))
+	router.POST("/", IdempotencyMiddleware(), CreateMessageHandler)
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("POST", "/", strings.NewReader(`{"message": "racecar"}`))
+	req.Header.Set(idempotencyKeyHeader, "create-1")
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+	assert.Equal(t, "", service_stub.BeginIdempotentInput)
+}
+
+// TestRequestHash tests requests differing only in their query or If-Match header are identified as different requests
+func TestRequestHash(t *testing.T) {
+	body := []byte(`{"message": "level"}`)
+	newRequest := func(target string, ifMatch string) *http.Request {
+		req, _ := http.NewRequest("PUT", target, nil)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		return req
+	}
+
+	hash := requestHash(newRequest("/v1/messages/1", `"1"`), body)
+	assert.Equal(t, hash, requestHash(newRequest("/v1/messages/1", `"1"`), body))
+	assert.NotEqual(t, hash, requestHash(newRequest("/v1/messages/1", `"2"`), body))
+	assert.NotEqual(t, hash, requestHash(newRequest("/v1/messages/1", ""), body))
+	assert.NotEqual(t, hash, requestHash(newRequest("/v1/messages/1?mode=exact", `"1"`), body))
+}
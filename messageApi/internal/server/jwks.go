@@ -0,0 +1,274 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// minJWKSRefreshInterval limits how often a token signed by an unknown key can cause the JWKS to be reloaded
+const minJWKSRefreshInterval = 10 * time.Second
+
+// maxJWKSSize is the largest JWKS document that will be read
+const maxJWKSSize = 1 << 20
+
+// jwk represents a single JSON Web Key from a JWKS document
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwksCache holds the public keys from a JWKS, reloading them when they are stale or a token uses an unknown key
+// Keys that fail to reload are kept so a temporary failure of the identity provider does not reject every token
+// The lock is only held to read or replace the keys, and concurrent reloads share a single fetch
+type jwksCache struct {
+	load            func(context.Context) ([]byte, error)
+	refreshInterval time.Duration
+	logger          *slog.Logger
+	group           singleflight.Group
+
+	mu        sync.RWMutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	now       func() time.Time
+}
+
+// newJWKSCache creates a cache for the JWKS at the URL, or in the file when no URL is set, loading the keys once
+func newJWKSCache(ctx context.Context, url string, file string, refreshInterval time.Duration, logger *slog.Logger) (*jwksCache, error) {
+	var load func(context.Context) ([]byte, error)
+	switch {
+	case url != "":
+		client := &http.Client{Timeout: 10 * time.Second}
+		load = func(ctx context.Context) ([]byte, error) {
+			return fetchJWKS(ctx, client, url)
+		}
+	case file != "":
+		load = func(context.Context) ([]byte, error) {
+			return os.ReadFile(file)
+		}
+	default:
+		return nil, errors.New("the jwt auth mode requires a JWKS URL or file")
+	}
+
+	cache := &jwksCache{load: load, refreshInterval: refreshInterval, logger: logger, now: time.Now}
+	if err := cache.refresh(ctx, 0); err != nil {
+		return nil, err
+	}
+
+	return cache, nil
+}
+
+// fetchJWKS downloads the JWKS document from the URL
+func fetchJWKS(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS from %s returned %s", url, resp.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+}
+
+// key returns the public key with the id, reloading the JWKS when it is stale or does not hold the key
+// A stale JWKS is reloaded in the background while the cached key is used, and an unknown key waits for the reload,
+// which happens at most once every minJWKSRefreshInterval. An empty id is only accepted when the JWKS holds a single key
+func (j *jwksCache) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	j.mu.RLock()
+	age := j.now().Sub(j.fetchedAt)
+	key, ok := j.lookup(kid)
+	j.mu.RUnlock()
+
+	switch {
+	case ok && j.refreshInterval > 0 && age >= j.refreshInterval:
+		go j.reload(context.WithoutCancel(ctx), j.refreshInterval)
+	case !ok && age >= minJWKSRefreshInterval:
+		j.reload(ctx, minJWKSRefreshInterval)
+
+		j.mu.RLock()
+		key, ok = j.lookup(kid)
+		j.mu.RUnlock()
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("signing key %q is not in the JWKS", kid)
+	}
+
+	return key, nil
+}
+
+// reload refreshes the keys, sharing the fetch with any reload already running and logging a failure
+func (j *jwksCache) reload(ctx context.Context, minAge time.Duration) {
+	_, err, _ := j.group.Do("jwks", func() (any, error) {
+		return nil, j.refresh(ctx, minAge)
+	})
+	if err != nil {
+		j.logger.WarnContext(ctx, "reloading JWKS failed, keeping the previous keys", "error", err)
+	}
+}
+
+// lookup returns the key with the id from the loaded keys while the lock is held
+func (j *jwksCache) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key, true
+		}
+	}
+
+	key, ok := j.keys[kid]
+
+	return key, ok
+}
+
+// refresh reloads the keys from the JWKS unless they were fetched less than minAge ago, such as by a reload that
+// finished just before. The fetch time is updated before fetching, even when it fails, so a broken JWKS is not reloaded
+// for every request, and the lock is not held while fetching
+func (j *jwksCache) refresh(ctx context.Context, minAge time.Duration) error {
+	j.mu.Lock()
+	if !j.fetchedAt.IsZero() && j.now().Sub(j.fetchedAt) < minAge {
+		j.mu.Unlock()
+		return nil
+	}
+	j.fetchedAt = j.now()
+	j.mu.Unlock()
+
+	data, err := j.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	j.keys = keys
+	j.mu.Unlock()
+
+	return nil
+}
+
+// parseJWKS reads the signing keys from a JWKS document, skipping keys that are not for signatures or not supported
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("JWKS is not valid JSON: %w", err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+
+		keys[k.Kid] = key
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS does not hold any supported signing keys")
+	}
+
+	return keys, nil
+}
+
+// publicKey converts an RSA or EC JSON Web Key into a public key
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("RSA exponent is too large")
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("curve %q is not supported", k.Crv)
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("key type %q is not supported", k.Kty)
+	}
+}
+
+// decodeBigInt decodes a base64url encoded big-endian integer from a JSON Web Key
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("key parameter is not valid base64url")
+	}
+
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"messageApi/internal/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwtSigningMethods are the asymmetric algorithms accepted for bearer tokens
+// Symmetric algorithms are never accepted so a public key from the JWKS cannot be used as an HMAC secret
+var jwtSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// jwtAuth authenticates callers by a JWT in an Authorization bearer token, signed by a key in the JWKS
+func jwtAuth(cfg types.JWTConfig, keys *jwksCache) gin.HandlerFunc {
+	options := []jwt.ParserOption{jwt.WithValidMethods(jwtSigningMethods), jwt.WithLeeway(cfg.Leeway), jwt.WithExpirationRequired()}
+	if cfg.Issuer != "" {
+		options = append(options, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		options = append(options, jwt.WithAudience(cfg.Audience))
+	}
+	parser := jwt.NewParser(options...)
+
+	return func(c *gin.Context) {
+		token := bearerToken(c)
+		if token == "" {
+			abortUnauthorized(c, "A bearer token is required")
+			return
+		}
+
+		identity, err := authenticateJWT(c.Request.Context(), parser, keys, cfg, token)
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			abortWithError(c, err, "Authentication failed")
+			return
+		}
+
+		c.Request = c.Request.WithContext(types.WithIdentity(c.Request.Context(), identity))
+		c.Next()
+	}
+}
+
+// authenticateJWT validates the token and maps its claims to an Identity, including the tenant when the token has one
+func authenticateJWT(ctx context.Context, parser *jwt.Parser, keys *jwksCache, cfg types.JWTConfig, token string) (types.Identity, error) {
+	claims := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+
+		return keys.key(ctx, kid)
+	})
+	if err != nil {
+		return types.Identity{}, fmt.Errorf("%w: %v", types.ErrUnauthorized, err)
+	}
+
+	subject, _ := claims[cfg.SubjectClaim].(string)
+	if subject == "" {
+		return types.Identity{}, fmt.Errorf("%w: token has no %s claim", types.ErrUnauthorized, cfg.SubjectClaim)
+	}
+
+	identity := types.Identity{Subject: "jwt:" + subject, Scopes: jwtScopes(claims[cfg.ScopeClaim], cfg.ScopePrefix)}
+	if cfg.TenantClaim != "" {
+		identity.Tenant, _ = claims[cfg.TenantClaim].(string)
+	}
+
+	return identity, nil
+}
+
+// jwtScopes reads the supported scopes from a claim holding a space separated string or a list of strings
+// Only values starting with the prefix are used and the prefix is removed before matching them to a Scope
+func jwtScopes(claim any, prefix string) []types.Scope {
+	var values []string
+	switch claim := claim.(type) {
+	case string:
+		values = strings.Fields(claim)
+	case []any:
+		for _, value := range claim {
+			if value, ok := value.(string); ok {
+				values = append(values, value)
+			}
+		}
+	}
+
+	scopes := []types.Scope{}
+	for _, value := range values {
+		value, ok := strings.CutPrefix(value, prefix)
+		scope := types.Scope(value)
+		if ok && slices.Contains(types.Scopes, scope) && !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return scopes
+}
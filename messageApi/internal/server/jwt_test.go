@@ -0,0 +1,300 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"messageApi/internal/logging"
+	"messageApi/internal/service"
+	"messageApi/internal/types"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// testJWK returns the JSON Web Key for a generated RSA or EC public key
+func testJWK(kid string, key crypto.PublicKey) map[string]string {
+	encode := func(value *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(value.Bytes())
+	}
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kid": kid, "kty": "RSA", "use": "sig", "n": encode(key.N), "e": encode(big.NewInt(int64(key.E)))}
+	case *ecdsa.PublicKey:
+		return map[string]string{"kid": kid, "kty": "EC", "use": "sig", "crv": key.Curve.Params().Name, "x": encode(key.X), "y": encode(key.Y)}
+	}
+
+	return nil
+}
+
+// testJWKS returns a JWKS document holding the keys
+func testJWKS(keys ...map[string]string) []byte {
+	data, _ := json.Marshal(map[string]any{"keys": keys})
+
+	return data
+}
+
+// signTestToken signs the claims with the key, adding the kid header when it is set
+func signTestToken(method jwt.SigningMethod, key crypto.PrivateKey, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+
+	signed, _ := token.SignedString(key)
+
+	return signed
+}
+
+// testClaims returns valid claims for the issuer and audience used by the tests, with the extra claims added
+func testClaims(extra jwt.MapClaims) jwt.MapClaims {
+	claims := jwt.MapClaims{
+		"sub":   "user-1",
+		"iss":   "https://issuer.example.com",
+		"aud":   "messageApi",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+		"scope": "read write",
+	}
+	for name, value := range extra {
+		claims[name] = value
+	}
+
+	return claims
+}
+
+// testJWTConfig returns the auth config for a JWKS served at the URL
+func testJWTConfig(url string) types.Config {
+	return types.Config{Auth: types.AuthConfig{Mode: types.AuthJWT, JWT: types.JWTConfig{
+		JWKSURL:      url,
+		Issuer:       "https://issuer.example.com",
+		Audience:     "messageApi",
+		SubjectClaim: "sub",
+		ScopeClaim:   "scope",
+	}}}
+}
+
+// TestJWTAuth tests bearer tokens are validated against the JWKS and their scopes are enforced per route
+func TestJWTAuth(t *testing.T) {
+	rsa_key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ec_key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other_key, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testJWKS(testJWK("rsa-1", &rsa_key.PublicKey), testJWK("ec-1", &ec_key.PublicKey)))
+	}))
+	defer jwks.Close()
+
+	srv, err := NewServer(testJWTConfig(jwks.URL), &service.ServiceStub{}, logging.Discard())
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		status int
+	}{
+		{"missing token", "GET", "", http.StatusUnauthorized},
+		{"rsa token", "GET", signTestToken(jwt.SigningMethodRS256, rsa_key, "rsa-1", testClaims(nil)), http.StatusOK},
+		{"ec token", "GET", signTestToken(jwt.SigningMethodES256, ec_key, "ec-1", testClaims(nil)), http.StatusOK},
+		{"scope list", "DELETE", signTestToken(jwt.SigningMethodRS256, rsa_key, "rsa-1", testClaims(jwt.MapClaims{"scope": []string{"write"}})), http.StatusOK},
+		{"missing scope", "DELETE", signTestToken(jwt.SigningMethodRS256, rsa_key, "rsa-1", testClaims(jwt.MapClaims{"scope": "read"})), http.StatusForbidden},
+		{"expired", "GET", signTestToken(jwt.SigningMethodRS256, rsa_key, "rsa-1", testClaims(jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()})), http.StatusUnauthorized},
+		{"no expiry", "GET", signTestToken(jwt.SigningMethodRS256, rsa_key, "rsa-1", testClaims(jwt.MapClaims{"exp": nil})), http.StatusUnauthorized},
+		{"wrong issuer", "GET", signTestToken(jwt.SigningMethodRS256, rsa_key, "rsa-1", testClaims(jwt.MapClaims{"iss": "https://other.example.com"})), http.StatusUnauthorized},
+		{"wrong audience", "GET", signTestToken(jwt.SigningMethodRS256, rsa_key, "rsa-1", testClaims(jwt.MapClaims{"aud": "other"})), http.StatusUnauthorized},
+		{"missing subject", "GET", signTestToken(jwt.SigningMethodRS256, rsa_key, "rsa-1", testClaims(jwt.MapClaims{"sub": nil})), http.StatusUnauthorized},
+		{"unknown key", "GET", signTestToken(jwt.SigningMethodRS256, other_key, "rsa-2", testClaims(nil)), http.StatusUnauthorized},
+		{"wrong key", "GET", signTestToken(jwt.SigningMethodRS256, other_key, "rsa-1", testClaims(nil)), http.StatusUnauthorized},
+		{"hmac token", "GET", signTestToken(jwt.SigningMethodHS256, []byte("secret"), "rsa-1", testClaims(nil)), http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, "/v1/messages/1", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			srv.(*server).api.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.status, w.Code)
+			if tt.status == http.StatusUnauthorized && tt.token != "" {
+				assert.Equal(t, `Bearer error="invalid_token"`, w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+// TestJWTIdentity tests the subject, prefixed scopes and tenant of a token are added to the request context
+func TestJWTIdentity(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	os.WriteFile(file, testJWKS(testJWK("", &key.PublicKey)), 0o600)
+
+	cfg := types.JWTConfig{JWKSFile: file, SubjectClaim: "email", ScopeClaim: "scp", ScopePrefix: "messages:", TenantClaim: "org"}
+	auth, err := AuthMiddleware(types.AuthConfig{Mode: types.AuthJWT, JWT: cfg}, &service.ServiceStub{}, logging.Discard())
+	assert.Nil(t, err)
+
+	var identity types.Identity
+	r := gin.New()
+	r.GET("/", auth, func(c *gin.Context) {
+		identity, _ = types.IdentityFromContext(c.Request.Context())
+	})
+	w := httptest.NewRecorder()
+
+	token := signTestToken(jwt.SigningMethodES384, key, "", jwt.MapClaims{
+		"email": "user@example.com",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"scp":   []string{"messages:read", "messages:admin", "write", "messages:unknown"},
+		"org":   "team-a",
+	})
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, types.Identity{Subject: "jwt:user@example.com", Scopes: []types.Scope{types.ScopeRead, types.ScopeAdmin}, Tenant: "team-a"}, identity)
+}
+
+// TestJWKSMissing tests the server cannot be created when the JWKS cannot be loaded
+func TestJWKSMissing(t *testing.T) {
+	_, err := NewServer(types.Config{Auth: types.AuthConfig{Mode: types.AuthJWT}}, &service.ServiceStub{}, logging.Discard())
+	assert.EqualError(t, err, "loading JWKS: the jwt auth mode requires a JWKS URL or file")
+
+	_, err = NewServer(testJWTConfig("http://127.0.0.1:1/jwks.json"), &service.ServiceStub{}, logging.Discard())
+	assert.ErrorContains(t, err, "loading JWKS:")
+
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	os.WriteFile(file, testJWKS(map[string]string{"kid": "enc", "kty": "RSA", "use": "enc", "n": "AQAB", "e": "AQAB"}), 0o600)
+
+	_, err = NewServer(types.Config{Auth: types.AuthConfig{Mode: types.AuthJWT, JWT: types.JWTConfig{JWKSFile: file}}}, &service.ServiceStub{}, logging.Discard())
+	assert.EqualError(t, err, "loading JWKS: JWKS does not hold any supported signing keys")
+}
+
+// TestJWKSRotation tests a token signed by a new key is accepted once the JWKS is reloaded
+// Unknown keys only cause a reload after minJWKSRefreshInterval, stale keys are reloaded in the background and failed
+// reloads keep the previous keys
+func TestJWKSRotation(t *testing.T) {
+	old_key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	new_key, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	var mu sync.Mutex
+	document := testJWKS(testJWK("old", &old_key.PublicKey))
+	fetches := 0
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetches++
+		if document == nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(document)
+	}))
+	defer jwks.Close()
+	fetched := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return fetches
+	}
+
+	cache, err := newJWKSCache(context.Background(), jwks.URL, "", time.Hour, logging.Discard())
+	assert.Nil(t, err)
+
+	clock := time.Now()
+	cache.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return clock
+	}
+	advance := func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		clock = clock.Add(d)
+	}
+
+	mu.Lock()
+	document = testJWKS(testJWK("old", &old_key.PublicKey), testJWK("new", &new_key.PublicKey))
+	mu.Unlock()
+
+	_, err = cache.key(context.Background(), "new")
+	assert.EqualError(t, err, `signing key "new" is not in the JWKS`)
+	assert.Equal(t, 1, fetched())
+
+	advance(minJWKSRefreshInterval)
+	key, err := cache.key(context.Background(), "new")
+	assert.Nil(t, err)
+	assert.Equal(t, &new_key.PublicKey, key)
+	assert.Equal(t, 2, fetched())
+
+	mu.Lock()
+	document = nil
+	mu.Unlock()
+
+	advance(time.Hour)
+	key, err = cache.key(context.Background(), "old")
+	assert.Nil(t, err)
+	assert.Equal(t, &old_key.PublicKey, key)
+	assert.Eventually(t, func() bool { return fetched() == 3 }, time.Second, time.Millisecond)
+
+	key, err = cache.key(context.Background(), "old")
+	assert.Nil(t, err)
+	assert.Equal(t, &old_key.PublicKey, key)
+}
+
+// TestJWKSConcurrentReload tests lookups of known keys do not wait for a reload and concurrent reloads share one fetch
+func TestJWKSConcurrentReload(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetches.Add(1) > 1 {
+			<-release
+		}
+		w.Write(testJWKS(testJWK("known", &key.PublicKey)))
+	}))
+	defer jwks.Close()
+
+	cache, err := newJWKSCache(context.Background(), jwks.URL, "", 0, logging.Discard())
+	assert.Nil(t, err)
+	cache.now = func() time.Time { return time.Now().Add(minJWKSRefreshInterval) }
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache.key(context.Background(), "unknown")
+		}()
+	}
+
+	assert.Eventually(t, func() bool { return fetches.Load() == 2 }, time.Second, time.Millisecond)
+
+	found, err := cache.key(context.Background(), "known")
+	assert.Nil(t, err)
+	assert.Equal(t, &key.PublicKey, found)
+
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(2), fetches.Load())
+}
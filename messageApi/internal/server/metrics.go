@@ -0,0 +1,72 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// unmatchedRoute labels requests that did not match a route so unknown paths cannot add new series
+const unmatchedRoute = "unmatched"
+
+// httpMetrics holds the request metrics recorded by the server module
+type httpMetrics struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+// newHTTPMetrics creates the request metrics recorded by the server module
+func newHTTPMetrics() httpMetrics {
+	return httpMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "messageapi_http_requests_total",
+			Help: "Number of HTTP requests handled, split by method, route template and status.",
+		}, []string{"method", "route", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "messageapi_http_request_duration_seconds",
+			Help:    "Time taken to handle HTTP requests, split by method, route template and status.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+	}
+}
+
+// newRegistry creates a registry holding the request metrics, the Go runtime and process metrics,
+// and the metrics of any other collectors such as the service module
+func newRegistry(metrics httpMetrics, others ...any) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		metrics.requests,
+		metrics.duration,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	for _, other := range others {
+		if collector, ok := other.(prometheus.Collector); ok {
+			registry.MustRegister(collector)
+		}
+	}
+
+	return registry
+}
+
+// MetricsMiddleware records the count and duration of requests labelled by their route template
+func MetricsMiddleware(metrics httpMetrics) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		status := strconv.Itoa(c.Writer.Status())
+
+		metrics.requests.WithLabelValues(c.Request.Method, route, status).Inc()
+		metrics.duration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
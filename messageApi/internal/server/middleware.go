@@ -1,7 +1,15 @@
 package server
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"messageApi/internal/logging"
 	"messageApi/internal/service"
+	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -13,3 +21,114 @@ func ServiceMiddleware(service service.Service) gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// TimeoutMiddleware limits how long the request context stays valid so slow requests are cancelled
+// The request context is already cancelled when the client disconnects; a zero timeout only keeps that
+func TimeoutMiddleware(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if timeout <= 0 {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// defaultMaxBodyBytes is the largest request body accepted when it is not set in the config
+const defaultMaxBodyBytes = 10 << 20
+
+// BodyLimitMiddleware stops reading a request body once it is larger than maxBodyBytes
+// Handlers reading past the limit get an *http.MaxBytesError, which is written as a 413
+func BodyLimitMiddleware(maxBodyBytes int64) gin.HandlerFunc {
+	if maxBodyBytes <= 0 {
+		maxBodyBytes = defaultMaxBodyBytes
+	}
+
+	return func(c *gin.Context) {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBodyBytes)
+		c.Next()
+	}
+}
+
+// requestIdHeader is the header used to receive and return the id of a request
+const requestIdHeader = "X-Request-ID"
+
+// maxRequestIdLength is the longest request id accepted from a client
+const maxRequestIdLength = 128
+
+// RequestIdMiddleware adds the request id to the request context and response headers
+// The id from the X-Request-ID header is kept when it is safe to log, otherwise a new id is generated
+func RequestIdMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIdHeader)
+		if !isValidRequestId(id) {
+			id = newRequestId()
+		}
+
+		c.Request = c.Request.WithContext(logging.WithRequestId(c.Request.Context(), id))
+		c.Header(requestIdHeader, id)
+		c.Next()
+	}
+}
+
+// isValidRequestId checks the id is not too long and only holds letters, digits and - _ . :
+func isValidRequestId(id string) bool {
+	if id == "" || len(id) > maxRequestIdLength {
+		return false
+	}
+
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// newRequestId generates a random 128 bit request id
+func newRequestId() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
+
+// LoggerMiddleware logs each request once it has been handled, at error level for 5xx responses
+// Every error added to the gin context is included and requests that matched no route have an empty route
+func LoggerMiddleware(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		status := c.Writer.Status()
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("route", c.FullPath()),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int("bytes", c.Writer.Size()),
+			slog.String("client_ip", c.ClientIP()),
+		}
+
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("error", strings.Join(c.Errors.Errors(), "; ")))
+		}
+
+		logger.LogAttrs(c.Request.Context(), level, "handled request", attrs...)
+	}
+}
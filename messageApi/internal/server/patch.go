@@ -0,0 +1,133 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"messageApi/internal/types"
+	"net/http"
+	"strconv"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/gin-gonic/gin"
+)
+
+// Media types accepted for the body of a PATCH request
+const (
+	mergePatchContentType = "application/merge-patch+json"
+	jsonPatchContentType  = "application/json-patch+json"
+)
+
+// errUnsupportedPatch is returned for a PATCH body that is neither a merge patch nor a JSON Patch
+var errUnsupportedPatch = errors.New("unsupported patch media type")
+
+// PatchMessageHandler handles requests to partially update an existing Message
+// The body is a JSON merge patch (RFC 7386) or a JSON Patch (RFC 6902), chosen by the Content-Type, applied to the
+// current Message. The Message is then only updated while it is still at the version that was patched
+func PatchMessageHandler(c *gin.Context) {
+	service, ok := getService(c)
+	if !ok {
+		abortWithProblem(c, http.StatusInternalServerError, "Service unavailable")
+		return
+	}
+
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		abortWithProblem(c, http.StatusBadRequest, "Invalid Id", FieldError{Field: "id", Message: "must be an integer"})
+		return
+	}
+
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+
+	// the body is limited by BodyLimitMiddleware, so a patch larger than MAX_BODY_BYTES gets a 413
+	patch, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		abortWithBodyError(c, err)
+		return
+	}
+
+	current, err := service.GetMessage(c.Request.Context(), id)
+	if err != nil {
+		abortWithError(c, err, "Error retrieving message")
+		return
+	}
+
+	if version > 0 && version != current.Version {
+		abortWithError(c, fmt.Errorf("%w: message %d is at version %d, not %d", types.ErrPrecondition, id, current.Version, version), "Error updating message")
+		return
+	}
+
+	msg, err := applyPatch(c.ContentType(), current, patch)
+	if err != nil {
+		abortWithPatchError(c, err)
+		return
+	}
+
+	msg.Id = id
+	msg.Version = current.Version
+
+	msg, err = service.UpdateMessage(c.Request.Context(), msg)
+	if err != nil {
+		abortWithError(c, err, "Error updating message")
+		return
+	}
+
+	c.Header("ETag", messageETag(msg.Version))
+	c.JSON(http.StatusOK, msg)
+}
+
+// applyPatch applies a JSON merge patch or JSON Patch to the JSON representation of the Message
+// Fields set by the server, such as owner and ispalindrome, can be patched but are ignored like any other request body
+func applyPatch(contentType string, msg types.Message, patch []byte) (types.Message, error) {
+	document, err := json.Marshal(msg)
+	if err != nil {
+		return types.Message{}, err
+	}
+
+	var patched []byte
+	switch contentType {
+	case mergePatchContentType:
+		patched, err = jsonpatch.MergePatch(document, patch)
+	case jsonPatchContentType:
+		var operations jsonpatch.Patch
+		operations, err = jsonpatch.DecodePatch(patch)
+		if err == nil {
+			patched, err = operations.Apply(document)
+		}
+	default:
+		return types.Message{}, errUnsupportedPatch
+	}
+	if err != nil {
+		return types.Message{}, err
+	}
+
+	var result types.Message
+	if err := json.Unmarshal(patched, &result); err != nil {
+		return types.Message{}, err
+	}
+
+	return result, nil
+}
+
+// abortWithPatchError writes a Problem for a patch that could not be applied
+// A failed test operation is a conflict with the current Message, as RFC 5789 suggests
+func abortWithPatchError(c *gin.Context, err error) {
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.Is(err, errUnsupportedPatch):
+		c.Header("Accept-Patch", strings.Join([]string{mergePatchContentType, jsonPatchContentType}, ", "))
+		abortWithProblem(c, http.StatusUnsupportedMediaType, fmt.Sprintf("Content-Type must be %s or %s", mergePatchContentType, jsonPatchContentType))
+	case errors.Is(err, jsonpatch.ErrTestFailed):
+		abortWithProblem(c, http.StatusConflict, "The patch test operation failed")
+	case errors.As(err, &typeErr):
+		abortWithBindError(c, err)
+	default:
+		abortWithProblem(c, http.StatusBadRequest, fmt.Sprintf("Invalid patch: %v", err))
+	}
+}
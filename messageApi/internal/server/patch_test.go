@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"messageApi/internal/database"
+	"messageApi/internal/logging"
+	"messageApi/internal/service"
+	"messageApi/internal/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupPatchServer creates a server backed by an in-memory database holding a single case-insensitive palindrome
+func setupPatchServer(t *testing.T) *gin.Engine {
+	svc, _ := service.NewService(types.Config{}, database.NewMemoryDatabase(), logging.Discard())
+	srv, _ := NewServer(types.Config{}, svc, logging.Discard())
+	api := srv.(*server).api
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/v1/messages", strings.NewReader(`{"message": "Racecar", "palindromemode": "case-insensitive"}`))
+	api.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	return api
+}
+
+// sendPatch sends a request with a Content-Type to the server
+func sendPatch(api *gin.Engine, method string, contentType string, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, "/v1/messages/1", strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	api.ServeHTTP(w, req)
+	return w
+}
+
+// TestUpdateRoutes tests PUT, PATCH and POST each update the Message and recompute whether it is a palindrome
+func TestUpdateRoutes(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		contentType  string
+		body         string
+		message      string
+		mode         types.PalindromeMode
+		isPalindrome bool
+	}{
+		{"put", "PUT", "application/json", `{"message": "Level"}`, "Level", types.PalindromeExact, false},
+		{"post", "POST", "application/json", `{"message": "Level"}`, "Level", types.PalindromeExact, false},
+		{"merge patch", "PATCH", mergePatchContentType, `{"message": "Level"}`, "Level", types.PalindromeCaseInsensitive, true},
+		{"merge patch mode", "PATCH", mergePatchContentType, `{"palindromemode": "exact"}`, "Racecar", types.PalindromeExact, false},
+		{"merge patch remove mode", "PATCH", mergePatchContentType, `{"palindromemode": null}`, "Racecar", types.PalindromeExact, false},
+		{"merge patch read-only", "PATCH", mergePatchContentType, `{"id": 5, "ispalindrome": false, "version": 9}`, "Racecar", types.PalindromeCaseInsensitive, true},
+		{"json patch", "PATCH", jsonPatchContentType, `[{"op": "test", "path": "/message", "value": "Racecar"}, {"op": "replace", "path": "/message", "value": "Kayak"}]`, "Kayak", types.PalindromeCaseInsensitive, true},
+		{"json patch mode", "PATCH", jsonPatchContentType, `[{"op": "replace", "path": "/palindromemode", "value": "exact"}]`, "Racecar", types.PalindromeExact, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := setupPatchServer(t)
+
+			w := sendPatch(api, tt.method, tt.contentType, tt.body)
+			assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+
+			var msg types.Message
+			json.Unmarshal(w.Body.Bytes(), &msg)
+			assert.Equal(t, 1, msg.Id)
+			assert.Equal(t, tt.message, msg.Message)
+			assert.Equal(t, tt.mode, msg.PalindromeMode)
+			assert.Equal(t, tt.isPalindrome, msg.IsPalindrome)
+			assert.Equal(t, 2, msg.Version)
+		})
+	}
+}
+
+// TestPatchMessageError tests a Problem is returned for patches that cannot be applied to the Message
+func TestPatchMessageError(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"unsupported media type", "application/json", `{"message": "Level"}`, http.StatusUnsupportedMediaType},
+		{"invalid merge patch", mergePatchContentType, `{"message": `, http.StatusBadRequest},
+		{"invalid json patch", jsonPatchContentType, `{"op": "replace"}`, http.StatusBadRequest},
+		{"unknown operation", jsonPatchContentType, `[{"op": "rename", "path": "/message"}]`, http.StatusBadRequest},
+		{"missing path", jsonPatchContentType, `[{"op": "remove", "path": "/missing"}]`, http.StatusBadRequest},
+		{"failed test", jsonPatchContentType, `[{"op": "test", "path": "/message", "value": "Kayak"}]`, http.StatusConflict},
+		{"wrong type", mergePatchContentType, `{"message": 1234}`, http.StatusBadRequest},
+		{"invalid message", mergePatchContentType, `{"message": ""}`, http.StatusBadRequest},
+		{"invalid mode", mergePatchContentType, `{"palindromemode": "reversed"}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := setupPatchServer(t)
+
+			w := sendPatch(api, "PATCH", tt.contentType, tt.body)
+			assert.Equal(t, tt.status, w.Code, w.Body.String())
+			assert.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+
+			w = sendPatch(api, "GET", "", "")
+			assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+		})
+	}
+
+	api := setupPatchServer(t)
+	w := sendPatch(api, "PATCH", "text/plain", "Level")
+	assert.Equal(t, mergePatchContentType+", "+jsonPatchContentType, w.Header().Get("Accept-Patch"))
+}
+
+// TestPatchMessageIfMatch tests a patch is only applied to the version of the Message named by If-Match
+func TestPatchMessageIfMatch(t *testing.T) {
+	api := setupPatchServer(t)
+
+	send := func(etag string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", "/v1/messages/1", strings.NewReader(body))
+		req.Header.Set("Content-Type", mergePatchContentType)
+		req.Header.Set("If-Match", etag)
+		api.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusPreconditionFailed, send(`"2"`, `{"message": "Level"}`).Code)
+	assert.Equal(t, http.StatusOK, send(`"1"`, `{"message": "Level"}`).Code)
+	assert.Equal(t, http.StatusPreconditionFailed, send(`"1"`, `{"message": "Kayak"}`).Code)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PATCH", "/v1/messages/2", strings.NewReader(`{"message": "Level"}`))
+	req.Header.Set("Content-Type", mergePatchContentType)
+	api.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// TestPatchMessageServiceUnavailable tests that an error is returned when the service is not initialized
+func TestPatchMessageServiceUnavailable(t *testing.T) {
+	router := gin.New()
+	router.PATCH("/:id", PatchMessageHandler)
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("PATCH", "/1", strings.NewReader(`{"message": "racecar"}`))
+	req.Header.Set("Content-Type", mergePatchContentType)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, problemJSON(http.StatusInternalServerError, "Service unavailable", "/1"), w.Body.String())
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+// TestPatchMessageBodyTooLarge tests a 413 is returned for a patch larger than MAX_BODY_BYTES and the Message is unchanged
+func TestPatchMessageBodyTooLarge(t *testing.T) {
+	svc, _ := service.NewService(types.Config{}, database.NewMemoryDatabase(), logging.Discard())
+	srv, _ := NewServer(types.Config{Server: types.ServerConfig{MaxBodyBytes: 32}}, svc, logging.Discard())
+	api := srv.(*server).api
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/v1/messages", strings.NewReader(`{"message": "Racecar"}`))
+	api.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = sendPatch(api, "PATCH", mergePatchContentType, `{"message": "`+strings.Repeat("a", 64)+`"}`)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+
+	msg, _ := svc.GetMessage(context.Background(), 1)
+	assert.Equal(t, "Racecar", msg.Message)
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+
+	"messageApi/internal/logging"
+	"messageApi/internal/service"
+	"messageApi/internal/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// problemContentType is the media type for RFC 7807 problem details documents
+const problemContentType = "application/problem+json"
+
+// Details sent in place of the error for 5xx responses, as the error can hold database internals such as table names
+// The error itself is logged with the request id, which clients can find in the Problem
+const (
+	unexpectedErrorDetail = "an unexpected error occurred"
+	unavailableDetail     = "the service is temporarily unavailable"
+)
+
+// Problem represents an RFC 7807 problem details document returned for failed requests
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	RequestId string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError represents a validation failure for a single field of the request
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// newProblem creates a Problem for the status code and request
+// The type is left as about:blank so the title is always the standard text for the status code
+func newProblem(c *gin.Context, status int, detail string) Problem {
+	return Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  c.Request.URL.Path,
+		RequestId: logging.RequestId(c.Request.Context()),
+	}
+}
+
+// writeProblem writes the Problem to the response and stops any remaining handlers from running
+func writeProblem(c *gin.Context, problem Problem) {
+	c.Header("Content-Type", problemContentType)
+	c.AbortWithStatusJSON(problem.Status, problem)
+}
+
+// abortWithProblem writes a Problem for the status code with the detail to the response
+func abortWithProblem(c *gin.Context, status int, detail string, fieldErrors ...FieldError) {
+	problem := newProblem(c, status, detail)
+	problem.Errors = fieldErrors
+
+	writeProblem(c, problem)
+}
+
+// abortWithError writes a Problem for an error returned by the service module
+// The status code is chosen from the type of error and validation failures are listed per field
+func abortWithError(c *gin.Context, err error, action string) {
+	c.Error(err)
+
+	writeProblem(c, problemForError(c, err, action))
+}
+
+// problemForError creates a Problem for an error returned by the service module, listing validation failures per field
+// The error is only sent for 4xx responses, so callers must add it to the gin context to have 5xx errors logged
+func problemForError(c *gin.Context, err error, action string) Problem {
+	status := statusForError(err)
+
+	detail := fmt.Sprintf("%s: %v", action, err)
+	switch {
+	case status == http.StatusServiceUnavailable:
+		detail = fmt.Sprintf("%s: %s", action, unavailableDetail)
+	case status >= http.StatusInternalServerError:
+		detail = fmt.Sprintf("%s: %s", action, unexpectedErrorDetail)
+	}
+
+	problem := newProblem(c, status, detail)
+
+	var validationErr *types.ValidationError
+	if errors.As(err, &validationErr) {
+		problem.Errors = []FieldError{{Field: validationErr.Field, Message: validationErr.Message}}
+	}
+
+	return problem
+}
+
+// abortWithBindError writes a Problem for a request body that could not be bound
+// Fields with the wrong JSON type are listed so the client can see which value was rejected
+func abortWithBindError(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		abortWithBodyError(c, err)
+		return
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		abortWithProblem(c, http.StatusBadRequest, "Invalid body", FieldError{Field: typeErr.Field, Message: fmt.Sprintf("must be of type %s", typeErr.Type)})
+		return
+	}
+
+	abortWithProblem(c, http.StatusBadRequest, "Invalid body")
+}
+
+// abortWithBodyError writes a Problem for a request body that could not be read
+// A body larger than the limit set by BodyLimitMiddleware gets a 413
+func abortWithBodyError(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		abortWithProblem(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("Body is larger than %d bytes", tooLarge.Limit))
+		return
+	}
+
+	abortWithProblem(c, http.StatusBadRequest, "Invalid body")
+}
+
+// statusForError maps an error returned by the service module to the matching HTTP status code
+func statusForError(err error) int {
+	switch {
+	case errors.Is(err, service.ErrValidation):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrPrecondition):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, service.ErrPreconditionRequired):
+		return http.StatusPreconditionRequired
+	case errors.Is(err, service.ErrIdempotency):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, service.ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, service.ErrForbidden), errors.Is(err, service.ErrQuota):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrAborted):
+		return http.StatusFailedDependency
+	case errors.Is(err, service.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// noRouteHandler returns a Problem for requests that do not match any route
+func noRouteHandler(c *gin.Context) {
+	abortWithProblem(c, http.StatusNotFound, fmt.Sprintf("No route matches %s", c.Request.URL.Path))
+}
+
+// noMethodHandler returns a Problem for requests using a method the route does not support
+func noMethodHandler(c *gin.Context) {
+	abortWithProblem(c, http.StatusMethodNotAllowed, fmt.Sprintf("Method %s is not allowed for %s", c.Request.Method, c.Request.URL.Path))
+}
+
+// recoveryHandler returns a gin.RecoveryFunc that logs the panic and returns a Problem
+func recoveryHandler(logger *slog.Logger) gin.RecoveryFunc {
+	return func(c *gin.Context, err any) {
+		logger.ErrorContext(c.Request.Context(), "recovered from panic", "panic", err, "stack", string(debug.Stack()))
+		abortWithProblem(c, http.StatusInternalServerError, "An unexpected error occurred")
+	}
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"messageApi/internal/ratelimit"
+	"messageApi/internal/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// anyMethod matches every method in a rate limit rule
+const anyMethod = "*"
+
+// rateLimits holds the limit for each method and route template, and the default for routes without a rule
+type rateLimits struct {
+	rules        map[string]ratelimit.Limit
+	defaultLimit *ratelimit.Limit
+}
+
+// newRateLimits parses the default limit and the rules from the config
+func newRateLimits(cfg types.RateLimitConfig) (rateLimits, error) {
+	limits := rateLimits{rules: map[string]ratelimit.Limit{}}
+
+	if cfg.Default != "" {
+		limit, err := ratelimit.ParseLimit(cfg.Default)
+		if err != nil {
+			return rateLimits{}, err
+		}
+		limits.defaultLimit = &limit
+	}
+
+	for key, value := range cfg.Rules {
+		fields := strings.Fields(key)
+		if len(fields) != 2 || !strings.HasPrefix(fields[1], "/") {
+			return rateLimits{}, fmt.Errorf("rate limit rule %q must be in the form <method> <route>", key)
+		}
+
+		limit, err := ratelimit.ParseLimit(value)
+		if err != nil {
+			return rateLimits{}, err
+		}
+		limits.rules[strings.ToUpper(fields[0])+" "+fields[1]] = limit
+	}
+
+	return limits, nil
+}
+
+// limitFor returns the limit for the method and route, preferring a rule for the method over one for any method
+func (l rateLimits) limitFor(method string, route string) (ratelimit.Limit, bool) {
+	if limit, ok := l.rules[method+" "+route]; ok {
+		return limit, true
+	}
+
+	if limit, ok := l.rules[anyMethod+" "+route]; ok {
+		return limit, true
+	}
+
+	if l.defaultLimit != nil {
+		return *l.defaultLimit, true
+	}
+
+	return ratelimit.Limit{}, false
+}
+
+// RateLimitMiddleware limits how often each client can call each route, returning 429 once its bucket is empty
+// Clients are identified by their Identity, or by IP address when authentication is disabled, so it must run after authentication
+// Requests are allowed when the store fails so an outage of a shared store does not take the API down
+func RateLimitMiddleware(cfg types.RateLimitConfig, store ratelimit.Store, logger *slog.Logger) (gin.HandlerFunc, error) {
+	limits, err := newRateLimits(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(c *gin.Context) {
+		limit, ok := limits.limitFor(c.Request.Method, c.FullPath())
+		if !ok {
+			c.Next()
+			return
+		}
+
+		key := c.Request.Method + " " + c.FullPath() + "|" + rateLimitClient(c)
+		if takeRateLimit(c, store, key, limit, logger) {
+			c.Next()
+		}
+	}, nil
+}
+
+// IPRateLimitMiddleware limits how often each IP address can call any route, returning 429 once its bucket is empty
+// It runs before authentication, so callers sending invalid credentials are limited as well as authenticated ones
+func IPRateLimitMiddleware(cfg types.RateLimitConfig, store ratelimit.Store, logger *slog.Logger) (gin.HandlerFunc, error) {
+	if cfg.PerIP == "" {
+		return func(c *gin.Context) { c.Next() }, nil
+	}
+
+	limit, err := ratelimit.ParseLimit(cfg.PerIP)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(c *gin.Context) {
+		if takeRateLimit(c, store, "ip|"+c.ClientIP(), limit, logger) {
+			c.Next()
+		}
+	}, nil
+}
+
+// takeRateLimit takes a request from the bucket for the key and sets the rate limit headers
+// A 429 is written and false returned when the bucket is empty; the request is allowed when the store fails
+func takeRateLimit(c *gin.Context, store ratelimit.Store, key string, limit ratelimit.Limit, logger *slog.Logger) bool {
+	result, err := store.Take(c.Request.Context(), key, limit)
+	if err != nil {
+		logger.WarnContext(c.Request.Context(), "rate limit store failed, allowing request", "error", err)
+		return true
+	}
+
+	c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.ResetAfter)))
+	c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Requests, ceilSeconds(limit.Period)))
+
+	if !result.Allowed {
+		c.Header("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+		abortWithProblem(c, http.StatusTooManyRequests, fmt.Sprintf("Rate limit of %s exceeded, retry after %d seconds", limit, ceilSeconds(result.RetryAfter)))
+		return false
+	}
+
+	return true
+}
+
+// rateLimitClient returns the key identifying the client, using the subject of an authenticated caller and the IP address otherwise
+func rateLimitClient(c *gin.Context) string {
+	if identity, ok := types.IdentityFromContext(c.Request.Context()); ok && identity.Subject != types.AnonymousSubject {
+		return identity.Subject
+	}
+
+	return "ip:" + c.ClientIP()
+}
+
+// ceilSeconds converts the Duration to whole seconds, rounding up so clients never retry too early
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
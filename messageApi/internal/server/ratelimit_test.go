@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"messageApi/internal/logging"
+	"messageApi/internal/ratelimit"
+	"messageApi/internal/service"
+	"messageApi/internal/types"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// failingStore is a ratelimit.Store that always fails, like a shared store that cannot be reached
+type failingStore struct{}
+
+// Take returns an error for every request
+func (failingStore) Take(ctx context.Context, key string, limit ratelimit.Limit) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("connection refused")
+}
+
+// TestRateLimit tests each client is limited per route and method and gets 429 with the rate limit headers once limited
+func TestRateLimit(t *testing.T) {
+	cfg := types.Config{RateLimit: types.RateLimitConfig{
+		Enabled: true,
+		Default: "3/1m",
+		Rules:   map[string]string{"POST /v1/messages": "1/1m", "* /v1/messages/:id": "2/1m"},
+	}}
+	srv, err := NewServer(cfg, &service.ServiceStub{}, logging.Discard())
+	assert.Nil(t, err)
+
+	send := func(method string, path string, ip string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, nil)
+		req.RemoteAddr = ip + ":1234"
+		srv.(*server).api.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("POST", "/v1/messages", "10.0.0.1")
+	assert.NotEqual(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "60", w.Header().Get("RateLimit-Reset"))
+	assert.Equal(t, "1;w=60", w.Header().Get("RateLimit-Policy"))
+
+	w = send("POST", "/v1/messages", "10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+	assert.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+
+	assert.NotEqual(t, http.StatusTooManyRequests, send("POST", "/v1/messages", "10.0.0.2").Code)
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, send("GET", "/v1/messages", "10.0.0.1").Code)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, send("GET", "/v1/messages", "10.0.0.1").Code)
+
+	assert.Equal(t, http.StatusOK, send("GET", "/v1/messages/1", "10.0.0.1").Code)
+	assert.Equal(t, http.StatusOK, send("DELETE", "/v1/messages/1", "10.0.0.1").Code)
+	assert.Equal(t, "2", send("DELETE", "/v1/messages/1", "10.0.0.1").Header().Get("RateLimit-Limit"))
+}
+
+// TestRateLimitByIdentity tests authenticated callers are limited by their identity rather than their address
+func TestRateLimitByIdentity(t *testing.T) {
+	stub := &service.ServiceStub{AuthenticateResponse: types.Identity{Subject: "apikey:1", Scopes: []types.Scope{types.ScopeRead}}}
+	cfg := types.Config{Auth: types.AuthConfig{Mode: types.AuthAPIKey}, RateLimit: types.RateLimitConfig{Enabled: true, Default: "1/1m"}}
+	srv, _ := NewServer(cfg, stub, logging.Discard())
+
+	send := func(ip string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/messages/1", nil)
+		req.RemoteAddr = ip + ":1234"
+		req.Header.Set("X-API-Key", "mk_abc_secret")
+		srv.(*server).api.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, send("10.0.0.1"))
+	assert.Equal(t, http.StatusTooManyRequests, send("10.0.0.2"))
+}
+
+// TestRateLimitPerIP tests requests with invalid credentials are limited by IP address before they are authenticated
+func TestRateLimitPerIP(t *testing.T) {
+	stub := &service.ServiceStub{AuthenticateError: service.ErrUnauthorized}
+	cfg := types.Config{Auth: types.AuthConfig{Mode: types.AuthAPIKey}, RateLimit: types.RateLimitConfig{Enabled: true, PerIP: "2/1m"}}
+	srv, _ := NewServer(cfg, stub, logging.Discard())
+
+	send := func(ip string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/messages/1", nil)
+		req.RemoteAddr = ip + ":1234"
+		req.Header.Set("X-API-Key", "mk_abc_guess")
+		srv.(*server).api.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, send("10.0.0.1"))
+	assert.Equal(t, http.StatusUnauthorized, send("10.0.0.1"))
+	assert.Equal(t, http.StatusTooManyRequests, send("10.0.0.1"))
+	assert.Equal(t, http.StatusUnauthorized, send("10.0.0.2"))
+}
+
+// TestRateLimitStoreFailure tests requests are allowed without rate limit headers when the store fails
+func TestRateLimitStoreFailure(t *testing.T) {
+	limiter, _ := RateLimitMiddleware(types.RateLimitConfig{Default: "1/1m"}, failingStore{}, logging.Discard())
+	router := gin.New()
+	router.Use(ServiceMiddleware(&service.ServiceStub{}), limiter)
+	router.GET("/", ListMessageHandler)
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/", nil)
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "", w.Header().Get("RateLimit-Limit"))
+	}
+}
+
+// TestRateLimitInvalidConfig tests invalid limits and rules stop the server being created
+func TestRateLimitInvalidConfig(t *testing.T) {
+	configs := map[string]types.RateLimitConfig{
+		`rate limit "fast" must be in the form <requests>/<period>`:           {Enabled: true, Default: "fast"},
+		`rate limit rule "/v1/messages" must be in the form <method> <route>`: {Enabled: true, Rules: map[string]string{"/v1/messages": "1/s"}},
+		`rate limit "0/s" must allow a positive number of requests`:           {Enabled: true, Rules: map[string]string{"GET /v1/messages": "0/s"}},
+		`rate limit "many" must be in the form <requests>/<period>`:           {Enabled: true, PerIP: "many"},
+	}
+
+	for expected, cfg := range configs {
+		_, err := NewServer(types.Config{RateLimit: cfg}, &service.ServiceStub{}, logging.Discard())
+		assert.EqualError(t, err, expected)
+	}
+}
@@ -1,40 +1,160 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"messageApi/internal/ratelimit"
 	"messageApi/internal/service"
 	"messageApi/internal/types"
 )
 
 // Server is the interface for the server module of the application
 type Server interface {
-	RunServer()
+	RunServer() error
 }
 
 // server is the implementation of the server module
 type server struct {
 	service service.Service
 	api     *gin.Engine
+	config  types.ServerConfig
+	logger  *slog.Logger
+
+	// shuttingDown is set once a shutdown starts so /readyz stops reporting the server as ready
+	shuttingDown atomic.Bool
 }
 
 // NewServer creates an instance of the server module
-func NewServer(cfg types.Config, service service.Service) (Server, error) {
-	r := gin.Default()
+func NewServer(cfg types.Config, service service.Service, logger *slog.Logger) (Server, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	metrics := newHTTPMetrics()
+	registry := newRegistry(metrics, service)
+
+	r := gin.New()
+	r.Use(
+		RequestIdMiddleware(),
+		TracingMiddleware(),
+		LoggerMiddleware(logger),
+		MetricsMiddleware(metrics),
+		gin.CustomRecoveryWithWriter(io.Discard, recoveryHandler(logger)),
+	)
 	r.SetTrustedProxies(nil)
 
+	r.HandleMethodNotAllowed = true
+	r.NoRoute(noRouteHandler)
+	r.NoMethod(noMethodHandler)
+
+	s := &server{service: service, api: r, config: cfg.Server, logger: logger}
+	r.GET("/healthz", healthzHandler)
+	r.GET("/readyz", s.readyzHandler)
+	r.GET("/metrics", gin.WrapH(promhttp.HandlerFor(registry, promhttp.HandlerOpts{})))
+
+	auth, err := AuthMiddleware(cfg.Auth, service, logger)
+	if err != nil {
+		return nil, err
+	}
+
 	v1Group := r.Group("/v1")
-	addV1Routes(v1Group, service)
+	v1Group.Use(TimeoutMiddleware(cfg.Server.RequestTimeout))
+
+	// the limit per IP address runs before authentication so requests with invalid credentials are limited too
+	if cfg.RateLimit.Enabled {
+		store := ratelimit.NewMemoryStore()
+		ipRateLimit, err := IPRateLimitMiddleware(cfg.RateLimit, store, logger)
+		if err != nil {
+			return nil, err
+		}
+		rateLimit, err := RateLimitMiddleware(cfg.RateLimit, store, logger)
+		if err != nil {
+			return nil, err
+		}
+		v1Group.Use(ipRateLimit, auth, rateLimit)
+	} else {
+		v1Group.Use(auth)
+	}
 
-	return &server{service, r}, nil
+	v1Group.Use(TenantMiddleware(cfg.Tenant))
+	addV1Routes(v1Group, service, cfg.Server)
+
+	return s, nil
 }
 
-// RunServer starts running the server
-func (s *server) RunServer() {
-	s.api.Run()
+// RunServer listens on the configured address and serves requests until SIGINT or SIGTERM is received
+// In-flight requests are given until the shutdown timeout to complete before RunServer returns
+func (s *server) RunServer() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(s.config.Host, strconv.Itoa(s.config.Port)))
+	if err != nil {
+		return err
+	}
+
+	s.logger.Info("listening for requests", "address", listener.Addr().String())
+
+	return s.serve(ctx, listener)
 }
 
-// errorAsJSON converts an error message to a JSON payload for return in the response body
-func errorAsJSON(msg string) map[string]string {
-	return map[string]string{"error": msg}
+// serve handles requests from the listener until the context is done, then shuts down gracefully
+func (s *server) serve(ctx context.Context, listener net.Listener) error {
+	httpServer := &http.Server{
+		Handler:        s.api,
+		ReadTimeout:    s.config.ReadTimeout,
+		WriteTimeout:   s.config.WriteTimeout,
+		IdleTimeout:    s.config.IdleTimeout,
+		MaxHeaderBytes: s.config.MaxHeaderBytes,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- httpServer.Serve(listener)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	s.logger.Info("shutting down", "delay", s.config.ShutdownDelay, "timeout", s.config.ShutdownTimeout)
+
+	// report not ready and keep serving for the delay so load balancers can stop sending requests
+	s.shuttingDown.Store(true)
+	if s.config.ShutdownDelay > 0 {
+		time.Sleep(s.config.ShutdownDelay)
+	}
+
+	shutdownCtx := context.Background()
+	if s.config.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		shutdownCtx, cancel = context.WithTimeout(shutdownCtx, s.config.ShutdownTimeout)
+		defer cancel()
+	}
+
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
 }
@@ -1,17 +1,410 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"messageApi/internal/database"
+	"messageApi/internal/logging"
+	"messageApi/internal/service"
+	"messageApi/internal/types"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
-// TestErrorAsJSON tests converting a string into a map successfully
-func TestErrorAsJSON(t *testing.T) {
-	msg := "an error has occurred"
-	msgJSON := map[string]string{"error": msg}
+// TestNoRoute tests a problem is returned for requests that do not match a route
+func TestNoRoute(t *testing.T) {
+	srv, _ := NewServer(types.Config{}, &service.ServiceStub{}, logging.Discard())
+	w := httptest.NewRecorder()
 
-	jsonError := errorAsJSON(msg)
+	req, _ := http.NewRequest("GET", "/v2/messages", nil)
+	req.Header.Set("X-Request-ID", "test-request")
 
-	assert.Equal(t, msgJSON, jsonError)
+	srv.(*server).api.ServeHTTP(w, req)
+
+	var problem Problem
+	json.Unmarshal(w.Body.Bytes(), &problem)
+
+	assert.Equal(t, Problem{Type: "about:blank", Title: "Not Found", Status: http.StatusNotFound, Detail: "No route matches /v2/messages", Instance: "/v2/messages", RequestId: "test-request"}, problem)
+	assert.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// TestNoMethod tests a problem is returned for requests using an unsupported method
+func TestNoMethod(t *testing.T) {
+	srv, _ := NewServer(types.Config{}, &service.ServiceStub{}, logging.Discard())
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("PATCH", "/v1/messages", nil)
+
+	srv.(*server).api.ServeHTTP(w, req)
+
+	assert.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+// TestAbortWithErrorFieldErrors tests validation failures are listed per field in the problem
+func TestAbortWithErrorFieldErrors(t *testing.T) {
+	router := gin.New()
+	router.GET("/", func(c *gin.Context) {
+		abortWithError(c, &types.ValidationError{Field: "message", Message: "message cannot be an empty string"}, "Error saving message")
+	})
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("GET", "/", nil)
+
+	router.ServeHTTP(w, req)
+
+	var problem Problem
+	json.Unmarshal(w.Body.Bytes(), &problem)
+
+	assert.Equal(t, []FieldError{{Field: "message", Message: "message cannot be an empty string"}}, problem.Errors)
+	assert.Equal(t, http.StatusBadRequest, problem.Status)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// TestStatusForError tests errors from the service module are mapped to the matching status code
+func TestStatusForError(t *testing.T) {
+	assert.Equal(t, http.StatusBadRequest, statusForError(&types.ValidationError{Field: "message", Message: "invalid"}))
+	assert.Equal(t, http.StatusNotFound, statusForError(fmt.Errorf("message 1 %w", service.ErrNotFound)))
+	assert.Equal(t, http.StatusConflict, statusForError(fmt.Errorf("%w: duplicate", service.ErrConflict)))
+	assert.Equal(t, http.StatusForbidden, statusForError(fmt.Errorf("%w: tenant \"team-a\" can store at most 5 messages", service.ErrQuota)))
+	assert.Equal(t, http.StatusPreconditionRequired, statusForError(fmt.Errorf("%w: the version of message 1 must be given", service.ErrPreconditionRequired)))
+	assert.Equal(t, http.StatusFailedDependency, statusForError(fmt.Errorf("%w: operation 1 was not made", service.ErrAborted)))
+	assert.Equal(t, http.StatusServiceUnavailable, statusForError(fmt.Errorf("%w: timeout", service.ErrUnavailable)))
+	assert.Equal(t, http.StatusInternalServerError, statusForError(errors.New("unknown")))
+}
+
+// TestTimeoutMiddleware tests the request context is given a deadline from the timeout
+func TestTimeoutMiddleware(t *testing.T) {
+	var deadline time.Time
+	var hasDeadline bool
+	router := gin.New()
+	router.Use(TimeoutMiddleware(time.Minute))
+	router.GET("/", func(c *gin.Context) {
+		deadline, hasDeadline = c.Request.Context().Deadline()
+	})
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("GET", "/", nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.True(t, hasDeadline)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, time.Second)
+}
+
+// TestTimeoutMiddlewareDisabled tests no deadline is added when the timeout is zero
+func TestTimeoutMiddlewareDisabled(t *testing.T) {
+	hasDeadline := true
+	router := gin.New()
+	router.Use(TimeoutMiddleware(0))
+	router.GET("/", func(c *gin.Context) {
+		_, hasDeadline = c.Request.Context().Deadline()
+	})
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("GET", "/", nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.False(t, hasDeadline)
+}
+
+// TestServeGracefulShutdown tests in-flight requests are completed when the server is shut down
+func TestServeGracefulShutdown(t *testing.T) {
+	srv, _ := NewServer(types.Config{Server: types.ServerConfig{ShutdownTimeout: 5 * time.Second}}, &service.ServiceStub{}, logging.Discard())
+	started := make(chan struct{})
+	srv.(*server).api.GET("/slow", func(c *gin.Context) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		c.Status(http.StatusOK)
+	})
+
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.(*server).serve(ctx, listener)
+	}()
+
+	responses := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(fmt.Sprintf("http://%s/slow", listener.Addr()))
+		if err != nil {
+			responses <- 0
+			return
+		}
+		resp.Body.Close()
+		responses <- resp.StatusCode
+	}()
+
+	<-started
+	cancel()
+
+	assert.Equal(t, http.StatusOK, <-responses)
+	assert.Equal(t, nil, <-served)
+	assert.True(t, srv.(*server).shuttingDown.Load())
+
+	_, err := http.Get(fmt.Sprintf("http://%s/slow", listener.Addr()))
+	assert.Error(t, err)
+}
+
+// TestServeShutdownTimeout tests an error is returned when in-flight requests outlast the shutdown timeout
+func TestServeShutdownTimeout(t *testing.T) {
+	srv, _ := NewServer(types.Config{Server: types.ServerConfig{ShutdownTimeout: 10 * time.Millisecond}}, &service.ServiceStub{}, logging.Discard())
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srv.(*server).api.GET("/stuck", func(c *gin.Context) {
+		close(started)
+		<-release
+	})
+	defer close(release)
+
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.(*server).serve(ctx, listener)
+	}()
+
+	go http.Get(fmt.Sprintf("http://%s/stuck", listener.Addr()))
+
+	<-started
+	cancel()
+
+	assert.Equal(t, context.DeadlineExceeded, <-served)
+}
+
+// TestHealthz tests the liveness endpoint reports ok without checking dependencies
+func TestHealthz(t *testing.T) {
+	srv, _ := NewServer(types.Config{}, &service.ServiceStub{PingError: errors.New("down")}, logging.Discard())
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+
+	srv.(*server).api.ServeHTTP(w, req)
+
+	assert.Equal(t, `{"status":"ok"}`, w.Body.String())
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+// TestReadyz tests the readiness endpoint reports each check and fails when any dependency is unavailable
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name         string
+		stub         *service.ServiceStub
+		shuttingDown bool
+		status       int
+		failed       map[string]string
+	}{
+		{"ready", &service.ServiceStub{}, false, http.StatusOK, map[string]string{}},
+		{"database down", &service.ServiceStub{PingError: errors.New("connection refused")}, false, http.StatusServiceUnavailable, map[string]string{"database": "connection refused"}},
+		{"migrations pending", &service.ServiceStub{CheckMigrationsError: errors.New("migrations have not been applied")}, false, http.StatusServiceUnavailable, map[string]string{"migrations": "migrations have not been applied"}},
+		{"shutting down", &service.ServiceStub{}, true, http.StatusServiceUnavailable, map[string]string{"shutdown": errShuttingDown.Error()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := NewServer(types.Config{}, tt.stub, logging.Discard())
+			srv.(*server).shuttingDown.Store(tt.shuttingDown)
+			w := httptest.NewRecorder()
+
+			req, _ := http.NewRequest("GET", "/readyz", nil)
+
+			srv.(*server).api.ServeHTTP(w, req)
+
+			var report HealthReport
+			json.Unmarshal(w.Body.Bytes(), &report)
+
+			assert.Equal(t, tt.status, w.Code)
+			assert.Len(t, report.Checks, 3)
+			for name, result := range report.Checks {
+				assert.Equal(t, tt.failed[name], result.Error, name)
+				if tt.failed[name] == "" {
+					assert.Equal(t, StatusOk, result.Status, name)
+				} else {
+					assert.Equal(t, StatusUnavailable, result.Status, name)
+				}
+			}
+			if len(tt.failed) == 0 {
+				assert.Equal(t, StatusOk, report.Status)
+			} else {
+				assert.Equal(t, StatusUnavailable, report.Status)
+			}
+		})
+	}
+}
+
+// TestRunChecksTimeout tests a slow check is cancelled once the health check timeout passes
+func TestRunChecksTimeout(t *testing.T) {
+	report := runChecks(context.Background(), 10*time.Millisecond, []healthCheck{
+		{"slow", func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+	})
+
+	assert.Equal(t, StatusUnavailable, report.Status)
+	assert.Equal(t, context.DeadlineExceeded.Error(), report.Checks["slow"].Error)
+}
+
+// TestMetrics tests requests are counted by route template and the service metrics are exposed
+func TestMetrics(t *testing.T) {
+	svc, _ := service.NewService(types.Config{}, &database.DatabaseStub{GetMessageResponse: types.Message{Id: 1, Message: "test"}}, logging.Discard())
+	srv, _ := NewServer(types.Config{}, svc, logging.Discard())
+
+	for _, path := range []string{"/v1/messages/1", "/v1/messages/2", "/unknown"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		srv.(*server).api.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	req, _ := http.NewRequest("POST", "/v1/messages", strings.NewReader(`{"message":""}`))
+	srv.(*server).api.ServeHTTP(httptest.NewRecorder(), req)
+
+	w := httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	srv.(*server).api.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `messageapi_http_requests_total{method="GET",route="/v1/messages/:id",status="200"} 2`)
+	assert.Contains(t, w.Body.String(), `messageapi_http_requests_total{method="GET",route="unmatched",status="404"} 1`)
+	assert.Contains(t, w.Body.String(), `messageapi_http_request_duration_seconds_count{method="GET",route="/v1/messages/:id",status="200"} 2`)
+	assert.Contains(t, w.Body.String(), `messageapi_http_requests_total{method="POST",route="/v1/messages",status="400"} 1`)
+	assert.Contains(t, w.Body.String(), `messageapi_validation_failures_total{field="message"} 1`)
+}
+
+// TestTracingMiddleware tests a server span continues the trace from the traceparent header and parents the service spans
+func TestTracingMiddleware(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	svc, _ := service.NewService(types.Config{}, &database.DatabaseStub{GetMessageError: fmt.Errorf("%w: connection refused", types.ErrUnavailable)}, logging.Discard())
+	srv, _ := NewServer(types.Config{}, svc, logging.Discard())
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("GET", "/v1/messages/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	srv.(*server).api.ServeHTTP(w, req)
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 2)
+
+	serviceSpan, serverSpan := spans[0], spans[1]
+	assert.Equal(t, "GET /v1/messages/:id", serverSpan.Name())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", serverSpan.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", serverSpan.Parent().SpanID().String())
+	assert.Contains(t, serverSpan.Attributes(), attribute.Int("http.response.status_code", http.StatusServiceUnavailable))
+	assert.Equal(t, codes.Error, serverSpan.Status().Code)
+
+	assert.Equal(t, "service.GetMessage", serviceSpan.Name())
+	assert.Equal(t, serverSpan.SpanContext().SpanID(), serviceSpan.Parent().SpanID())
+}
+
+// TestRequestIdMiddleware tests request ids are propagated when valid and generated otherwise
+func TestRequestIdMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		expected string
+	}{
+		{"propagated", "f3a9-11:req_2.b", "f3a9-11:req_2.b"},
+		{"missing", "", ""},
+		{"invalid characters", "id with spaces\n", ""},
+		{"too long", strings.Repeat("a", maxRequestIdLength+1), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(RequestIdMiddleware())
+			router.GET("/", func(c *gin.Context) {
+				c.String(http.StatusOK, logging.RequestId(c.Request.Context()))
+			})
+			w := httptest.NewRecorder()
+
+			req, _ := http.NewRequest("GET", "/", nil)
+			req.Header.Set("X-Request-ID", tt.header)
+
+			router.ServeHTTP(w, req)
+
+			id := w.Header().Get("X-Request-ID")
+			assert.Equal(t, id, w.Body.String())
+			if tt.expected != "" {
+				assert.Equal(t, tt.expected, id)
+			} else {
+				assert.Len(t, id, 32)
+				assert.NotEqual(t, tt.header, id)
+			}
+		})
+	}
+}
+
+// TestLoggerMiddleware tests each request is logged as JSON with its route, status, error and request id
+func TestLoggerMiddleware(t *testing.T) {
+	var logs bytes.Buffer
+	logger, _ := logging.NewLogger(types.LogConfig{Format: logging.FormatJSON}, &logs)
+	srv, _ := NewServer(types.Config{}, &service.ServiceStub{GetMessageError: fmt.Errorf("%w: connection refused", types.ErrUnavailable)}, logger)
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("GET", "/v1/messages/1", nil)
+	req.Header.Set("X-Request-ID", "test-request")
+
+	srv.(*server).api.ServeHTTP(w, req)
+
+	var record map[string]any
+	json.Unmarshal(logs.Bytes(), &record)
+
+	assert.Equal(t, "ERROR", record["level"])
+	assert.Equal(t, "handled request", record["msg"])
+	assert.Equal(t, "GET", record["method"])
+	assert.Equal(t, "/v1/messages/:id", record["route"])
+	assert.Equal(t, "/v1/messages/1", record["path"])
+	assert.Equal(t, float64(http.StatusServiceUnavailable), record["status"])
+	assert.Equal(t, "unavailable: connection refused", record["error"])
+	assert.Equal(t, "test-request", record["request_id"])
+
+	assert.NotContains(t, w.Body.String(), "connection refused")
+	assert.Contains(t, w.Body.String(), unavailableDetail)
+}
+
+// TestRecoveryLogsPanic tests a panic is logged with the request id and returned as a problem
+func TestRecoveryLogsPanic(t *testing.T) {
+	var logs bytes.Buffer
+	logger, _ := logging.NewLogger(types.LogConfig{Format: logging.FormatJSON}, &logs)
+	srv, _ := NewServer(types.Config{}, &service.ServiceStub{}, logger)
+	srv.(*server).api.GET("/panic", func(c *gin.Context) {
+		panic("something broke")
+	})
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("GET", "/panic", nil)
+	req.Header.Set("X-Request-ID", "test-request")
+
+	srv.(*server).api.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), `"request_id":"test-request"`)
+
+	var record map[string]any
+	json.Unmarshal(bytes.SplitN(logs.Bytes(), []byte("\n"), 2)[0], &record)
+
+	assert.Equal(t, "recovered from panic", record["msg"])
+	assert.Equal(t, "something broke", record["panic"])
+	assert.Equal(t, "test-request", record["request_id"])
 }
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"messageApi/internal/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TenantMiddleware adds the tenant of the request to the request context so every Message it touches belongs to that tenant
+// Authenticated callers belong to the tenant in their Identity, or the default tenant when their credentials do not name
+// one, and the header must match it when it is sent. Only anonymous callers choose the tenant with the header
+func TenantMiddleware(cfg types.TenantConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var header string
+		if cfg.Header != "" {
+			header = c.GetHeader(cfg.Header)
+		}
+
+		tenant := header
+		if identity, ok := types.IdentityFromContext(c.Request.Context()); ok && identity.Subject != types.AnonymousSubject {
+			tenant = identity.Tenant
+			if tenant == "" {
+				tenant = types.DefaultTenant
+			}
+
+			if header != "" && header != tenant {
+				abortWithProblem(c, http.StatusForbidden, fmt.Sprintf("The %s header does not match the tenant of the caller", cfg.Header))
+				return
+			}
+		}
+
+		if tenant == "" {
+			tenant = types.DefaultTenant
+		}
+
+		if !isValidTenant(tenant) {
+			abortWithProblem(c, http.StatusBadRequest, "Invalid tenant", FieldError{Field: cfg.Header, Message: fmt.Sprintf("must be 1 to %d letters, digits, - _ or .", types.MaxTenantLength)})
+			return
+		}
+
+		c.Request = c.Request.WithContext(types.WithTenant(c.Request.Context(), tenant))
+		c.Next()
+	}
+}
+
+// isValidTenant checks the tenant is not too long and only holds letters, digits and - _ .
+func isValidTenant(tenant string) bool {
+	if tenant == "" || len(tenant) > types.MaxTenantLength {
+		return false
+	}
+
+	for _, r := range tenant {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"messageApi/internal/database"
+	"messageApi/internal/logging"
+	"messageApi/internal/service"
+	"messageApi/internal/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTenantMiddleware tests the tenant is taken from the caller's Identity, or the default tenant when it has none,
+// and only anonymous callers choose it with the header
+func TestTenantMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		subject  string
+		identity string
+		header   string
+		status   int
+		expected string
+	}{
+		{"default", types.AnonymousSubject, "", "", http.StatusOK, types.DefaultTenant},
+		{"header", types.AnonymousSubject, "", "team-a", http.StatusOK, "team-a"},
+		{"identity", "user-1", "team-b", "", http.StatusOK, "team-b"},
+		{"matching header", "user-1", "team-b", "team-b", http.StatusOK, "team-b"},
+		{"mismatched header", "user-1", "team-b", "team-a", http.StatusForbidden, ""},
+		{"identity without tenant", "user-1", "", "", http.StatusOK, types.DefaultTenant},
+		{"identity without tenant matching header", "user-1", "", types.DefaultTenant, http.StatusOK, types.DefaultTenant},
+		{"identity without tenant mismatched header", "user-1", "", "team-a", http.StatusForbidden, ""},
+		{"invalid characters", types.AnonymousSubject, "", "team a", http.StatusBadRequest, ""},
+		{"too long", types.AnonymousSubject, "", strings.Repeat("a", types.MaxTenantLength+1), http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tenant string
+			router := gin.New()
+			router.Use(func(c *gin.Context) {
+				identity := types.Identity{Subject: tt.subject, Tenant: tt.identity}
+				c.Request = c.Request.WithContext(types.WithIdentity(c.Request.Context(), identity))
+			})
+			router.Use(TenantMiddleware(types.TenantConfig{Header: "X-Tenant-ID"}))
+			router.GET("/", func(c *gin.Context) {
+				tenant = types.TenantFromContext(c.Request.Context())
+			})
+			w := httptest.NewRecorder()
+
+			req, _ := http.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				req.Header.Set("X-Tenant-ID", tt.header)
+			}
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, tt.expected, tenant)
+		})
+	}
+}
+
+// TestTenantIsolation tests a Message created for one tenant cannot be read by another
+func TestTenantIsolation(t *testing.T) {
+	svc, _ := service.NewService(types.Config{}, database.NewMemoryDatabase(), logging.Discard())
+	srv, _ := NewServer(types.Config{Tenant: types.TenantConfig{Header: "X-Tenant-ID"}}, svc, logging.Discard())
+	api := srv.(*server).api
+
+	send := func(method string, path string, tenant string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-Tenant-ID", tenant)
+		api.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusCreated, send("POST", "/v1/messages", "team-a", `{"message": "racecar"}`).Code)
+
+	assert.Equal(t, http.StatusOK, send("GET", "/v1/messages/1", "team-a", "").Code)
+	assert.Equal(t, http.StatusNotFound, send("GET", "/v1/messages/1", "team-b", "").Code)
+	assert.Equal(t, http.StatusNotFound, send("DELETE", "/v1/messages/1", "team-b", "").Code)
+	assert.Equal(t, "[]", send("GET", "/v1/messages", "team-b", "").Body.String())
+}
+
+// TestAPIKeyTenantIsolation tests an API key is kept to its tenant and cannot pick another with the header
+func TestAPIKeyTenantIsolation(t *testing.T) {
+	cfg := types.Config{Auth: types.AuthConfig{Mode: types.AuthAPIKey, BootstrapKey: "bootstrap-secret"}, Tenant: types.TenantConfig{Header: "X-Tenant-ID"}}
+	svc, _ := service.NewService(cfg, database.NewMemoryDatabase(), logging.Discard())
+	srv, _ := NewServer(cfg, svc, logging.Discard())
+	api := srv.(*server).api
+
+	send := func(method string, path string, key string, tenant string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-API-Key", key)
+		if tenant != "" {
+			req.Header.Set("X-Tenant-ID", tenant)
+		}
+		api.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("POST", "/v1/admin/keys", "bootstrap-secret", "", `{"name": "team a", "scopes": ["admin"], "tenant": "team-a"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var key types.APIKey
+	json.Unmarshal(w.Body.Bytes(), &key)
+	assert.Equal(t, "team-a", key.Tenant)
+
+	assert.Equal(t, http.StatusForbidden, send("GET", "/v1/messages", "bootstrap-secret", "team-a", "").Code)
+	assert.Equal(t, http.StatusForbidden, send("GET", "/v1/messages", key.Key, "team-b", "").Code)
+	assert.Equal(t, http.StatusCreated, send("POST", "/v1/messages", key.Key, "", `{"message": "racecar"}`).Code)
+	assert.Equal(t, http.StatusNotFound, send("GET", "/v1/messages/1", "bootstrap-secret", "", "").Code)
+
+	assert.Equal(t, "[]", send("GET", "/v1/admin/keys", "bootstrap-secret", "", "").Body.String())
+	assert.Equal(t, http.StatusNotFound, send("DELETE", fmt.Sprintf("/v1/admin/keys/%d", key.Id), "bootstrap-secret", "", "").Code)
+	assert.Equal(t, http.StatusForbidden, send("POST", "/v1/admin/keys", key.Key, "", `{"name": "default", "scopes": ["read"], "tenant": "default"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, send("POST", "/v1/admin/keys", "bootstrap-secret", "", `{"name": "bad", "scopes": ["read"], "tenant": "team a"}`).Code)
+	assert.Equal(t, http.StatusOK, send("DELETE", fmt.Sprintf("/v1/admin/keys/%d", key.Id), key.Key, "", "").Code)
+}
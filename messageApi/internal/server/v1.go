@@ -1,24 +1,34 @@
 package server
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"messageApi/internal/service"
 	"messageApi/internal/types"
 	"net/http"
+	"net/url"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
 
 // addV1Routes adds the service middleware and routes to the RouterGroup
-func addV1Routes(group *gin.RouterGroup, service service.Service) {
-	group.Use(ServiceMiddleware(service))
-
-	group.POST("/messages", CreateMessageHandler)
-	group.GET("/messages", ListMessageHandler)
-	group.GET("/messages/:id", GetMessageHandler)
-	group.POST("/messages/:id", UpdateMessageHandler)
-	group.DELETE("/messages/:id", DeleteMessageHandler)
+// Each route requires a scope, so the group must authenticate callers before the routes run
+func addV1Routes(group *gin.RouterGroup, service service.Service, cfg types.ServerConfig) {
+	group.Use(ServiceMiddleware(service), BodyLimitMiddleware(cfg.MaxBodyBytes))
+
+	group.POST("/messages", RequireScope(types.ScopeWrite), IdempotencyMiddleware(), CreateMessageHandler)
+	group.GET("/messages", RequireScope(types.ScopeRead), ListMessageHandler)
+	group.GET("/messages/:id", RequireScope(types.ScopeRead), GetMessageHandler)
+	group.PUT("/messages/:id", RequireScope(types.ScopeWrite), RequireIfMatch(cfg.RequireIfMatch), IdempotencyMiddleware(), UpdateMessageHandler)
+	group.PATCH("/messages/:id", RequireScope(types.ScopeWrite), RequireIfMatch(cfg.RequireIfMatch), IdempotencyMiddleware(), PatchMessageHandler)
+	// POST is kept for clients written before PUT and PATCH were supported
+	group.POST("/messages/:id", RequireScope(types.ScopeWrite), RequireIfMatch(cfg.RequireIfMatch), IdempotencyMiddleware(), UpdateMessageHandler)
+	group.DELETE("/messages/:id", RequireScope(types.ScopeWrite), RequireIfMatch(cfg.RequireIfMatch), DeleteMessageHandler)
+	group.POST("/messages:action", requireAction("batch"), RequireScope(types.ScopeWrite), IdempotencyMiddleware(), BatchMessagesHandler)
+
+	addAdminRoutes(group)
 }
 
 // getService gets the service module from the request context
@@ -32,127 +42,255 @@ func getService(c *gin.Context) (service.Service, bool) {
 }
 
 // CreateMessageHandler handles requests to create messages
+// The ETag of the new Message is returned so it can be sent in If-Match when the Message is changed
 func CreateMessageHandler(c *gin.Context) {
 	service, ok := getService(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, errorAsJSON("Service unavailable"))
+		abortWithProblem(c, http.StatusInternalServerError, "Service unavailable")
 		return
 	}
 
 	var msg types.Message
 
 	if err := c.ShouldBindJSON(&msg); err != nil {
-		c.JSON(http.StatusBadRequest, errorAsJSON("Invalid body"))
+		abortWithBindError(c, err)
 		return
 	}
 
-	msg, err := service.CreateMessage(msg)
+	msg, err := service.CreateMessage(c.Request.Context(), msg)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, errorAsJSON(fmt.Sprintf("Error saving message: %v", err)))
+		abortWithError(c, err, "Error saving message")
 		return
 	}
 
+	c.Header("ETag", messageETag(msg.Version))
 	c.JSON(http.StatusCreated, msg)
 }
 
 // ListMessageHandler handles requests to list Messages
+// A Link header pointing at the next page is added when more Messages are available
+// The ETag is a hash of the page, so If-None-Match returns 304 until a Message on the page changes
 func ListMessageHandler(c *gin.Context) {
 	service, ok := getService(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, errorAsJSON("Service unavailable"))
+		abortWithProblem(c, http.StatusInternalServerError, "Service unavailable")
+		return
+	}
+
+	opts, err := parseListOptions(c)
+	if err != nil {
+		abortWithError(c, err, "Invalid query")
 		return
 	}
 
-	msgs, err := service.ListMessages()
+	page, err := service.ListMessages(c.Request.Context(), opts)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, errorAsJSON(fmt.Sprintf("Error retrieving messages: %v", err)))
+		abortWithError(c, err, "Error retrieving messages")
 		return
 	}
 
-	c.JSON(http.StatusOK, msgs)
+	if page.Next != nil {
+		c.Header("Link", fmt.Sprintf(`<%s>; rel="next"`, nextPageURL(c, page.Next)))
+	}
+
+	msgs := page.Messages
+	if msgs == nil {
+		msgs = []types.Message{}
+	}
+
+	body, err := json.Marshal(msgs)
+	if err != nil {
+		abortWithError(c, err, "Error encoding messages")
+		return
+	}
+
+	etag := bodyETag(body)
+	if notModified(c, etag) {
+		return
+	}
+
+	c.Header("ETag", etag)
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
+
+// ownerMe is the value of the owner query parameter that lists the caller's own Messages
+const ownerMe = "me"
+
+// parseListOptions reads the paging, filtering and sorting query parameters from the request
+// The owner filter takes the subject of a caller, or me for the caller making the request
+func parseListOptions(c *gin.Context) (types.ListOptions, error) {
+	opts := types.ListOptions{
+		Sort:  c.Query("sort"),
+		Order: c.Query("order"),
+	}
+
+	if limit, ok := c.GetQuery("limit"); ok {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value < 1 {
+			return opts, &types.ValidationError{Field: "limit", Message: "limit must be a positive integer"}
+		}
+		opts.Limit = value
+	}
+
+	if cursor, ok := c.GetQuery("cursor"); ok {
+		after, err := decodeCursor(cursor)
+		if err != nil {
+			return opts, &types.ValidationError{Field: "cursor", Message: "cursor is not valid"}
+		}
+		opts.After = &after
+	}
+
+	if isPalindrome, ok := c.GetQuery("ispalindrome"); ok {
+		value, err := strconv.ParseBool(isPalindrome)
+		if err != nil {
+			return opts, &types.ValidationError{Field: "ispalindrome", Message: "ispalindrome must be a boolean"}
+		}
+		opts.IsPalindrome = &value
+	}
+
+	if owner, ok := c.GetQuery("owner"); ok {
+		if owner == "" {
+			return opts, &types.ValidationError{Field: "owner", Message: "owner cannot be an empty string"}
+		}
+
+		if owner == ownerMe {
+			identity, ok := types.IdentityFromContext(c.Request.Context())
+			if !ok {
+				return opts, fmt.Errorf("%w: owner=me requires an authenticated caller", types.ErrUnauthorized)
+			}
+			owner = identity.Subject
+		}
+		opts.Owner = owner
+	}
+
+	return opts, nil
+}
+
+// nextPageURL copies the request URL and replaces the cursor with the one for the next page
+func nextPageURL(c *gin.Context, next *types.Cursor) string {
+	query := c.Request.URL.Query()
+	query.Set("cursor", encodeCursor(*next))
+
+	nextUrl := url.URL{Path: c.Request.URL.Path, RawQuery: query.Encode()}
+
+	return nextUrl.String()
+}
+
+// encodeCursor converts a Cursor into an opaque string for use in a query parameter
+func encodeCursor(cursor types.Cursor) string {
+	data, _ := json.Marshal(cursor)
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor converts an opaque cursor string back into a Cursor
+func decodeCursor(value string) (types.Cursor, error) {
+	var cursor types.Cursor
+
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, err
+	}
+
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return cursor, err
+	}
+
+	return cursor, nil
 }
 
 // GetMessageHandler handles requests to get a single Message
+// A 304 is returned when the If-None-Match header holds the current ETag of the Message
 func GetMessageHandler(c *gin.Context) {
 	service, ok := getService(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, errorAsJSON("Service unavailable"))
+		abortWithProblem(c, http.StatusInternalServerError, "Service unavailable")
 		return
 	}
 
 	id, err := strconv.Atoi(c.Params.ByName("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, "Invalid Id")
+		abortWithProblem(c, http.StatusBadRequest, "Invalid Id", FieldError{Field: "id", Message: "must be an integer"})
 		return
 	}
 
-	msg, err := service.GetMessage(id)
+	msg, err := service.GetMessage(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, errorAsJSON(fmt.Sprintf("Error retrieving message: %v", err)))
+		abortWithError(c, err, "Error retrieving message")
 		return
 	}
 
-	if msg.Id == 0 {
-		c.JSON(http.StatusNotFound, errorAsJSON(fmt.Sprintf("Message not found for id %d", id)))
+	etag := messageETag(msg.Version)
+	if notModified(c, etag) {
 		return
 	}
 
+	c.Header("ETag", etag)
 	c.JSON(http.StatusOK, msg)
 }
 
-// UpdateMessageHandler handles requests to update an existing Message
+// UpdateMessageHandler handles requests to replace an existing Message, which fields missing from the body are reset for
+// When an If-Match header is sent the Message is only updated while its ETag still matches, otherwise a 412 is returned
 func UpdateMessageHandler(c *gin.Context) {
 	service, ok := getService(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, errorAsJSON("Service unavailable"))
+		abortWithProblem(c, http.StatusInternalServerError, "Service unavailable")
 		return
 	}
 
 	id, err := strconv.Atoi(c.Params.ByName("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, errorAsJSON("Invalid Id"))
+		abortWithProblem(c, http.StatusBadRequest, "Invalid Id", FieldError{Field: "id", Message: "must be an integer"})
+		return
+	}
+
+	version, ok := ifMatchVersion(c)
+	if !ok {
 		return
 	}
 
 	var msg types.Message
 
 	if err := c.ShouldBindJSON(&msg); err != nil {
-		c.JSON(http.StatusBadRequest, errorAsJSON("Invalid body"))
+		abortWithBindError(c, err)
 		return
 	}
 
 	msg.Id = id
+	msg.Version = version
 
-	msg, err = service.UpdateMessage(msg)
+	msg, err = service.UpdateMessage(c.Request.Context(), msg)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, errorAsJSON(fmt.Sprintf("Error updating message: %v", err)))
-		return
-	}
-
-	if msg.Id == 0 {
-		c.JSON(http.StatusNotFound, errorAsJSON(fmt.Sprintf("Message not found for id %d", id)))
+		abortWithError(c, err, "Error updating message")
 		return
 	}
 
+	c.Header("ETag", messageETag(msg.Version))
 	c.JSON(http.StatusOK, msg)
 }
 
 // DeleteMessageHandler handles requests to delete an existing Message
+// When an If-Match header is sent the Message is only deleted while its ETag still matches, otherwise a 412 is returned
 func DeleteMessageHandler(c *gin.Context) {
 	service, ok := getService(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, errorAsJSON("Service unavailable"))
+		abortWithProblem(c, http.StatusInternalServerError, "Service unavailable")
 		return
 	}
 
 	id, err := strconv.Atoi(c.Params.ByName("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, "Invalid Id")
+		abortWithProblem(c, http.StatusBadRequest, "Invalid Id", FieldError{Field: "id", Message: "must be an integer"})
+		return
+	}
+
+	version, ok := ifMatchVersion(c)
+	if !ok {
 		return
 	}
 
-	if err := service.DeleteMessage(id); err != nil {
-		c.JSON(http.StatusInternalServerError, errorAsJSON(fmt.Sprintf("Failed to delete message with id %d", id)))
+	if err := service.DeleteMessage(c.Request.Context(), id, version); err != nil {
+		abortWithError(c, err, fmt.Sprintf("Failed to delete message with id %d", id))
 		return
 	}
 
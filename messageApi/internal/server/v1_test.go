@@ -6,10 +6,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"messageApi/internal/database"
+	"messageApi/internal/logging"
 	"messageApi/internal/service"
 	"messageApi/internal/types"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/gin-gonic/gin"
@@ -66,6 +69,14 @@ func setupDeleteRouterWithId(service service.Service, handler gin.HandlerFunc) *
 	return router
 }
 
+// problemJSON creates the expected problem document for an error response
+func problemJSON(status int, detail string, instance string, fieldErrors ...FieldError) string {
+	problem := Problem{Type: "about:blank", Title: http.StatusText(status), Status: status, Detail: detail, Instance: instance, Errors: fieldErrors}
+	data, _ := json.Marshal(problem)
+
+	return string(data)
+}
+
 // TestCreateMessage tests successfully creating a message
 func TestCreateMessage(t *testing.T) {
 	responseMessage := types.Message{Id: 1, Message: "racecar", IsPalindrome: true}
@@ -88,7 +99,7 @@ func TestCreateMessage(t *testing.T) {
 
 // TestCreateMessageServiceUnavailable tests that an error is returned when the service is not initialized
 func TestCreateMessageServiceUnavailable(t *testing.T) {
-	mockResponse := `{"error":"Service unavailable"}`
+	mockResponse := problemJSON(http.StatusInternalServerError, "Service unavailable", "/")
 	router := gin.Default()
 	w := httptest.NewRecorder()
 
@@ -108,7 +119,7 @@ func TestCreateMessageServiceUnavailable(t *testing.T) {
 
 // TestCreateMessageInvalidBody tests an error is returned when Message is not a string
 func TestCreateMessageInvalidBody(t *testing.T) {
-	mockResponse := `{"error":"Invalid body"}`
+	mockResponse := problemJSON(http.StatusBadRequest, "Invalid body", "/", FieldError{Field: "message", Message: "must be of type string"})
 	service_stub := service.ServiceStub{}
 	w := httptest.NewRecorder()
 	router := setupPostRouter(&service_stub, CreateMessageHandler)
@@ -125,10 +136,24 @@ func TestCreateMessageInvalidBody(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, w.Code)
 }
 
+// TestCreateMessageBodyTooLarge tests a 413 is returned for a body over MAX_BODY_BYTES without an Idempotency-Key
+func TestCreateMessageBodyTooLarge(t *testing.T) {
+	service_stub := service.ServiceStub{}
+	srv, _ := NewServer(types.Config{Server: types.ServerConfig{MaxBodyBytes: 16}}, &service_stub, logging.Discard())
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("POST", "/v1/messages", strings.NewReader(`{"message": "racecar"}`))
+
+	srv.(*server).api.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+}
+
 // TestCreateMessageError tests an error is returned when the call to the service fails
 func TestCreateMessageError(t *testing.T) {
 	errorMsg := "create message failed"
-	mockResponse := fmt.Sprintf(`{"error":"Error saving message: %s"}`, errorMsg)
+	mockResponse := problemJSON(http.StatusInternalServerError, "Error saving message: an unexpected error occurred", "/")
 	service_stub := service.ServiceStub{CreateMessageError: errors.New(errorMsg)}
 	w := httptest.NewRecorder()
 	router := setupPostRouter(&service_stub, CreateMessageHandler)
@@ -149,7 +174,7 @@ func TestCreateMessageError(t *testing.T) {
 func TestListMessage(t *testing.T) {
 	responseMessages := []types.Message{{Id: 1, Message: "racecar", IsPalindrome: true}, {Id: 2, Message: "second", IsPalindrome: false}}
 	mockResponse, _ := json.Marshal(responseMessages)
-	service_stub := service.ServiceStub{ListMessagesResponse: responseMessages}
+	service_stub := service.ServiceStub{ListMessagesResponse: types.MessagePage{Messages: responseMessages}}
 	w := httptest.NewRecorder()
 	router := setupGetRouter(&service_stub, ListMessageHandler)
 
@@ -160,12 +185,117 @@ func TestListMessage(t *testing.T) {
 	responseData, _ := io.ReadAll(w.Body)
 
 	assert.Equal(t, string(mockResponse), string(responseData))
+	assert.Equal(t, "", w.Header().Get("Link"))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+// TestListMessageNextPage tests a Link header is returned when there is another page of Messages
+func TestListMessageNextPage(t *testing.T) {
+	responseMessages := []types.Message{{Id: 1, Message: "racecar", IsPalindrome: true}}
+	next := types.Cursor{Id: 1}
+	service_stub := service.ServiceStub{ListMessagesResponse: types.MessagePage{Messages: responseMessages, Next: &next}}
+	w := httptest.NewRecorder()
+	router := setupGetRouter(&service_stub, ListMessageHandler)
+
+	req, _ := http.NewRequest("GET", "/?limit=1&ispalindrome=true", nil)
+
+	router.ServeHTTP(w, req)
+
+	mockLink := fmt.Sprintf(`</?cursor=%s&ispalindrome=true&limit=1>; rel="next"`, encodeCursor(next))
+
+	assert.Equal(t, mockLink, w.Header().Get("Link"))
 	assert.Equal(t, http.StatusOK, w.Code)
 }
 
+// TestListMessageEmpty tests an empty JSON array is returned when there are no Messages
+func TestListMessageEmpty(t *testing.T) {
+	service_stub := service.ServiceStub{}
+	w := httptest.NewRecorder()
+	router := setupGetRouter(&service_stub, ListMessageHandler)
+
+	req, _ := http.NewRequest("GET", "/", nil)
+
+	router.ServeHTTP(w, req)
+
+	responseData, _ := io.ReadAll(w.Body)
+
+	assert.Equal(t, "[]", string(responseData))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+// TestListMessageInvalidQuery tests an error is returned when the query parameters are invalid
+func TestListMessageInvalidQuery(t *testing.T) {
+	queries := map[string]string{
+		"/?limit=abc":          problemJSON(http.StatusBadRequest, "Invalid query: limit must be a positive integer", "/", FieldError{Field: "limit", Message: "limit must be a positive integer"}),
+		"/?limit=0":            problemJSON(http.StatusBadRequest, "Invalid query: limit must be a positive integer", "/", FieldError{Field: "limit", Message: "limit must be a positive integer"}),
+		"/?cursor=!!!":         problemJSON(http.StatusBadRequest, "Invalid query: cursor is not valid", "/", FieldError{Field: "cursor", Message: "cursor is not valid"}),
+		"/?ispalindrome=maybe": problemJSON(http.StatusBadRequest, "Invalid query: ispalindrome must be a boolean", "/", FieldError{Field: "ispalindrome", Message: "ispalindrome must be a boolean"}),
+		"/?owner=":             problemJSON(http.StatusBadRequest, "Invalid query: owner cannot be an empty string", "/", FieldError{Field: "owner", Message: "owner cannot be an empty string"}),
+	}
+
+	for query, mockResponse := range queries {
+		service_stub := service.ServiceStub{}
+		w := httptest.NewRecorder()
+		router := setupGetRouter(&service_stub, ListMessageHandler)
+
+		req, _ := http.NewRequest("GET", query, nil)
+
+		router.ServeHTTP(w, req)
+
+		responseData, _ := io.ReadAll(w.Body)
+
+		assert.Equal(t, mockResponse, string(responseData), query)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
+// TestListMessageOwner tests the owner filter is passed to the service, using the caller's subject for me
+func TestListMessageOwner(t *testing.T) {
+	queries := map[string]string{
+		"/v1/messages":                  "",
+		"/v1/messages?owner=me":         types.AnonymousSubject,
+		"/v1/messages?owner=apikey%3A7": "apikey:7",
+	}
+
+	for query, owner := range queries {
+		service_stub := service.ServiceStub{}
+		srv, _ := NewServer(types.Config{}, &service_stub, logging.Discard())
+		w := httptest.NewRecorder()
+
+		req, _ := http.NewRequest("GET", query, nil)
+
+		srv.(*server).api.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code, query)
+		assert.Equal(t, owner, service_stub.ListMessagesInput.Owner, query)
+	}
+}
+
+// TestListMessageOwnerMeUnauthenticated tests owner=me is rejected when there is no caller identity
+func TestListMessageOwnerMeUnauthenticated(t *testing.T) {
+	w := httptest.NewRecorder()
+	router := setupGetRouter(&service.ServiceStub{}, ListMessageHandler)
+
+	req, _ := http.NewRequest("GET", "/?owner=me", nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+// TestCursorRoundTrip tests a Cursor can be encoded and decoded without losing values
+func TestCursorRoundTrip(t *testing.T) {
+	cursor := types.Cursor{Id: 42, Value: "racecar", Sort: types.SortByMessage, Order: types.OrderDesc}
+
+	decoded, err := decodeCursor(encodeCursor(cursor))
+
+	assert.Equal(t, cursor, decoded)
+	assert.Equal(t, nil, err)
+}
+
 // TestListMessageServiceUnavailable tests that an error is returned when the service is not initialized
 func TestListMessageServiceUnavailable(t *testing.T) {
-	mockResponse := `{"error":"Service unavailable"}`
+	mockResponse := problemJSON(http.StatusInternalServerError, "Service unavailable", "/")
 	router := gin.Default()
 	w := httptest.NewRecorder()
 
@@ -184,7 +314,7 @@ func TestListMessageServiceUnavailable(t *testing.T) {
 // TestListMessageError tests an error is returned when the call to the service module fails
 func TestListMessageError(t *testing.T) {
 	errorMsg := "list message failed"
-	mockResponse := fmt.Sprintf(`{"error":"Error retrieving messages: %s"}`, errorMsg)
+	mockResponse := problemJSON(http.StatusInternalServerError, "Error retrieving messages: an unexpected error occurred", "/")
 	service_stub := service.ServiceStub{ListMessagesError: errors.New(errorMsg)}
 	w := httptest.NewRecorder()
 	router := setupGetRouter(&service_stub, ListMessageHandler)
@@ -219,7 +349,7 @@ func TestGetMessage(t *testing.T) {
 
 // TestGetMessageServiceUnavailable tests that an error is returned when the service is not initialized
 func TestGetMessageServiceUnavailable(t *testing.T) {
-	mockResponse := `{"error":"Service unavailable"}`
+	mockResponse := problemJSON(http.StatusInternalServerError, "Service unavailable", "/1")
 	router := gin.Default()
 	w := httptest.NewRecorder()
 
@@ -238,7 +368,7 @@ func TestGetMessageServiceUnavailable(t *testing.T) {
 // TestGetMessageError tests an error is returned when the call to the service module fails
 func TestGetMessageError(t *testing.T) {
 	errorMsg := "get message failed"
-	mockResponse := fmt.Sprintf(`{"error":"Error retrieving message: %s"}`, errorMsg)
+	mockResponse := problemJSON(http.StatusInternalServerError, "Error retrieving message: an unexpected error occurred", "/1")
 	service_stub := service.ServiceStub{GetMessageError: errors.New(errorMsg)}
 	w := httptest.NewRecorder()
 	router := setupGetRouterWithId(&service_stub, GetMessageHandler)
@@ -275,7 +405,7 @@ func TestUpdateMessage(t *testing.T) {
 
 // TestUpdateMessageServiceUnavailable tests that an error is returned when the service is not initialized
 func TestUpdateMessageServiceUnavailable(t *testing.T) {
-	mockResponse := `{"error":"Service unavailable"}`
+	mockResponse := problemJSON(http.StatusInternalServerError, "Service unavailable", "/1")
 	router := gin.Default()
 	w := httptest.NewRecorder()
 
@@ -295,7 +425,7 @@ func TestUpdateMessageServiceUnavailable(t *testing.T) {
 
 // TestUpdateMessageInvalidBody tests an error is returned when Message is not a string
 func TestUpdateMessageInvalidBody(t *testing.T) {
-	mockResponse := `{"error":"Invalid body"}`
+	mockResponse := problemJSON(http.StatusBadRequest, "Invalid body", "/1", FieldError{Field: "message", Message: "must be of type string"})
 	service_stub := service.ServiceStub{}
 	w := httptest.NewRecorder()
 	router := setupPostRouterWithId(&service_stub, UpdateMessageHandler)
@@ -315,7 +445,7 @@ func TestUpdateMessageInvalidBody(t *testing.T) {
 // TestUpdateMessageError tests an error is returned when the call to the service fails
 func TestUpdateMessageError(t *testing.T) {
 	errorMsg := "update message failed"
-	mockResponse := fmt.Sprintf(`{"error":"Error updating message: %s"}`, errorMsg)
+	mockResponse := problemJSON(http.StatusInternalServerError, "Error updating message: an unexpected error occurred", "/1")
 	service_stub := service.ServiceStub{UpdateMessageError: errors.New(errorMsg)}
 	w := httptest.NewRecorder()
 	router := setupPostRouterWithId(&service_stub, UpdateMessageHandler)
@@ -348,7 +478,7 @@ func TestDeleteMessage(t *testing.T) {
 
 // TestDeleteMessageServiceUnavailable tests that an error is returned when the service is not initialized
 func TestDeleteMessageServiceUnavailable(t *testing.T) {
-	mockResponse := `{"error":"Service unavailable"}`
+	mockResponse := problemJSON(http.StatusInternalServerError, "Service unavailable", "/1")
 	router := gin.Default()
 	w := httptest.NewRecorder()
 
@@ -366,8 +496,8 @@ func TestDeleteMessageServiceUnavailable(t *testing.T) {
 
 // TestDeleteMessageError tests an error is returned when the call to the service fails
 func TestDeleteMessageError(t *testing.T) {
-	mockResponse := `{"error":"Failed to delete message with id 1"}`
-	service_stub := service.ServiceStub{DeleteMessageError: errors.New("")}
+	mockResponse := problemJSON(http.StatusInternalServerError, "Failed to delete message with id 1: an unexpected error occurred", "/1")
+	service_stub := service.ServiceStub{DeleteMessageError: errors.New("delete message failed")}
 	w := httptest.NewRecorder()
 	router := setupDeleteRouterWithId(&service_stub, DeleteMessageHandler)
 
@@ -380,3 +510,94 @@ func TestDeleteMessageError(t *testing.T) {
 	assert.Equal(t, mockResponse, string(responseData))
 	assert.Equal(t, http.StatusInternalServerError, w.Code)
 }
+
+// TestGetMessageNotFound tests a 404 is returned when the service module cannot find the Message
+func TestGetMessageNotFound(t *testing.T) {
+	mockResponse := problemJSON(http.StatusNotFound, "Error retrieving message: message 1 not found", "/1")
+	service_stub := service.ServiceStub{GetMessageError: fmt.Errorf("message 1 %w", service.ErrNotFound)}
+	w := httptest.NewRecorder()
+	router := setupGetRouterWithId(&service_stub, GetMessageHandler)
+
+	req, _ := http.NewRequest("GET", "/1", nil)
+
+	router.ServeHTTP(w, req)
+
+	responseData, _ := io.ReadAll(w.Body)
+
+	assert.Equal(t, mockResponse, string(responseData))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// TestCreateMessageValidationError tests a 400 is returned when the service module rejects the Message
+func TestCreateMessageValidationError(t *testing.T) {
+	mockResponse := problemJSON(http.StatusBadRequest, "Error saving message: message cannot be an empty string", "/", FieldError{Field: "message", Message: "message cannot be an empty string"})
+	service_stub := service.ServiceStub{CreateMessageError: &types.ValidationError{Field: "message", Message: "message cannot be an empty string"}}
+	w := httptest.NewRecorder()
+	router := setupPostRouter(&service_stub, CreateMessageHandler)
+
+	req, _ := http.NewRequest("POST", "/", bytes.NewBuffer([]byte(`{"message": ""}`)))
+
+	router.ServeHTTP(w, req)
+
+	responseData, _ := io.ReadAll(w.Body)
+
+	assert.Equal(t, mockResponse, string(responseData))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// TestDeleteMessageNotFound tests a 404 is returned when the Message to delete does not exist
+func TestDeleteMessageNotFound(t *testing.T) {
+	service_stub := service.ServiceStub{DeleteMessageError: fmt.Errorf("message 1 %w", service.ErrNotFound)}
+	w := httptest.NewRecorder()
+	router := setupDeleteRouterWithId(&service_stub, DeleteMessageHandler)
+
+	req, _ := http.NewRequest("DELETE", "/1", nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// TestGetMessageInvalidId tests a problem is returned when the id is not an integer
+func TestGetMessageInvalidId(t *testing.T) {
+	mockResponse := problemJSON(http.StatusBadRequest, "Invalid Id", "/abc", FieldError{Field: "id", Message: "must be an integer"})
+	service_stub := service.ServiceStub{}
+	w := httptest.NewRecorder()
+	router := setupGetRouterWithId(&service_stub, GetMessageHandler)
+
+	req, _ := http.NewRequest("GET", "/abc", nil)
+
+	router.ServeHTTP(w, req)
+
+	responseData, _ := io.ReadAll(w.Body)
+
+	assert.Equal(t, mockResponse, string(responseData))
+	assert.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// TestListMessageCursorSortChanged tests a 400 is returned when a cursor is used with a different sort than it was created for
+func TestListMessageCursorSortChanged(t *testing.T) {
+	svc, _ := service.NewService(types.Config{}, database.NewMemoryDatabase(), logging.Discard())
+	srv, _ := NewServer(types.Config{}, svc, logging.Discard())
+	api := srv.(*server).api
+
+	send := func(method string, target string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, target, strings.NewReader(body))
+		api.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, text := range []string{"racecar", "kayak", "level"} {
+		assert.Equal(t, http.StatusCreated, send("POST", "/v1/messages", fmt.Sprintf(`{"message": %q}`, text)).Code)
+	}
+
+	w := send("GET", "/v1/messages?limit=1&sort=message", "")
+	link := strings.TrimSuffix(strings.TrimPrefix(w.Header().Get("Link"), "<"), `>; rel="next"`)
+	assert.Equal(t, http.StatusOK, send("GET", link, "").Code)
+
+	w = send("GET", strings.Replace(link, "sort=message", "sort=id", 1), "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "cursor was created for a different sort or order")
+}
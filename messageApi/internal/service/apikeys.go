@@ -0,0 +1,173 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"messageApi/internal/types"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// bootstrapSubject is the Subject of callers using the bootstrap key from the config
+const bootstrapSubject = "apikey:bootstrap"
+
+// apiKeyPrefix starts every issued API key so they are easy to recognise, for example by secret scanners
+const apiKeyPrefix = "mk_"
+
+// maxAPIKeyNameLength is the longest name, in characters, an APIKey can be given
+const maxAPIKeyNameLength = 100
+
+// errInvalidAPIKey is returned for every API key that cannot be used so callers cannot tell why it failed
+var errInvalidAPIKey = fmt.Errorf("%w: invalid api key", types.ErrUnauthorized)
+
+// IssueAPIKey creates a new APIKey with the name and scopes for the tenant, or the caller's tenant when it is empty
+// Only the bootstrap key can issue keys for another tenant, so the first admin key of each tenant can be issued
+// The returned APIKey holds the key itself, which cannot be retrieved again as only its hash is stored
+func (s *service) IssueAPIKey(ctx context.Context, name string, scopes []types.Scope, tenant string) (types.APIKey, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return types.APIKey{}, &types.ValidationError{Field: "name", Message: "name cannot be an empty string"}
+	}
+
+	if utf8.RuneCountInString(name) > maxAPIKeyNameLength {
+		return types.APIKey{}, &types.ValidationError{Field: "name", Message: fmt.Sprintf("name cannot be longer than %d characters", maxAPIKeyNameLength)}
+	}
+
+	scopes, err := validateScopes(scopes)
+	if err != nil {
+		return types.APIKey{}, err
+	}
+
+	if caller := types.TenantFromContext(ctx); tenant == "" {
+		tenant = caller
+	} else if identity, _ := types.IdentityFromContext(ctx); tenant != caller && identity.Subject != bootstrapSubject {
+		return types.APIKey{}, fmt.Errorf("%w: only the bootstrap key can issue keys for tenant %q", types.ErrForbidden, tenant)
+	}
+
+	prefix, key, err := generateAPIKey()
+	if err != nil {
+		return types.APIKey{}, err
+	}
+
+	apiKey, err := s.Db.CreateAPIKey(ctx, types.APIKey{Tenant: tenant, Name: name, Prefix: prefix, Hash: hashAPIKey(key), Scopes: scopes})
+	if err != nil {
+		return types.APIKey{}, err
+	}
+	apiKey.Key = key
+
+	s.logger.InfoContext(ctx, "issued api key", "id", apiKey.Id, "prefix", apiKey.Prefix, "scopes", apiKey.Scopes, "tenant", apiKey.Tenant)
+
+	return apiKey, nil
+}
+
+// ListAPIKeys returns every APIKey for the caller's tenant, including revoked keys
+func (s *service) ListAPIKeys(ctx context.Context) ([]types.APIKey, error) {
+	return s.Db.ListAPIKeys(ctx)
+}
+
+// RevokeAPIKey stops the APIKey from being used, when it belongs to the caller's tenant
+func (s *service) RevokeAPIKey(ctx context.Context, id int) (types.APIKey, error) {
+	apiKey, err := s.Db.RevokeAPIKey(ctx, id)
+	if err != nil {
+		return types.APIKey{}, err
+	}
+
+	s.logger.InfoContext(ctx, "revoked api key", "id", apiKey.Id, "prefix", apiKey.Prefix)
+
+	return apiKey, nil
+}
+
+// AuthenticateAPIKey returns the Identity for a key that was issued and has not been revoked, in the key's tenant
+// The bootstrap key from the config is accepted as an admin key of the default tenant so the first keys can be issued
+func (s *service) AuthenticateAPIKey(ctx context.Context, key string) (types.Identity, error) {
+	if bootstrap := s.config.Auth.BootstrapKey; bootstrap != "" && subtle.ConstantTimeCompare([]byte(key), []byte(bootstrap)) == 1 {
+		return types.Identity{Subject: bootstrapSubject, Scopes: []types.Scope{types.ScopeAdmin}, Tenant: types.DefaultTenant}, nil
+	}
+
+	prefix, ok := parseAPIKey(key)
+	if !ok {
+		return types.Identity{}, errInvalidAPIKey
+	}
+
+	apiKey, err := s.Db.GetAPIKey(ctx, prefix)
+	if errors.Is(err, types.ErrNotFound) {
+		return types.Identity{}, errInvalidAPIKey
+	} else if err != nil {
+		return types.Identity{}, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashAPIKey(key)), []byte(apiKey.Hash)) != 1 || apiKey.RevokedAt != nil {
+		return types.Identity{}, errInvalidAPIKey
+	}
+
+	return types.Identity{Subject: fmt.Sprintf("apikey:%d", apiKey.Id), Scopes: apiKey.Scopes, Tenant: apiKey.Tenant}, nil
+}
+
+// validateScopes checks at least one scope is given and each one is supported, removing duplicates
+func validateScopes(scopes []types.Scope) ([]types.Scope, error) {
+	if len(scopes) == 0 {
+		return nil, &types.ValidationError{Field: "scopes", Message: "at least one scope is required"}
+	}
+
+	valid := []types.Scope{}
+	for _, scope := range scopes {
+		if !slices.Contains(types.Scopes, scope) {
+			return nil, &types.ValidationError{Field: "scopes", Message: fmt.Sprintf("scope %q is not supported", scope)}
+		}
+
+		if !slices.Contains(valid, scope) {
+			valid = append(valid, scope)
+		}
+	}
+
+	return valid, nil
+}
+
+// generateAPIKey creates a random key in the form mk_<prefix>_<secret>
+// The prefix is used to find the stored key and the secret holds 256 random bits
+func generateAPIKey() (string, string, error) {
+	prefix := make([]byte, 6)
+	secret := make([]byte, 32)
+
+	if _, err := rand.Read(prefix); err != nil {
+		return "", "", err
+	}
+
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+
+	encodedPrefix := hex.EncodeToString(prefix)
+
+	return encodedPrefix, apiKeyPrefix + encodedPrefix + "_" + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// parseAPIKey returns the prefix of a key in the form generated by generateAPIKey
+func parseAPIKey(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, apiKeyPrefix)
+	if !ok {
+		return "", false
+	}
+
+	prefix, secret, ok := strings.Cut(rest, "_")
+	if !ok || prefix == "" || secret == "" {
+		return "", false
+	}
+
+	return prefix, true
+}
+
+// hashAPIKey hashes the key for storage
+// A fast hash is enough as keys hold 256 random bits, unlike passwords which need a slow hash
+func hashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(hash[:])
+}
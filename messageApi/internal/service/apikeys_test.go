@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"messageApi/internal/database"
+	"messageApi/internal/logging"
+	"messageApi/internal/types"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestIssueAndAuthenticateAPIKey tests an issued key authenticates with its scopes until it is revoked
+func TestIssueAndAuthenticateAPIKey(t *testing.T) {
+	db := database.NewMemoryDatabase()
+	service, _ := NewService(types.Config{}, db, logging.Discard())
+	ctx := context.Background()
+
+	key, err := service.IssueAPIKey(ctx, " reader ", []types.Scope{types.ScopeRead, types.ScopeRead}, "")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "reader", key.Name)
+	assert.Equal(t, []types.Scope{types.ScopeRead}, key.Scopes)
+	assert.True(t, strings.HasPrefix(key.Key, "mk_"+key.Prefix+"_"), key.Key)
+
+	stored, _ := db.GetAPIKey(ctx, key.Prefix)
+	assert.Equal(t, hashAPIKey(key.Key), stored.Hash)
+	assert.Equal(t, "", stored.Key)
+
+	identity, err := service.AuthenticateAPIKey(ctx, key.Key)
+	assert.Equal(t, types.Identity{Subject: "apikey:1", Scopes: []types.Scope{types.ScopeRead}, Tenant: types.DefaultTenant}, identity)
+	assert.Equal(t, nil, err)
+
+	_, err = service.RevokeAPIKey(ctx, key.Id)
+	assert.Equal(t, nil, err)
+
+	_, err = service.AuthenticateAPIKey(ctx, key.Key)
+	assert.Equal(t, errInvalidAPIKey, err)
+}
+
+// TestAuthenticateAPIKeyInvalid tests keys that were not issued are rejected as unauthorized
+func TestAuthenticateAPIKeyInvalid(t *testing.T) {
+	service, _ := NewService(types.Config{}, database.NewMemoryDatabase(), logging.Discard())
+	ctx := context.Background()
+
+	key, _ := service.IssueAPIKey(ctx, "writer", []types.Scope{types.ScopeWrite}, "")
+
+	for _, invalid := range []string{"", "not-a-key", "mk_" + key.Prefix + "_", "mk_unknown_secret", key.Key + "x"} {
+		_, err := service.AuthenticateAPIKey(ctx, invalid)
+		assert.True(t, errors.Is(err, ErrUnauthorized), "%q: expected unauthorized, got %v", invalid, err)
+	}
+}
+
+// TestAuthenticateAPIKeyUnavailable tests database failures are not reported as invalid keys
+func TestAuthenticateAPIKeyUnavailable(t *testing.T) {
+	db_stub := database.DatabaseStub{GetAPIKeyError: ErrUnavailable}
+	service, _ := NewService(types.Config{}, &db_stub, logging.Discard())
+
+	_, err := service.AuthenticateAPIKey(context.Background(), "mk_abc_secret")
+
+	assert.Equal(t, ErrUnavailable, err)
+}
+
+// TestAuthenticateBootstrapKey tests the bootstrap key from the config is an admin key
+func TestAuthenticateBootstrapKey(t *testing.T) {
+	service, _ := NewService(types.Config{Auth: types.AuthConfig{BootstrapKey: "bootstrap-secret"}}, &database.DatabaseStub{}, logging.Discard())
+
+	identity, err := service.AuthenticateAPIKey(context.Background(), "bootstrap-secret")
+
+	assert.Equal(t, types.Identity{Subject: "apikey:bootstrap", Scopes: []types.Scope{types.ScopeAdmin}, Tenant: types.DefaultTenant}, identity)
+	assert.Equal(t, nil, err)
+}
+
+// TestAPIKeyTenant tests keys belong to the tenant they were issued for and are only listed and revoked there
+func TestAPIKeyTenant(t *testing.T) {
+	service, _ := NewService(types.Config{Auth: types.AuthConfig{BootstrapKey: "bootstrap-secret"}}, database.NewMemoryDatabase(), logging.Discard())
+	bootstrap, _ := service.AuthenticateAPIKey(context.Background(), "bootstrap-secret")
+	ctx := types.WithTenant(types.WithIdentity(context.Background(), bootstrap), bootstrap.Tenant)
+
+	key, err := service.IssueAPIKey(ctx, "team admin", []types.Scope{types.ScopeAdmin}, "team-a")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "team-a", key.Tenant)
+
+	identity, err := service.AuthenticateAPIKey(context.Background(), key.Key)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "team-a", identity.Tenant)
+
+	keys, _ := service.ListAPIKeys(ctx)
+	assert.Equal(t, []types.APIKey{}, keys)
+
+	_, err = service.RevokeAPIKey(ctx, key.Id)
+	assert.True(t, errors.Is(err, ErrNotFound), "revoke: expected not found, got %v", err)
+
+	team := types.WithTenant(types.WithIdentity(context.Background(), identity), identity.Tenant)
+	_, err = service.IssueAPIKey(team, "other", []types.Scope{types.ScopeRead}, types.DefaultTenant)
+	assert.True(t, errors.Is(err, ErrForbidden), "issue: expected forbidden, got %v", err)
+
+	keys, _ = service.ListAPIKeys(team)
+	assert.Equal(t, 1, len(keys))
+
+	_, err = service.RevokeAPIKey(team, key.Id)
+	assert.Equal(t, nil, err)
+}
+
+// TestIssueAPIKeyValidation tests the name and scopes of an API key are validated
+func TestIssueAPIKeyValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes []types.Scope
+		field  string
+	}{
+		{"", []types.Scope{types.ScopeRead}, "name"},
+		{strings.Repeat("a", maxAPIKeyNameLength+1), []types.Scope{types.ScopeRead}, "name"},
+		{"key", nil, "scopes"},
+		{"key", []types.Scope{"delete"}, "scopes"},
+	}
+
+	db_stub := database.DatabaseStub{}
+	service, _ := NewService(types.Config{}, &db_stub, logging.Discard())
+
+	for _, tt := range tests {
+		_, err := service.IssueAPIKey(context.Background(), tt.name, tt.scopes, "")
+
+		var validationErr *types.ValidationError
+		assert.True(t, errors.As(err, &validationErr), "expected validation error, got %v", err)
+		assert.Equal(t, tt.field, validationErr.Field)
+	}
+
+	assert.Equal(t, types.APIKey{}, db_stub.CreateAPIKeyInput)
+}
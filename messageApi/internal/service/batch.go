@@ -0,0 +1,296 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"messageApi/internal/database"
+	"messageApi/internal/tracing"
+	"messageApi/internal/types"
+	"slices"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// defaultBatchMaxOperations is the most changes a batch can hold when it is not set in the config
+const defaultBatchMaxOperations = 5000
+
+// ExecuteBatch validates and makes each change in the batch, returning a result for every change in the same order
+// A transactional batch makes every change or none of them. Otherwise each change can fail on its own, and the creates
+// are made before the updates and deletes. An error is only returned when the batch as a whole cannot be handled
+func (s *service) ExecuteBatch(ctx context.Context, ops []types.BatchOperation, transactional bool) (_ []types.BatchResult, err error) {
+	ctx, span := startSpan(ctx, "ExecuteBatch", attribute.Int("batch.size", len(ops)), attribute.Bool("batch.transactional", transactional))
+	defer func() { tracing.EndSpan(span, err) }()
+
+	identity, err := callerIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ops) == 0 || len(ops) > s.config.Batch.MaxOperations {
+		return nil, &types.ValidationError{Field: "operations", Message: fmt.Sprintf("a batch must hold between 1 and %d operations", s.config.Batch.MaxOperations)}
+	}
+
+	// the operations are prepared in place, so they are copied to leave the caller's unchanged
+	ops = slices.Clone(ops)
+	results := make([]types.BatchResult, len(ops))
+	for i := range ops {
+		results[i].Err = s.prepareOperation(ctx, identity, &ops[i])
+	}
+
+	if err := s.authorizeOperations(ctx, identity, ops, results); err != nil {
+		return nil, err
+	}
+
+	// the transaction checking the quota can be run more than once, so each run starts from the prepared results
+	prepared := slices.Clone(results)
+	apply := func(db database.Database) error {
+		copy(results, prepared)
+
+		if err := s.checkBatchQuota(ctx, db, ops, results); err != nil {
+			return err
+		}
+
+		if transactional {
+			return s.applyTransactional(ctx, db, ops, results)
+		}
+
+		s.applyEach(ctx, db, ops, results)
+		return nil
+	}
+
+	// the quota is checked in the same transaction as the creates, so concurrent creates cannot take the tenant over it
+	if s.tenantQuota(types.TenantFromContext(ctx)) > 0 {
+		err = s.Db.WithTx(ctx, apply)
+	} else {
+		err = apply(s.Db)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	failed := 0
+	for i, result := range results {
+		if result.Err != nil {
+			failed++
+		} else if ops[i].Op == types.BatchCreate {
+			s.metrics.countCreated(result.Message)
+		}
+	}
+
+	s.logger.DebugContext(ctx, "executed batch", "size", len(ops), "failed", failed, "transactional", transactional)
+
+	span.SetAttributes(attribute.Int("batch.failed", failed))
+
+	return results, nil
+}
+
+// prepareOperation validates the Message of the change and sets whether it is a palindrome
+// Creates are owned by the caller, and the owners of updated and deleted Messages are checked by authorizeOperations
+// Updates and deletes must give a version when REQUIRE_IF_MATCH is set, as requests changing a single Message must
+func (s *service) prepareOperation(ctx context.Context, identity types.Identity, op *types.BatchOperation) error {
+	if op.Op == types.BatchUpdate || op.Op == types.BatchDelete {
+		if s.config.Server.RequireIfMatch && op.Version <= 0 {
+			return fmt.Errorf("%w: the version of message %d must be given", types.ErrPreconditionRequired, op.Id)
+		}
+	}
+
+	switch op.Op {
+	case types.BatchCreate:
+		op.Id, op.Version, op.Owner = 0, 0, identity.Subject
+		return s.checkMessage(ctx, &op.Message)
+	case types.BatchUpdate:
+		return s.checkMessage(ctx, &op.Message)
+	case types.BatchDelete:
+		return nil
+	default:
+		return &types.ValidationError{Field: "op", Message: fmt.Sprintf("op must be %s, %s or %s", types.BatchCreate, types.BatchUpdate, types.BatchDelete)}
+	}
+}
+
+// authorizeOperations checks the caller owns the Message of each update and delete or is an admin, loading them all in a
+// single query. The first change to each Message also fails with a precondition error when the Message is not at the
+// version given, so stale changes are found before the others are stored
+func (s *service) authorizeOperations(ctx context.Context, identity types.Identity, ops []types.BatchOperation, results []types.BatchResult) error {
+	ids := []int{}
+	for i, op := range ops {
+		if op.Op != types.BatchCreate && results[i].Err == nil {
+			ids = append(ids, op.Id)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	msgs, err := s.Db.GetMessages(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	stored := make(map[int]types.Message, len(msgs))
+	for _, msg := range msgs {
+		stored[msg.Id] = msg
+	}
+
+	checked := map[int]bool{}
+	for i, op := range ops {
+		if op.Op == types.BatchCreate || results[i].Err != nil {
+			continue
+		}
+
+		msg, ok := stored[op.Id]
+		switch {
+		case !ok:
+			results[i].Err = fmt.Errorf("message %d %w", op.Id, types.ErrNotFound)
+		case !checked[op.Id] && op.Version > 0 && op.Version != msg.Version:
+			results[i].Err = fmt.Errorf("%w: message %d is at version %d, not %d", types.ErrPrecondition, msg.Id, msg.Version, op.Version)
+		default:
+			results[i].Err = s.checkOwner(ctx, identity, msg)
+		}
+		checked[op.Id] = true
+	}
+
+	return nil
+}
+
+// checkBatchQuota fails the creates in the batch that would take the tenant in the context over its quota
+// Deletes in the same batch are not counted, so a full tenant has to delete Messages before creating more
+func (s *service) checkBatchQuota(ctx context.Context, db database.Database, ops []types.BatchOperation, results []types.BatchResult) error {
+	tenant := types.TenantFromContext(ctx)
+
+	quota := s.tenantQuota(tenant)
+	if quota <= 0 {
+		return nil
+	}
+
+	count, err := db.CountMessages(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i, op := range ops {
+		if op.Op != types.BatchCreate || results[i].Err != nil {
+			continue
+		}
+
+		if count >= quota {
+			results[i].Err = quotaError(tenant, quota)
+		} else {
+			count++
+		}
+	}
+
+	return nil
+}
+
+// applyTransactional makes every change in the batch with the data module in a single transaction
+// When any change fails none are made, and the changes that did not fail get ErrAborted
+func (s *service) applyTransactional(ctx context.Context, db database.Database, ops []types.BatchOperation, results []types.BatchResult) error {
+	if !hasFailure(results) {
+		msgs, err := db.ApplyBatch(ctx, ops)
+
+		var batchErr *types.BatchError
+		if errors.As(err, &batchErr) {
+			results[batchErr.Index].Err = batchErr.Err
+		} else if err != nil {
+			return err
+		} else {
+			for i := range results {
+				results[i].Message = msgs[i]
+			}
+			return nil
+		}
+	}
+
+	for i := range results {
+		if results[i].Err == nil {
+			results[i].Err = fmt.Errorf("%w: operation %d was not made as another operation in the batch failed", types.ErrAborted, i)
+		}
+	}
+
+	return nil
+}
+
+// applyEach makes each change in the batch that passed validation on its own, so one failure does not stop the others
+// The changes are stored together, which is much faster for large imports, with the creates before the updates and
+// deletes. When a change fails, the changes before it are stored again and then the changes after it, so each change
+// is only sent a few times however many fail. When storing them together fails for any other reason they are stored
+// one at a time
+func (s *service) applyEach(ctx context.Context, db database.Database, ops []types.BatchOperation, results []types.BatchResult) {
+	creates, changes := []int{}, []int{}
+	for i, op := range ops {
+		if results[i].Err != nil {
+			continue
+		}
+
+		if op.Op == types.BatchCreate {
+			creates = append(creates, i)
+		} else {
+			changes = append(changes, i)
+		}
+	}
+
+	// the changes before a failure were rolled back with it, so they are queued ahead of the changes after it to keep
+	// the order they are made in
+	queue := [][]int{append(creates, changes...)}
+	for len(queue) > 0 {
+		pending := queue[0]
+		queue = queue[1:]
+		if len(pending) == 0 {
+			continue
+		}
+
+		batch := make([]types.BatchOperation, len(pending))
+		for j, i := range pending {
+			batch[j] = ops[i]
+		}
+
+		msgs, err := db.ApplyBatch(ctx, batch)
+
+		var batchErr *types.BatchError
+		switch {
+		case err == nil:
+			for j, i := range pending {
+				results[i].Message = msgs[j]
+			}
+		case errors.As(err, &batchErr):
+			results[pending[batchErr.Index]].Err = batchErr.Err
+			queue = append([][]int{pending[:batchErr.Index], pending[batchErr.Index+1:]}, queue...)
+		default:
+			s.logger.DebugContext(ctx, "storing batch changes together failed, storing them one at a time", "error", err)
+			for _, i := range pending {
+				results[i].Message, results[i].Err = applyOne(ctx, db, ops[i])
+			}
+		}
+	}
+}
+
+// applyOne makes a single change in the batch in its own transaction, so when the batch is made in a transaction a
+// failed change only rolls back itself
+func applyOne(ctx context.Context, db database.Database, op types.BatchOperation) (msg types.Message, err error) {
+	err = db.WithTx(ctx, func(tx database.Database) (err error) {
+		switch op.Op {
+		case types.BatchCreate:
+			msg, err = tx.CreateMessage(ctx, op.Message)
+		case types.BatchUpdate:
+			msg, err = tx.UpdateMessage(ctx, op.Message)
+		default:
+			msg, err = types.Message{Id: op.Id}, tx.DeleteMessage(ctx, op.Id, op.Version)
+		}
+		return err
+	})
+
+	return msg, err
+}
+
+// hasFailure returns true when any of the results has an error
+func hasFailure(results []types.BatchResult) bool {
+	for _, result := range results {
+		if result.Err != nil {
+			return true
+		}
+	}
+
+	return false
+}
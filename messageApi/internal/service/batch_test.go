@@ -0,0 +1,330 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"messageApi/internal/database"
+	"messageApi/internal/logging"
+	"messageApi/internal/types"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestExecuteBatch tests each change in a batch is validated and made on its own, so failures do not stop the others
+func TestExecuteBatch(t *testing.T) {
+	db := database.NewMemoryDatabase()
+	service, _ := NewService(types.Config{}, db, logging.Discard())
+	ctx := ownerContext()
+
+	existing, _ := service.CreateMessage(ctx, types.Message{Message: "test"})
+	other, _ := service.CreateMessage(types.WithIdentity(context.Background(), types.Identity{Subject: "user-2"}), types.Message{Message: "kayak"})
+
+	ops := []types.BatchOperation{
+		{Op: types.BatchCreate, Message: types.Message{Id: 99, Message: "racecar", Owner: "user-2"}},
+		{Op: types.BatchUpdate, Message: types.Message{Id: existing.Id, Message: "level", Version: 1}},
+		{Op: types.BatchCreate, Message: types.Message{Message: ""}},
+		{Op: types.BatchDelete, Message: types.Message{Id: other.Id}},
+		{Op: "rename", Message: types.Message{Id: existing.Id}},
+		{Op: types.BatchDelete, Message: types.Message{Id: 404}},
+	}
+
+	results, err := service.ExecuteBatch(ctx, ops, false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, len(ops), len(results))
+
+	assert.Equal(t, nil, results[0].Err)
+	assert.Equal(t, types.Message{Id: 3, Message: "racecar", IsPalindrome: true, PalindromeMode: types.PalindromeExact, Owner: "user-1", Version: 1}, results[0].Message)
+
+	assert.Equal(t, nil, results[1].Err)
+	assert.Equal(t, true, results[1].Message.IsPalindrome)
+	assert.Equal(t, 2, results[1].Message.Version)
+
+	assert.True(t, errors.Is(results[2].Err, ErrValidation), "expected validation error, got %v", results[2].Err)
+	assert.True(t, errors.Is(results[3].Err, ErrForbidden), "expected forbidden, got %v", results[3].Err)
+	assert.True(t, errors.Is(results[4].Err, ErrValidation), "expected validation error, got %v", results[4].Err)
+	assert.True(t, errors.Is(results[5].Err, ErrNotFound), "expected not found, got %v", results[5].Err)
+
+	assert.Equal(t, types.Message{Id: 99, Message: "racecar", Owner: "user-2"}, ops[0].Message)
+
+	count, _ := db.CountMessages(ctx)
+	assert.Equal(t, 3, count)
+}
+
+// TestExecuteBatchTransactional tests no change in a transactional batch is made when any of them fails
+func TestExecuteBatchTransactional(t *testing.T) {
+	db := database.NewMemoryDatabase()
+	service, _ := NewService(types.Config{}, db, logging.Discard())
+	ctx := ownerContext()
+
+	existing, _ := service.CreateMessage(ctx, types.Message{Message: "test"})
+
+	ops := []types.BatchOperation{
+		{Op: types.BatchCreate, Message: types.Message{Message: "racecar"}},
+		{Op: types.BatchUpdate, Message: types.Message{Id: existing.Id, Message: "level", Version: 2}},
+	}
+
+	results, err := service.ExecuteBatch(ctx, ops, true)
+	assert.Equal(t, nil, err)
+	assert.True(t, errors.Is(results[0].Err, ErrAborted), "expected aborted, got %v", results[0].Err)
+	assert.True(t, errors.Is(results[1].Err, ErrPrecondition), "expected precondition failure, got %v", results[1].Err)
+
+	ops = append(ops, types.BatchOperation{Op: types.BatchCreate})
+	results, _ = service.ExecuteBatch(ctx, ops, true)
+	assert.True(t, errors.Is(results[0].Err, ErrAborted), "expected aborted, got %v", results[0].Err)
+	assert.True(t, errors.Is(results[2].Err, ErrValidation), "expected validation error, got %v", results[2].Err)
+
+	count, _ := db.CountMessages(ctx)
+	assert.Equal(t, 1, count)
+
+	ops[1].Version = 1
+	results, err = service.ExecuteBatch(ctx, ops[:2], true)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, results[0].Err)
+	assert.Equal(t, nil, results[1].Err)
+	assert.Equal(t, "level", results[1].Message.Message)
+
+	count, _ = db.CountMessages(ctx)
+	assert.Equal(t, 2, count)
+}
+
+// TestExecuteBatchQuota tests only the creates that fit in the tenant's quota are made
+func TestExecuteBatchQuota(t *testing.T) {
+	service, _ := NewService(types.Config{Tenant: types.TenantConfig{MaxMessages: 2}}, database.NewMemoryDatabase(), logging.Discard())
+	ctx := ownerContext()
+
+	service.CreateMessage(ctx, types.Message{Message: "test"})
+
+	ops := []types.BatchOperation{
+		{Op: types.BatchCreate, Message: types.Message{Message: "racecar"}},
+		{Op: types.BatchCreate, Message: types.Message{Message: "kayak"}},
+	}
+
+	results, err := service.ExecuteBatch(ctx, ops, false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, results[0].Err)
+	assert.True(t, errors.Is(results[1].Err, ErrQuota), "expected quota error, got %v", results[1].Err)
+}
+
+// TestExecuteBatchQuotaConcurrent tests concurrent batches and single creates cannot take the tenant over its quota
+func TestExecuteBatchQuotaConcurrent(t *testing.T) {
+	db := database.NewMemoryDatabase()
+	service, _ := NewService(types.Config{Tenant: types.TenantConfig{MaxMessages: 5}}, db, logging.Discard())
+	ops := []types.BatchOperation{
+		{Op: types.BatchCreate, Message: types.Message{Message: "racecar"}},
+		{Op: types.BatchCreate, Message: types.Message{Message: "kayak"}},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			service.ExecuteBatch(ownerContext(), ops, false)
+		}()
+		go func() {
+			defer wg.Done()
+			service.CreateMessage(ownerContext(), types.Message{Message: "level"})
+		}()
+	}
+	wg.Wait()
+
+	count, _ := db.CountMessages(context.Background())
+	assert.Equal(t, 5, count)
+}
+
+// TestExecuteBatchQuotaTx tests the quota is checked in the same transaction as the creates, and only when there is one
+func TestExecuteBatchQuotaTx(t *testing.T) {
+	db_stub := database.DatabaseStub{CountMessagesResponse: 1, ApplyBatchResponse: []types.Message{{Id: 2}}}
+	service, _ := NewService(types.Config{Tenant: types.TenantConfig{MaxMessages: 2}}, &db_stub, logging.Discard())
+	ops := []types.BatchOperation{{Op: types.BatchCreate, Message: types.Message{Message: "racecar"}}}
+
+	results, err := service.ExecuteBatch(ownerContext(), ops, false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []types.BatchResult{{Message: types.Message{Id: 2}}}, results)
+	assert.Equal(t, 1, db_stub.WithTxCalls)
+
+	output_err := errors.New("serialization failure")
+	db_stub.WithTxError = output_err
+	_, err = service.ExecuteBatch(ownerContext(), ops, true)
+	assert.Equal(t, output_err, err)
+
+	db_stub = database.DatabaseStub{ApplyBatchResponse: []types.Message{{Id: 2}}}
+	service, _ = NewService(types.Config{}, &db_stub, logging.Discard())
+	_, err = service.ExecuteBatch(ownerContext(), ops, false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, db_stub.WithTxCalls)
+}
+
+// TestExecuteBatchSize tests empty batches and batches over the configured size are rejected
+func TestExecuteBatchSize(t *testing.T) {
+	service, _ := NewService(types.Config{Batch: types.BatchConfig{MaxOperations: 1}}, database.NewMemoryDatabase(), logging.Discard())
+	create := types.BatchOperation{Op: types.BatchCreate, Message: types.Message{Message: "racecar"}}
+
+	_, err := service.ExecuteBatch(ownerContext(), []types.BatchOperation{}, false)
+	assert.True(t, errors.Is(err, ErrValidation), "empty: expected validation error, got %v", err)
+
+	_, err = service.ExecuteBatch(ownerContext(), []types.BatchOperation{create, create}, false)
+	assert.True(t, errors.Is(err, ErrValidation), "too many: expected validation error, got %v", err)
+
+	_, err = service.ExecuteBatch(context.Background(), []types.BatchOperation{create}, false)
+	assert.True(t, errors.Is(err, ErrUnauthorized), "anonymous: expected unauthorized, got %v", err)
+}
+
+// TestExecuteBatchFallback tests creates are stored one at a time when storing them together fails
+func TestExecuteBatchFallback(t *testing.T) {
+	output_msg := types.Message{Id: 1, Message: "racecar", IsPalindrome: true, PalindromeMode: types.PalindromeExact, Owner: "user-1", Version: 1}
+	db_stub := database.DatabaseStub{
+		ApplyBatchError:       errors.New("copy failed"),
+		CreateMessageResponse: output_msg,
+	}
+	service, _ := NewService(types.Config{}, &db_stub, logging.Discard())
+
+	results, err := service.ExecuteBatch(ownerContext(), []types.BatchOperation{{Op: types.BatchCreate, Message: types.Message{Message: "racecar"}}}, false)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []types.BatchResult{{Message: output_msg}}, results)
+	assert.Equal(t, "user-1", db_stub.CreateMessageInput.Owner)
+}
+
+// TestExecuteBatchTransactionalError tests an error storing a transactional batch is returned for the whole batch
+func TestExecuteBatchTransactionalError(t *testing.T) {
+	output_err := errors.New("database unavailable")
+	db_stub := database.DatabaseStub{ApplyBatchError: output_err}
+	service, _ := NewService(types.Config{}, &db_stub, logging.Discard())
+
+	_, err := service.ExecuteBatch(ownerContext(), []types.BatchOperation{{Op: types.BatchCreate, Message: types.Message{Message: "racecar"}}}, true)
+
+	assert.Equal(t, output_err, err)
+	assert.Equal(t, 1, len(db_stub.ApplyBatchInput))
+}
+
+// TestExecuteBatchLoadsMessagesOnce tests the Messages changed by a batch are loaded in one call and stored together
+func TestExecuteBatchLoadsMessagesOnce(t *testing.T) {
+	stored := []types.Message{{Id: 1, Message: "test", Owner: "user-1", Version: 1}, {Id: 2, Message: "kayak", Owner: "user-2", Version: 3}}
+	db_stub := database.DatabaseStub{GetMessagesResponse: stored, ApplyBatchResponse: []types.Message{{Id: 5}, {Id: 1}, {Id: 1}}}
+	service, _ := NewService(types.Config{}, &db_stub, logging.Discard())
+
+	ops := []types.BatchOperation{
+		{Op: types.BatchDelete, Message: types.Message{Id: 1}},
+		{Op: types.BatchUpdate, Message: types.Message{Id: 2, Message: "level"}},
+		{Op: types.BatchDelete, Message: types.Message{Id: 1, Version: 4}},
+		{Op: types.BatchDelete, Message: types.Message{Id: 404}},
+		{Op: types.BatchCreate, Message: types.Message{Message: "racecar"}},
+	}
+
+	results, err := service.ExecuteBatch(ownerContext(), ops, false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []int{1, 2, 1, 404}, db_stub.GetMessagesInput)
+
+	assert.True(t, errors.Is(results[1].Err, ErrForbidden), "expected forbidden, got %v", results[1].Err)
+	assert.True(t, errors.Is(results[3].Err, ErrNotFound), "expected not found, got %v", results[3].Err)
+
+	assert.Equal(t, 3, len(db_stub.ApplyBatchInput))
+	assert.Equal(t, types.BatchCreate, db_stub.ApplyBatchInput[0].Op)
+	assert.Equal(t, types.Message{Id: 5}, results[4].Message)
+	assert.Equal(t, types.Message{Id: 1}, results[0].Message)
+}
+
+// TestExecuteBatchStale tests a stale change is found before storing, and a change failing while stored does not stop the others
+func TestExecuteBatchStale(t *testing.T) {
+	db := database.NewMemoryDatabase()
+	service, _ := NewService(types.Config{}, db, logging.Discard())
+	ctx := ownerContext()
+
+	existing, _ := service.CreateMessage(ctx, types.Message{Message: "test"})
+	other, _ := service.CreateMessage(ctx, types.Message{Message: "kayak"})
+
+	ops := []types.BatchOperation{
+		{Op: types.BatchUpdate, Message: types.Message{Id: other.Id, Message: "level", Version: 2}},
+		{Op: types.BatchUpdate, Message: types.Message{Id: existing.Id, Message: "level", Version: 1}},
+		{Op: types.BatchUpdate, Message: types.Message{Id: existing.Id, Message: "refer", Version: 1}},
+		{Op: types.BatchCreate, Message: types.Message{Message: "racecar"}},
+	}
+
+	results, err := service.ExecuteBatch(ctx, ops, false)
+	assert.Equal(t, nil, err)
+	assert.True(t, errors.Is(results[0].Err, ErrPrecondition), "expected precondition failure, got %v", results[0].Err)
+	assert.Equal(t, nil, results[1].Err)
+	assert.True(t, errors.Is(results[2].Err, ErrPrecondition), "expected precondition failure, got %v", results[2].Err)
+	assert.Equal(t, nil, results[3].Err)
+
+	stored, _ := db.GetMessage(ctx, existing.Id)
+	assert.Equal(t, "level", stored.Message)
+
+	count, _ := db.CountMessages(ctx)
+	assert.Equal(t, 3, count)
+}
+
+// countingDatabase records the size of each batch stored so the number of times changes are resubmitted can be checked
+type countingDatabase struct {
+	database.Database
+	sizes []int
+}
+
+// ApplyBatch records the size of the batch then stores it
+func (d *countingDatabase) ApplyBatch(ctx context.Context, ops []types.BatchOperation) ([]types.Message, error) {
+	d.sizes = append(d.sizes, len(ops))
+	return d.Database.ApplyBatch(ctx, ops)
+}
+
+// TestExecuteBatchResubmits tests only the changes around each failure are stored again, rather than every remaining change
+func TestExecuteBatchResubmits(t *testing.T) {
+	db := &countingDatabase{Database: database.NewMemoryDatabase()}
+	service, _ := NewService(types.Config{}, db, logging.Discard())
+	ctx := ownerContext()
+
+	first, _ := service.CreateMessage(ctx, types.Message{Message: "test"})
+	second, _ := service.CreateMessage(ctx, types.Message{Message: "kayak"})
+
+	ops := []types.BatchOperation{
+		{Op: types.BatchUpdate, Message: types.Message{Id: first.Id, Message: "level", Version: 1}},
+		{Op: types.BatchUpdate, Message: types.Message{Id: first.Id, Message: "refer", Version: 1}},
+		{Op: types.BatchUpdate, Message: types.Message{Id: second.Id, Message: "level", Version: 1}},
+		{Op: types.BatchUpdate, Message: types.Message{Id: second.Id, Message: "refer", Version: 1}},
+		{Op: types.BatchCreate, Message: types.Message{Message: "racecar"}},
+	}
+
+	results, err := service.ExecuteBatch(ctx, ops, false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []int{5, 2, 2, 1}, db.sizes)
+
+	assert.Equal(t, nil, results[0].Err)
+	assert.True(t, errors.Is(results[1].Err, ErrPrecondition), "expected precondition failure, got %v", results[1].Err)
+	assert.Equal(t, nil, results[2].Err)
+	assert.True(t, errors.Is(results[3].Err, ErrPrecondition), "expected precondition failure, got %v", results[3].Err)
+	assert.Equal(t, nil, results[4].Err)
+
+	for _, id := range []int{first.Id, second.Id} {
+		stored, _ := db.GetMessage(ctx, id)
+		assert.Equal(t, "level", stored.Message)
+		assert.Equal(t, 2, stored.Version)
+	}
+}
+
+// TestExecuteBatchRequireIfMatch tests updates and deletes without a version fail when REQUIRE_IF_MATCH is set
+func TestExecuteBatchRequireIfMatch(t *testing.T) {
+	db := database.NewMemoryDatabase()
+	service, _ := NewService(types.Config{Server: types.ServerConfig{RequireIfMatch: true}}, db, logging.Discard())
+	ctx := ownerContext()
+
+	existing, _ := service.CreateMessage(ctx, types.Message{Message: "test"})
+
+	ops := []types.BatchOperation{
+		{Op: types.BatchUpdate, Message: types.Message{Id: existing.Id, Message: "level"}},
+		{Op: types.BatchDelete, Message: types.Message{Id: existing.Id}},
+		{Op: types.BatchCreate, Message: types.Message{Message: "racecar"}},
+		{Op: types.BatchUpdate, Message: types.Message{Id: existing.Id, Message: "kayak", Version: 1}},
+	}
+
+	results, err := service.ExecuteBatch(ctx, ops, false)
+	assert.Equal(t, nil, err)
+	assert.True(t, errors.Is(results[0].Err, ErrPreconditionRequired), "update: expected precondition required, got %v", results[0].Err)
+	assert.True(t, errors.Is(results[1].Err, ErrPreconditionRequired), "delete: expected precondition required, got %v", results[1].Err)
+	assert.Equal(t, nil, results[2].Err)
+	assert.Equal(t, nil, results[3].Err)
+
+	stored, _ := db.GetMessage(ctx, existing.Id)
+	assert.Equal(t, "kayak", stored.Message)
+}
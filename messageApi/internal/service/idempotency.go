@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"messageApi/internal/types"
+	"time"
+)
+
+// defaultIdempotencyTTL is how long responses are kept for retries when it is not set in the config
+const defaultIdempotencyTTL = 24 * time.Hour
+
+// defaultIdempotencyLease is how long a key is reserved for the first request when requests have no timeout
+const defaultIdempotencyLease = time.Minute
+
+// BeginIdempotentRequest reserves the caller's Idempotency-Key for a request identified by the hash
+// The stored record is returned with true when the key already has a response to replay
+// A conflict error is returned while the first request with the key is still being handled and an
+// idempotency error when the key was used for a different request. The key is only reserved for as long as a request
+// can run, so a retry can take it over when the first request stopped without completing or abandoning it
+func (s *service) BeginIdempotentRequest(ctx context.Context, key string, requestHash string) (types.IdempotencyRecord, bool, error) {
+	identity, err := callerIdentity(ctx)
+	if err != nil {
+		return types.IdempotencyRecord{}, false, err
+	}
+
+	if err := validateIdempotencyKey(key); err != nil {
+		return types.IdempotencyRecord{}, false, err
+	}
+
+	record := types.IdempotencyRecord{
+		Key:         key,
+		Caller:      identity.Subject,
+		RequestHash: requestHash,
+		ExpiresAt:   time.Now().Add(s.idempotencyLease()),
+	}
+
+	// the existing record can expire or be abandoned between the insert and the read, so the insert is tried once more
+	for attempt := 0; attempt < 2; attempt++ {
+		err := s.Db.CreateIdempotencyRecord(ctx, record)
+		if err == nil {
+			return types.IdempotencyRecord{}, false, nil
+		} else if !errors.Is(err, types.ErrConflict) {
+			return types.IdempotencyRecord{}, false, err
+		}
+
+		existing, err := s.Db.GetIdempotencyRecord(ctx, identity.Subject, key)
+		if errors.Is(err, types.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return types.IdempotencyRecord{}, false, err
+		}
+
+		if existing.RequestHash != requestHash {
+			return types.IdempotencyRecord{}, false, fmt.Errorf("%w: idempotency key %q was used for a different request", types.ErrIdempotency, key)
+		}
+
+		if existing.Status == 0 {
+			return types.IdempotencyRecord{}, false, fmt.Errorf("%w: a request with idempotency key %q is still in progress", types.ErrConflict, key)
+		}
+
+		s.logger.DebugContext(ctx, "replaying idempotent request", "key", key, "status", existing.Status)
+
+		return existing, true, nil
+	}
+
+	return types.IdempotencyRecord{}, false, fmt.Errorf("%w: a request with idempotency key %q is still in progress", types.ErrConflict, key)
+}
+
+// CompleteIdempotentRequest stores the response to a request reserved by BeginIdempotentRequest so retries replay it
+// for the configured TTL
+func (s *service) CompleteIdempotentRequest(ctx context.Context, record types.IdempotencyRecord) error {
+	identity, err := callerIdentity(ctx)
+	if err != nil {
+		return err
+	}
+	record.Caller = identity.Subject
+	record.ExpiresAt = time.Now().Add(s.config.Idempotency.TTL)
+
+	return s.Db.CompleteIdempotencyRecord(ctx, record)
+}
+
+// AbandonIdempotentRequest releases the caller's Idempotency-Key without a response so the request can be retried
+func (s *service) AbandonIdempotentRequest(ctx context.Context, key string) error {
+	identity, err := callerIdentity(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = s.Db.DeleteIdempotencyRecord(ctx, identity.Subject, key)
+	if errors.Is(err, types.ErrNotFound) {
+		return nil
+	}
+
+	return err
+}
+
+// idempotencyLease returns how long a key is reserved for the first request, which is as long as a request can run
+func (s *service) idempotencyLease() time.Duration {
+	if s.config.Server.RequestTimeout > 0 {
+		return s.config.Server.RequestTimeout
+	}
+
+	return defaultIdempotencyLease
+}
+
+// validateIdempotencyKey checks the key is not empty, fits in the database and only holds visible ASCII characters
+func validateIdempotencyKey(key string) error {
+	if key == "" || len(key) > types.MaxIdempotencyKeyLength {
+		return &types.ValidationError{Field: "Idempotency-Key", Message: fmt.Sprintf("Idempotency-Key must be between 1 and %d characters", types.MaxIdempotencyKeyLength)}
+	}
+
+	for _, r := range key {
+		if r < '!' || r > '~' {
+			return &types.ValidationError{Field: "Idempotency-Key", Message: "Idempotency-Key can only hold visible ASCII characters"}
+		}
+	}
+
+	return nil
+}
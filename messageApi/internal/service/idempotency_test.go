@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"messageApi/internal/database"
+	"messageApi/internal/logging"
+	"messageApi/internal/types"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestIdempotentRequest tests a key is reserved by the first request and its response is replayed to retries
+func TestIdempotentRequest(t *testing.T) {
+	db := database.NewMemoryDatabase()
+	service, _ := NewService(types.Config{}, db, logging.Discard())
+	ctx := ownerContext()
+
+	_, replay, err := service.BeginIdempotentRequest(ctx, "key-1", "hash")
+	assert.False(t, replay)
+	assert.Equal(t, nil, err)
+
+	stored, _ := db.GetIdempotencyRecord(ctx, "user-1", "key-1")
+	assert.WithinDuration(t, time.Now().Add(defaultIdempotencyLease), stored.ExpiresAt, time.Second)
+
+	_, _, err = service.BeginIdempotentRequest(ctx, "key-1", "hash")
+	assert.True(t, errors.Is(err, ErrConflict), "expected conflict, got %v", err)
+
+	_, _, err = service.BeginIdempotentRequest(ctx, "key-1", "other")
+	assert.True(t, errors.Is(err, ErrIdempotency), "expected idempotency error, got %v", err)
+
+	err = service.CompleteIdempotentRequest(ctx, types.IdempotencyRecord{Key: "key-1", Status: 201, Body: []byte(`{"id":1}`)})
+	assert.Equal(t, nil, err)
+
+	stored, _ = db.GetIdempotencyRecord(ctx, "user-1", "key-1")
+	assert.WithinDuration(t, time.Now().Add(defaultIdempotencyTTL), stored.ExpiresAt, time.Second)
+
+	record, replay, err := service.BeginIdempotentRequest(ctx, "key-1", "hash")
+	assert.True(t, replay)
+	assert.Equal(t, 201, record.Status)
+	assert.Equal(t, []byte(`{"id":1}`), record.Body)
+	assert.Equal(t, nil, err)
+
+	other := types.WithIdentity(context.Background(), types.Identity{Subject: "user-2"})
+	_, replay, err = service.BeginIdempotentRequest(other, "key-1", "hash")
+	assert.False(t, replay)
+	assert.Equal(t, nil, err)
+}
+
+// TestAbandonIdempotentRequest tests an abandoned key can be used again
+func TestAbandonIdempotentRequest(t *testing.T) {
+	service, _ := NewService(types.Config{}, database.NewMemoryDatabase(), logging.Discard())
+	ctx := ownerContext()
+
+	service.BeginIdempotentRequest(ctx, "key-1", "hash")
+	assert.Equal(t, nil, service.AbandonIdempotentRequest(ctx, "key-1"))
+	assert.Equal(t, nil, service.AbandonIdempotentRequest(ctx, "key-1"))
+
+	_, replay, err := service.BeginIdempotentRequest(ctx, "key-1", "other")
+	assert.False(t, replay)
+	assert.Equal(t, nil, err)
+}
+
+// TestIdempotentRequestTakeover tests a retry takes over a key once the first request has run for longer than the
+// request timeout without completing or abandoning it
+func TestIdempotentRequestTakeover(t *testing.T) {
+	db := database.NewMemoryDatabase()
+	service, _ := NewService(types.Config{Server: types.ServerConfig{RequestTimeout: 20 * time.Millisecond}}, db, logging.Discard())
+	ctx := ownerContext()
+
+	_, _, err := service.BeginIdempotentRequest(ctx, "key-1", "hash")
+	assert.Equal(t, nil, err)
+
+	_, _, err = service.BeginIdempotentRequest(ctx, "key-1", "hash")
+	assert.True(t, errors.Is(err, ErrConflict), "expected conflict while reserved, got %v", err)
+
+	time.Sleep(30 * time.Millisecond)
+
+	_, replay, err := service.BeginIdempotentRequest(ctx, "key-1", "hash")
+	assert.False(t, replay)
+	assert.Equal(t, nil, err)
+
+	stored, _ := db.GetIdempotencyRecord(ctx, "user-1", "key-1")
+	assert.Equal(t, 0, stored.Status)
+	assert.WithinDuration(t, time.Now().Add(20*time.Millisecond), stored.ExpiresAt, 20*time.Millisecond)
+}
+
+// TestIdempotencyKeyValidation tests keys must be short, visible ASCII and sent by an authenticated caller
+func TestIdempotencyKeyValidation(t *testing.T) {
+	service, _ := NewService(types.Config{}, database.NewMemoryDatabase(), logging.Discard())
+
+	for _, key := range []string{"", "has space", "ключ", strings.Repeat("a", types.MaxIdempotencyKeyLength+1)} {
+		_, _, err := service.BeginIdempotentRequest(ownerContext(), key, "hash")
+		assert.True(t, errors.Is(err, ErrValidation), "key %q: expected validation error, got %v", key, err)
+	}
+
+	_, _, err := service.BeginIdempotentRequest(context.Background(), "key-1", "hash")
+	assert.True(t, errors.Is(err, ErrUnauthorized), "expected unauthorized, got %v", err)
+}
+
+// TestBeginIdempotentRequestError tests errors from the data module are returned
+func TestBeginIdempotentRequestError(t *testing.T) {
+	output_err := errors.New("database unavailable")
+	db_stub := database.DatabaseStub{CreateIdempotencyError: output_err}
+	service, _ := NewService(types.Config{Server: types.ServerConfig{RequestTimeout: 5 * time.Second}}, &db_stub, logging.Discard())
+
+	_, _, err := service.BeginIdempotentRequest(ownerContext(), "key-1", "hash")
+	assert.Equal(t, output_err, err)
+	assert.Equal(t, "user-1", db_stub.CreateIdempotencyInput.Caller)
+	assert.WithinDuration(t, time.Now().Add(5*time.Second), db_stub.CreateIdempotencyInput.ExpiresAt, time.Second)
+}
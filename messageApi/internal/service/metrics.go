@@ -0,0 +1,62 @@
+package service
+
+import (
+	"errors"
+	"messageApi/internal/types"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// serviceMetrics holds the counters recorded by the service module
+type serviceMetrics struct {
+	messagesCreated    *prometheus.CounterVec
+	validationFailures *prometheus.CounterVec
+}
+
+// newServiceMetrics creates the counters recorded by the service module
+func newServiceMetrics() serviceMetrics {
+	return serviceMetrics{
+		messagesCreated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "messageapi_messages_created_total",
+			Help: "Number of Messages created, split by whether they are palindromes.",
+		}, []string{"palindrome"}),
+		validationFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "messageapi_validation_failures_total",
+			Help: "Number of Messages rejected by validation, split by the field that failed.",
+		}, []string{"field"}),
+	}
+}
+
+// countCreated records a Message was created
+func (m serviceMetrics) countCreated(msg types.Message) {
+	m.messagesCreated.WithLabelValues(strconv.FormatBool(msg.IsPalindrome)).Inc()
+}
+
+// countValidationFailure records a validation failure when the error is one
+func (m serviceMetrics) countValidationFailure(err error) {
+	var validationErr *types.ValidationError
+	if errors.As(err, &validationErr) {
+		m.validationFailures.WithLabelValues(validationErr.Field).Inc()
+	}
+}
+
+// Describe sends the descriptions of the service metrics, and the data module metrics when it has any
+func (s *service) Describe(ch chan<- *prometheus.Desc) {
+	s.metrics.messagesCreated.Describe(ch)
+	s.metrics.validationFailures.Describe(ch)
+
+	if collector, ok := s.Db.(prometheus.Collector); ok {
+		collector.Describe(ch)
+	}
+}
+
+// Collect sends the current service metrics, and the data module metrics when it has any
+func (s *service) Collect(ch chan<- prometheus.Metric) {
+	s.metrics.messagesCreated.Collect(ch)
+	s.metrics.validationFailures.Collect(ch)
+
+	if collector, ok := s.Db.(prometheus.Collector); ok {
+		collector.Collect(ch)
+	}
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"fmt"
+	"messageApi/internal/types"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// isValidPalindromeMode checks the mode is one of the supported palindrome modes
+func isValidPalindromeMode(mode types.PalindromeMode) bool {
+	switch mode {
+	case types.PalindromeExact, types.PalindromeCaseInsensitive, types.PalindromeAlphanumeric, types.PalindromeNFC, types.PalindromeNFKD:
+		return true
+	default:
+		return false
+	}
+}
+
+// validatePalindromeMode returns a validation error when the mode is not supported
+func validatePalindromeMode(mode types.PalindromeMode) error {
+	if !isValidPalindromeMode(mode) {
+		return &types.ValidationError{Field: "palindromemode", Message: fmt.Sprintf("palindrome mode %q is not supported", mode)}
+	}
+
+	return nil
+}
+
+// isPalindrome checks if the message is a palindrome using the mode to decide which differences are ignored
+// The message is compared by grapheme cluster so accented characters and emoji sequences are kept whole
+func isPalindrome(msg string, mode types.PalindromeMode) bool {
+	clusters := graphemeClusters(normalizeForPalindrome(msg, mode))
+
+	if mode == types.PalindromeAlphanumeric {
+		clusters = alphanumericClusters(clusters)
+	}
+
+	i := 0
+	j := len(clusters) - 1
+
+	for (i <= j) && clusters[i] == clusters[j] {
+		i++
+		j--
+	}
+
+	return (i > j)
+}
+
+// normalizeForPalindrome transforms the message so differences ignored by the mode compare equal
+func normalizeForPalindrome(msg string, mode types.PalindromeMode) string {
+	switch mode {
+	case types.PalindromeCaseInsensitive, types.PalindromeAlphanumeric:
+		return cases.Fold().String(norm.NFC.String(msg))
+	case types.PalindromeNFC:
+		return norm.NFC.String(msg)
+	case types.PalindromeNFKD:
+		return norm.NFKD.String(msg)
+	default:
+		return msg
+	}
+}
+
+// graphemeClusters splits the message into user perceived characters
+func graphemeClusters(msg string) []string {
+	clusters := []string{}
+
+	graphemes := uniseg.NewGraphemes(msg)
+	for graphemes.Next() {
+		clusters = append(clusters, graphemes.Str())
+	}
+
+	return clusters
+}
+
+// alphanumericClusters keeps only the clusters that start with a letter or number
+func alphanumericClusters(clusters []string) []string {
+	kept := []string{}
+
+	for _, cluster := range clusters {
+		r, _ := utf8.DecodeRuneInString(cluster)
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			kept = append(kept, cluster)
+		}
+	}
+
+	return kept
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"messageApi/internal/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestIsPalindromeGraphemes tests multi-byte characters are compared as whole characters
+func TestIsPalindromeGraphemes(t *testing.T) {
+	assert.Equal(t, true, isPalindrome("été", types.PalindromeExact))
+	assert.Equal(t, true, isPalindrome("👍🏽a👍🏽", types.PalindromeExact))
+	assert.Equal(t, true, isPalindrome("👨‍👩‍👧x👨‍👩‍👧", types.PalindromeExact))
+	assert.Equal(t, false, isPalindrome("👍🏽a👍", types.PalindromeExact))
+	assert.Equal(t, true, isPalindrome("", types.PalindromeExact))
+}
+
+// TestIsPalindromeCaseInsensitive tests letter case is ignored
+func TestIsPalindromeCaseInsensitive(t *testing.T) {
+	assert.Equal(t, true, isPalindrome("Racecar", types.PalindromeCaseInsensitive))
+	assert.Equal(t, true, isPalindrome("ÉtÉ", types.PalindromeCaseInsensitive))
+	assert.Equal(t, false, isPalindrome("race car", types.PalindromeCaseInsensitive))
+}
+
+// TestIsPalindromeAlphanumeric tests case, whitespace and punctuation are ignored
+func TestIsPalindromeAlphanumeric(t *testing.T) {
+	assert.Equal(t, true, isPalindrome("A man, a plan, a canal: Panama", types.PalindromeAlphanumeric))
+	assert.Equal(t, true, isPalindrome("Was it a car or a cat I saw?", types.PalindromeAlphanumeric))
+	assert.Equal(t, true, isPalindrome("Ésope reste ici et se reposé", types.PalindromeAlphanumeric))
+	assert.Equal(t, false, isPalindrome("A man, a plan", types.PalindromeAlphanumeric))
+}
+
+// TestIsPalindromeNormalized tests composed and decomposed characters compare equal once normalized
+func TestIsPalindromeNormalized(t *testing.T) {
+	composed := "é"
+	decomposed := "é"
+	msg := composed + "t" + decomposed
+
+	assert.Equal(t, false, isPalindrome(msg, types.PalindromeExact))
+	assert.Equal(t, true, isPalindrome(msg, types.PalindromeNFC))
+	assert.Equal(t, true, isPalindrome(msg, types.PalindromeNFKD))
+	assert.Equal(t, true, isPalindrome("ﬁxﬁ", types.PalindromeExact))
+	assert.Equal(t, false, isPalindrome("ﬁxif", types.PalindromeNFC))
+	assert.Equal(t, true, isPalindrome("ﬁxif", types.PalindromeNFKD))
+}
+
+// TestValidatePalindromeMode tests only supported modes are accepted
+func TestValidatePalindromeMode(t *testing.T) {
+	assert.Equal(t, nil, validatePalindromeMode(types.PalindromeExact))
+	assert.Equal(t, &types.ValidationError{Field: "palindromemode", Message: `palindrome mode "reverse" is not supported`}, validatePalindromeMode("reverse"))
+}
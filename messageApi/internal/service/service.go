@@ -1,99 +1,413 @@
 package service
 
 import (
-	"errors"
+	"context"
+	"fmt"
+	"log/slog"
 	"messageApi/internal/database"
+	"messageApi/internal/tracing"
 	"messageApi/internal/types"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Service is the interface for the service module of the application
 type Service interface {
-	CreateMessage(types.Message) (types.Message, error)
-	ListMessages() ([]types.Message, error)
-	GetMessage(int) (types.Message, error)
-	UpdateMessage(types.Message) (types.Message, error)
-	DeleteMessage(int) error
+	CreateMessage(context.Context, types.Message) (types.Message, error)
+	ListMessages(context.Context, types.ListOptions) (types.MessagePage, error)
+	GetMessage(context.Context, int) (types.Message, error)
+	UpdateMessage(context.Context, types.Message) (types.Message, error)
+	DeleteMessage(context.Context, int, int) error
+	ExecuteBatch(context.Context, []types.BatchOperation, bool) ([]types.BatchResult, error)
+	IssueAPIKey(context.Context, string, []types.Scope, string) (types.APIKey, error)
+	ListAPIKeys(context.Context) ([]types.APIKey, error)
+	RevokeAPIKey(context.Context, int) (types.APIKey, error)
+	AuthenticateAPIKey(context.Context, string) (types.Identity, error)
+	BeginIdempotentRequest(context.Context, string, string) (types.IdempotencyRecord, bool, error)
+	CompleteIdempotentRequest(context.Context, types.IdempotencyRecord) error
+	AbandonIdempotentRequest(context.Context, string) error
+	Ping(context.Context) error
+	CheckMigrations(context.Context) error
 }
 
+// Errors returned by the service module, wrapped with details of the failure
+// Use errors.Is to check for them as the data module wraps them with the underlying cause
+var (
+	ErrNotFound             = types.ErrNotFound
+	ErrValidation           = types.ErrValidation
+	ErrConflict             = types.ErrConflict
+	ErrUnavailable          = types.ErrUnavailable
+	ErrUnauthorized         = types.ErrUnauthorized
+	ErrForbidden            = types.ErrForbidden
+	ErrQuota                = types.ErrQuota
+	ErrPrecondition         = types.ErrPrecondition
+	ErrPreconditionRequired = types.ErrPreconditionRequired
+	ErrIdempotency          = types.ErrIdempotency
+	ErrAborted              = types.ErrAborted
+)
+
 // service is the implementation of the service module
 type service struct {
-	Db database.Database
+	Db      database.Database
+	config  types.Config
+	metrics serviceMetrics
+	logger  *slog.Logger
 }
 
+// Page size limits used when they are not set in the config
+const (
+	defaultListLimit = 50
+	maxListLimit     = 1000
+)
+
 // NewService creates an instance of the service module
-func NewService(cfg types.Config, db database.Database) (Service, error) {
-	return &service{db}, nil
+func NewService(cfg types.Config, db database.Database, logger *slog.Logger) (Service, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	if cfg.List.DefaultLimit <= 0 {
+		cfg.List.DefaultLimit = defaultListLimit
+	}
+
+	if cfg.List.MaxLimit <= 0 {
+		cfg.List.MaxLimit = maxListLimit
+	}
+
+	if cfg.Palindrome.DefaultMode == "" {
+		cfg.Palindrome.DefaultMode = types.PalindromeExact
+	}
+
+	if cfg.Idempotency.TTL <= 0 {
+		cfg.Idempotency.TTL = defaultIdempotencyTTL
+	}
+
+	if cfg.Batch.MaxOperations <= 0 {
+		cfg.Batch.MaxOperations = defaultBatchMaxOperations
+	}
+
+	if !isValidPalindromeMode(cfg.Palindrome.DefaultMode) {
+		return nil, fmt.Errorf("palindrome mode %q is not supported", cfg.Palindrome.DefaultMode)
+	}
+
+	if err := validateTenantConfig(cfg.Tenant); err != nil {
+		return nil, err
+	}
+
+	rules, err := validationRules(cfg.Validation)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Validation = rules
+
+	return &service{db, cfg, newServiceMetrics(), logger}, nil
 }
 
-// CreateMessage validates the message then sends it to the data module
-func (s *service) CreateMessage(msg types.Message) (types.Message, error) {
-	if err := validateMessage(msg); err != nil {
+// CreateMessage validates the message then sends it to the data module, owned by the caller
+func (s *service) CreateMessage(ctx context.Context, msg types.Message) (_ types.Message, err error) {
+	ctx, span := startSpan(ctx, "CreateMessage")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	identity, err := callerIdentity(ctx)
+	if err != nil {
 		return types.Message{}, err
 	}
 
-	msg.IsPalindrome = isPalindrome(msg.Message)
+	if err := s.checkMessage(ctx, &msg); err != nil {
+		return types.Message{}, err
+	}
 
-	msg, err := s.Db.CreateMessage(msg)
+	msg.Owner = identity.Subject
+	msg, err = s.createWithinQuota(ctx, msg)
 	if err != nil {
 		return types.Message{}, err
 	}
 
+	s.logger.DebugContext(ctx, "created message", "id", msg.Id, "ispalindrome", msg.IsPalindrome, "palindromemode", msg.PalindromeMode)
+
+	span.SetAttributes(messageAttributes(msg)...)
+	s.metrics.countCreated(msg)
+
 	return msg, nil
 }
 
-// isPalindrome checks if the message is a palindrome. Needs to be an exact match, including casing and whitespace
-func isPalindrome(msg string) bool {
-	i := 0
-	j := len(msg) - 1
+// checkMessage validates the Message and sets whether it is a palindrome, counting any validation failure
+func (s *service) checkMessage(ctx context.Context, msg *types.Message) error {
+	msg.Message = prepareMessage(msg.Message, s.config.Validation)
 
-	for (i <= j) && msg[i] == msg[j] {
-		i++
-		j--
+	err := validateMessage(*msg, s.config.Validation)
+	if err == nil {
+		err = s.checkPalindrome(msg)
 	}
 
-	return (i > j)
+	if err != nil {
+		s.metrics.countValidationFailure(err)
+		s.logger.DebugContext(ctx, "rejected message", "error", err)
+		return err
+	}
+
+	return nil
 }
 
-// validateMessage checks that the Message matches the requirements
-func validateMessage(msg types.Message) error {
-	if len(msg.Message) > 100 {
-		return errors.New("message cannot be longer than 100 characters")
+// checkPalindrome sets whether the Message is a palindrome using its mode, or the default mode when not set
+func (s *service) checkPalindrome(msg *types.Message) error {
+	if msg.PalindromeMode == "" {
+		msg.PalindromeMode = s.config.Palindrome.DefaultMode
 	}
 
-	if len(msg.Message) == 0 {
-		return errors.New("message cannot be an empty string")
+	if err := validatePalindromeMode(msg.PalindromeMode); err != nil {
+		return err
 	}
 
+	msg.IsPalindrome = isPalindrome(msg.Message, msg.PalindromeMode)
+
 	return nil
 }
 
 // GetMessage returns a single Message from the data module
-func (s *service) GetMessage(id int) (types.Message, error) {
-	return s.Db.GetMessage(id)
+func (s *service) GetMessage(ctx context.Context, id int) (_ types.Message, err error) {
+	ctx, span := startSpan(ctx, "GetMessage", attribute.Int("message.id", id))
+	defer func() { tracing.EndSpan(span, err) }()
+
+	return s.Db.GetMessage(ctx, id)
 }
 
-// ListMessages returns a list of Messages from the data module
-func (s *service) ListMessages() ([]types.Message, error) {
-	return s.Db.ListMessages()
+// ListMessages returns a single page of Messages from the data module
+// One extra Message is requested so the cursor for the next page is only returned when one exists
+func (s *service) ListMessages(ctx context.Context, opts types.ListOptions) (_ types.MessagePage, err error) {
+	ctx, span := startSpan(ctx, "ListMessages")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	opts, err = s.normalizeListOptions(opts)
+	if err != nil {
+		return types.MessagePage{}, err
+	}
+
+	span.SetAttributes(
+		attribute.Int("list.limit", opts.Limit),
+		attribute.String("list.sort", opts.Sort),
+		attribute.String("list.order", opts.Order),
+	)
+
+	limit := opts.Limit
+	opts.Limit++
+
+	msgs, err := s.Db.ListMessages(ctx, opts)
+	if err != nil {
+		return types.MessagePage{}, err
+	}
+
+	page := types.MessagePage{Messages: msgs}
+
+	if len(msgs) > limit {
+		page.Messages = msgs[:limit]
+		last := page.Messages[limit-1]
+		page.Next = &types.Cursor{Id: last.Id, Sort: opts.Sort, Order: opts.Order}
+
+		if opts.Sort == types.SortByMessage {
+			page.Next.Value = last.Message
+		}
+	}
+
+	span.SetAttributes(attribute.Int("list.count", len(page.Messages)))
+
+	return page, nil
 }
 
-// UpdateMessage updates an existing Message
-func (s *service) UpdateMessage(msg types.Message) (types.Message, error) {
-	if err := validateMessage(msg); err != nil {
+// normalizeListOptions applies the default list options and checks the values are supported
+func (s *service) normalizeListOptions(opts types.ListOptions) (types.ListOptions, error) {
+	if opts.Limit < 0 {
+		return opts, &types.ValidationError{Field: "limit", Message: "limit cannot be negative"}
+	}
+
+	if opts.Limit == 0 {
+		opts.Limit = s.config.List.DefaultLimit
+	}
+
+	if opts.Limit > s.config.List.MaxLimit {
+		opts.Limit = s.config.List.MaxLimit
+	}
+
+	switch opts.Sort {
+	case "":
+		opts.Sort = types.SortById
+	case types.SortById, types.SortByMessage:
+	default:
+		return opts, &types.ValidationError{Field: "sort", Message: fmt.Sprintf("cannot sort by %q", opts.Sort)}
+	}
+
+	switch opts.Order {
+	case "":
+		opts.Order = types.OrderAsc
+	case types.OrderAsc, types.OrderDesc:
+	default:
+		return opts, &types.ValidationError{Field: "order", Message: fmt.Sprintf("cannot order by %q", opts.Order)}
+	}
+
+	if opts.After != nil && (opts.After.Sort != opts.Sort || opts.After.Order != opts.Order) {
+		return opts, &types.ValidationError{Field: "cursor", Message: "cursor was created for a different sort or order"}
+	}
+
+	return opts, nil
+}
+
+// UpdateMessage updates an existing Message, which only its owner or an admin can do
+// When the version of msg is set the Message is only updated while it is still at that version
+func (s *service) UpdateMessage(ctx context.Context, msg types.Message) (_ types.Message, err error) {
+	ctx, span := startSpan(ctx, "UpdateMessage", attribute.Int("message.id", msg.Id))
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if err := s.checkMessage(ctx, &msg); err != nil {
 		return types.Message{}, err
 	}
 
-	msg.IsPalindrome = isPalindrome(msg.Message)
+	if err := s.authorizeOwner(ctx, msg.Id); err != nil {
+		return types.Message{}, err
+	}
 
-	msg, err := s.Db.UpdateMessage(msg)
+	msg, err = s.Db.UpdateMessage(ctx, msg)
 	if err != nil {
 		return types.Message{}, err
 	}
 
+	s.logger.DebugContext(ctx, "updated message", "id", msg.Id, "ispalindrome", msg.IsPalindrome, "palindromemode", msg.PalindromeMode)
+
+	span.SetAttributes(messageAttributes(msg)...)
+
 	return msg, nil
 }
 
-// DeleteMessage deletes an existing message
-func (s *service) DeleteMessage(id int) error {
-	return s.Db.DeleteMessage(id)
+// DeleteMessage deletes an existing message, which only its owner or an admin can do
+// When the version is not 0 the Message is only deleted while it is still at that version
+func (s *service) DeleteMessage(ctx context.Context, id int, version int) (err error) {
+	ctx, span := startSpan(ctx, "DeleteMessage", attribute.Int("message.id", id))
+	defer func() { tracing.EndSpan(span, err) }()
+
+	if err := s.authorizeOwner(ctx, id); err != nil {
+		return err
+	}
+
+	if err := s.Db.DeleteMessage(ctx, id, version); err != nil {
+		return err
+	}
+
+	s.logger.DebugContext(ctx, "deleted message", "id", id)
+
+	return nil
+}
+
+// createWithinQuota stores the Message, checking the quota of the tenant in the context in the same transaction when it
+// has one, so concurrent creates cannot take the tenant over its quota
+func (s *service) createWithinQuota(ctx context.Context, msg types.Message) (types.Message, error) {
+	tenant := types.TenantFromContext(ctx)
+
+	quota := s.tenantQuota(tenant)
+	if quota <= 0 {
+		return s.Db.CreateMessage(ctx, msg)
+	}
+
+	// the transaction can be run more than once, so the created Message is only kept from the run that committed
+	var created types.Message
+	err := s.Db.WithTx(ctx, func(tx database.Database) (err error) {
+		if err := s.checkQuota(ctx, tx, tenant, quota); err != nil {
+			return err
+		}
+
+		created, err = tx.CreateMessage(ctx, msg)
+		return err
+	})
+
+	return created, err
+}
+
+// checkQuota returns a quota error when the tenant already stores as many Messages as its quota allows
+func (s *service) checkQuota(ctx context.Context, db database.Database, tenant string, quota int) error {
+	count, err := db.CountMessages(ctx)
+	if err != nil {
+		return err
+	}
+
+	if count >= quota {
+		s.logger.InfoContext(ctx, "rejected message over tenant quota", "tenant", tenant, "quota", quota)
+		return quotaError(tenant, quota)
+	}
+
+	return nil
+}
+
+// tenantQuota returns the most Messages the tenant can store, where 0 means there is no limit
+func (s *service) tenantQuota(tenant string) int {
+	quota, ok := s.config.Tenant.Quotas[tenant]
+	if !ok {
+		quota = s.config.Tenant.MaxMessages
+	}
+
+	return quota
+}
+
+// quotaError returns the error used when the tenant already stores as many Messages as its quota allows
+func quotaError(tenant string, quota int) error {
+	return fmt.Errorf("%w: tenant %q can store at most %d messages", types.ErrQuota, tenant, quota)
+}
+
+// validateTenantConfig checks the tenant quotas are not negative
+func validateTenantConfig(cfg types.TenantConfig) error {
+	if cfg.MaxMessages < 0 {
+		return fmt.Errorf("tenant max messages cannot be negative, got %d", cfg.MaxMessages)
+	}
+
+	for tenant, quota := range cfg.Quotas {
+		if quota < 0 {
+			return fmt.Errorf("quota for tenant %q cannot be negative", tenant)
+		}
+	}
+
+	return nil
+}
+
+// authorizeOwner checks the caller owns the Message with the id or is an admin
+// A not found error is returned before a forbidden error so callers cannot change Messages that do not exist
+func (s *service) authorizeOwner(ctx context.Context, id int) error {
+	identity, err := callerIdentity(ctx)
+	if err != nil {
+		return err
+	}
+
+	msg, err := s.Db.GetMessage(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return s.checkOwner(ctx, identity, msg)
+}
+
+// checkOwner checks the caller owns the stored Message or is an admin
+func (s *service) checkOwner(ctx context.Context, identity types.Identity, msg types.Message) error {
+	if msg.Owner != identity.Subject && !identity.HasScope(types.ScopeAdmin) {
+		s.logger.DebugContext(ctx, "rejected change to message owned by another caller", "id", msg.Id, "owner", msg.Owner, "subject", identity.Subject)
+		return fmt.Errorf("%w: message %d is owned by another caller", types.ErrForbidden, msg.Id)
+	}
+
+	return nil
+}
+
+// callerIdentity returns the Identity of the caller from the context
+// Messages cannot be changed without an Identity as they would have no owner to check against
+func callerIdentity(ctx context.Context) (types.Identity, error) {
+	identity, ok := types.IdentityFromContext(ctx)
+	if !ok || identity.Subject == "" {
+		return types.Identity{}, fmt.Errorf("%w: no caller identity", types.ErrUnauthorized)
+	}
+
+	return identity, nil
+}
+
+// Ping checks the data module can reach its storage
+func (s *service) Ping(ctx context.Context) error {
+	return s.Db.Ping(ctx)
+}
+
+// CheckMigrations checks the storage used by the data module has an up to date schema
+func (s *service) CheckMigrations(ctx context.Context) error {
+	return s.Db.CheckMigrations(ctx)
 }
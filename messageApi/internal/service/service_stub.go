@@ -1,41 +1,127 @@
 package service
 
-import "messageApi/internal/types"
+import (
+	"context"
+	"messageApi/internal/types"
+)
 
 // ServiceStub provides a stub for use in testing
 type ServiceStub struct {
-	GetMessageResponse    types.Message
-	GetMessageError       error
-	ListMessagesResponse  []types.Message
-	ListMessagesError     error
-	CreateMessageResponse types.Message
-	CreateMessageError    error
-	UpdateMessageResponse types.Message
-	UpdateMessageError    error
-	DeleteMessageError    error
+	GetMessageResponse      types.Message
+	GetMessageError         error
+	ListMessagesInput       types.ListOptions
+	ListMessagesResponse    types.MessagePage
+	ListMessagesError       error
+	CreateMessageResponse   types.Message
+	CreateMessageError      error
+	UpdateMessageInput      types.Message
+	UpdateMessageResponse   types.Message
+	UpdateMessageError      error
+	DeleteMessageVersion    int
+	DeleteMessageError      error
+	ExecuteBatchInput       []types.BatchOperation
+	ExecuteBatchTransaction bool
+	ExecuteBatchResponse    []types.BatchResult
+	ExecuteBatchError       error
+	IssueAPIKeyResponse     types.APIKey
+	IssueAPIKeyError        error
+	ListAPIKeysResponse     []types.APIKey
+	ListAPIKeysError        error
+	RevokeAPIKeyResponse    types.APIKey
+	RevokeAPIKeyError       error
+	AuthenticateResponse    types.Identity
+	AuthenticateError       error
+	BeginIdempotentInput    string
+	BeginIdempotentResponse types.IdempotencyRecord
+	BeginIdempotentReplay   bool
+	BeginIdempotentError    error
+	CompleteIdempotentInput types.IdempotencyRecord
+	CompleteIdempotentError error
+	AbandonIdempotentInput  string
+	AbandonIdempotentError  error
+	PingError               error
+	CheckMigrationsError    error
 }
 
 // CreateMessage returns static vars for use in testing
-func (d *ServiceStub) CreateMessage(msg types.Message) (types.Message, error) {
+func (d *ServiceStub) CreateMessage(ctx context.Context, msg types.Message) (types.Message, error) {
 	return d.CreateMessageResponse, d.CreateMessageError
 }
 
 // GetMessage returns static vars for use in testing
-func (d *ServiceStub) GetMessage(id int) (types.Message, error) {
+func (d *ServiceStub) GetMessage(ctx context.Context, id int) (types.Message, error) {
 	return d.GetMessageResponse, d.GetMessageError
 }
 
-// ListMessages returns static vars for use in testing
-func (d *ServiceStub) ListMessages() ([]types.Message, error) {
+// ListMessages records the input and returns static vars for use in testing
+func (d *ServiceStub) ListMessages(ctx context.Context, opts types.ListOptions) (types.MessagePage, error) {
+	d.ListMessagesInput = opts
 	return d.ListMessagesResponse, d.ListMessagesError
 }
 
-// UpdateMessage returns static vars for use in testing
-func (d *ServiceStub) UpdateMessage(msg types.Message) (types.Message, error) {
+// UpdateMessage records the input and returns static vars for use in testing
+func (d *ServiceStub) UpdateMessage(ctx context.Context, msg types.Message) (types.Message, error) {
+	d.UpdateMessageInput = msg
 	return d.UpdateMessageResponse, d.UpdateMessageError
 }
 
-// DeleteMessage returns static vars for use in testing
-func (d *ServiceStub) DeleteMessage(id int) error {
+// DeleteMessage records the version and returns static vars for use in testing
+func (d *ServiceStub) DeleteMessage(ctx context.Context, id int, version int) error {
+	d.DeleteMessageVersion = version
 	return d.DeleteMessageError
 }
+
+// ExecuteBatch records the input and returns static vars for use in testing
+func (d *ServiceStub) ExecuteBatch(ctx context.Context, ops []types.BatchOperation, transactional bool) ([]types.BatchResult, error) {
+	d.ExecuteBatchInput = ops
+	d.ExecuteBatchTransaction = transactional
+	return d.ExecuteBatchResponse, d.ExecuteBatchError
+}
+
+// IssueAPIKey returns static vars for use in testing
+func (d *ServiceStub) IssueAPIKey(ctx context.Context, name string, scopes []types.Scope, tenant string) (types.APIKey, error) {
+	return d.IssueAPIKeyResponse, d.IssueAPIKeyError
+}
+
+// ListAPIKeys returns static vars for use in testing
+func (d *ServiceStub) ListAPIKeys(ctx context.Context) ([]types.APIKey, error) {
+	return d.ListAPIKeysResponse, d.ListAPIKeysError
+}
+
+// RevokeAPIKey returns static vars for use in testing
+func (d *ServiceStub) RevokeAPIKey(ctx context.Context, id int) (types.APIKey, error) {
+	return d.RevokeAPIKeyResponse, d.RevokeAPIKeyError
+}
+
+// AuthenticateAPIKey returns static vars for use in testing
+func (d *ServiceStub) AuthenticateAPIKey(ctx context.Context, key string) (types.Identity, error) {
+	return d.AuthenticateResponse, d.AuthenticateError
+}
+
+// BeginIdempotentRequest records the key and returns static vars for use in testing
+func (d *ServiceStub) BeginIdempotentRequest(ctx context.Context, key string, requestHash string) (types.IdempotencyRecord, bool, error) {
+	d.BeginIdempotentInput = key
+	return d.BeginIdempotentResponse, d.BeginIdempotentReplay, d.BeginIdempotentError
+}
+
+// CompleteIdempotentRequest records the input and returns static vars for use in testing
+func (d *ServiceStub) CompleteIdempotentRequest(ctx context.Context, record types.IdempotencyRecord) error {
+	d.CompleteIdempotentInput = record
+	return d.CompleteIdempotentError
+}
+
+// AbandonIdempotentRequest records the key and returns static vars for use in testing
+func (d *ServiceStub) AbandonIdempotentRequest(ctx context.Context, key string) error {
+	d.AbandonIdempotentInput = key
+	return d.AbandonIdempotentError
+}
+
+// Ping returns static vars for use in testing
+func (d *ServiceStub) Ping(ctx context.Context) error {
+	return d.PingError
+}
+
+// CheckMigrations returns static vars for use in testing
+func (d *ServiceStub) CheckMigrations(ctx context.Context) error {
+	return d.CheckMigrationsError
+}
@@ -1,14 +1,28 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"messageApi/internal/database"
+	"messageApi/internal/logging"
 	"messageApi/internal/types"
+	"sync"
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
+// ownerContext returns a context holding the Identity of a caller that can read and write Messages
+func ownerContext() context.Context {
+	return types.WithIdentity(context.Background(), types.Identity{Subject: "user-1", Scopes: []types.Scope{types.ScopeRead, types.ScopeWrite}})
+}
+
 // TestCreateMessagePalindrome tests creating a Message that is a palindrome
 func TestCreateMessagePalindrome(t *testing.T) {
 	input_msg := types.Message{Id: 0, Message: "racecar", IsPalindrome: false}
@@ -17,9 +31,9 @@ func TestCreateMessagePalindrome(t *testing.T) {
 		CreateMessageResponse: output_msg,
 		CreateMessageError:    nil,
 	}
-	service, _ := NewService(types.Config{}, &db_stub)
+	service, _ := NewService(types.Config{}, &db_stub, logging.Discard())
 
-	msg, err := service.CreateMessage(input_msg)
+	msg, err := service.CreateMessage(ownerContext(), input_msg)
 
 	assert.Equal(t, output_msg, msg)
 	assert.Equal(t, nil, err)
@@ -33,9 +47,9 @@ func TestCreateMessageNotPalindrome(t *testing.T) {
 		CreateMessageResponse: output_msg,
 		CreateMessageError:    nil,
 	}
-	service, _ := NewService(types.Config{}, &db_stub)
+	service, _ := NewService(types.Config{}, &db_stub, logging.Discard())
 
-	msg, err := service.CreateMessage(input_msg)
+	msg, err := service.CreateMessage(ownerContext(), input_msg)
 
 	assert.Equal(t, output_msg, msg)
 	assert.Equal(t, nil, err)
@@ -50,9 +64,9 @@ func TestCreateMessageError(t *testing.T) {
 		CreateMessageResponse: types.Message{},
 		CreateMessageError:    output_err,
 	}
-	service, _ := NewService(types.Config{}, &db_stub)
+	service, _ := NewService(types.Config{}, &db_stub, logging.Discard())
 
-	msg, err := service.CreateMessage(input_msg)
+	msg, err := service.CreateMessage(ownerContext(), input_msg)
 
 	assert.Equal(t, types.Message{}, msg)
 	assert.Equal(t, output_err, err)
@@ -60,10 +74,10 @@ func TestCreateMessageError(t *testing.T) {
 
 // TestIsPalindrome tests whether various strings are palindromes
 func TestIsPalindrome(t *testing.T) {
-	assert.Equal(t, false, isPalindrome("test"))
-	assert.Equal(t, true, isPalindrome("racecar"))
-	assert.Equal(t, false, isPalindrome("race car"))
-	assert.Equal(t, false, isPalindrome("Racecar"))
+	assert.Equal(t, false, isPalindrome("test", types.PalindromeExact))
+	assert.Equal(t, true, isPalindrome("racecar", types.PalindromeExact))
+	assert.Equal(t, false, isPalindrome("race car", types.PalindromeExact))
+	assert.Equal(t, false, isPalindrome("Racecar", types.PalindromeExact))
 }
 
 // TestUpdateMessagePalindrome tests updating a Message that is a palindrome
@@ -71,12 +85,13 @@ func TestUpdateMessagePalindrome(t *testing.T) {
 	input_msg := types.Message{Id: 1, Message: "racecar", IsPalindrome: false}
 	output_msg := types.Message{Id: 1, Message: "racecar", IsPalindrome: true}
 	db_stub := database.DatabaseStub{
+		GetMessageResponse:    types.Message{Id: 1, Owner: "user-1"},
 		UpdateMessageResponse: output_msg,
 		UpdateMessageError:    nil,
 	}
-	service, _ := NewService(types.Config{}, &db_stub)
+	service, _ := NewService(types.Config{}, &db_stub, logging.Discard())
 
-	msg, err := service.UpdateMessage(input_msg)
+	msg, err := service.UpdateMessage(ownerContext(), input_msg)
 
 	assert.Equal(t, output_msg, msg)
 	assert.Equal(t, nil, err)
@@ -87,12 +102,13 @@ func TestUpdateMessageNotPalindrome(t *testing.T) {
 	input_msg := types.Message{Id: 1, Message: "Test", IsPalindrome: false}
 	output_msg := types.Message{Id: 1, Message: "Test", IsPalindrome: false}
 	db_stub := database.DatabaseStub{
+		GetMessageResponse:    types.Message{Id: 1, Owner: "user-1"},
 		UpdateMessageResponse: output_msg,
 		UpdateMessageError:    nil,
 	}
-	service, _ := NewService(types.Config{}, &db_stub)
+	service, _ := NewService(types.Config{}, &db_stub, logging.Discard())
 
-	msg, err := service.UpdateMessage(input_msg)
+	msg, err := service.UpdateMessage(ownerContext(), input_msg)
 
 	assert.Equal(t, output_msg, msg)
 	assert.Equal(t, nil, err)
@@ -104,12 +120,13 @@ func TestUpdateMessageError(t *testing.T) {
 	output_err := errors.New("error updating message")
 
 	db_stub := database.DatabaseStub{
+		GetMessageResponse:    types.Message{Id: 1, Owner: "user-1"},
 		UpdateMessageResponse: types.Message{},
 		UpdateMessageError:    output_err,
 	}
-	service, _ := NewService(types.Config{}, &db_stub)
+	service, _ := NewService(types.Config{}, &db_stub, logging.Discard())
 
-	msg, err := service.UpdateMessage(input_msg)
+	msg, err := service.UpdateMessage(ownerContext(), input_msg)
 
 	assert.Equal(t, types.Message{}, msg)
 	assert.Equal(t, output_err, err)
@@ -124,9 +141,9 @@ func TestGetMessage(t *testing.T) {
 		GetMessageResponse: output_msg,
 		GetMessageError:    output_err,
 	}
-	service, _ := NewService(types.Config{}, &db_stub)
+	service, _ := NewService(types.Config{}, &db_stub, logging.Discard())
 
-	msg, err := service.GetMessage(output_msg.Id)
+	msg, err := service.GetMessage(context.Background(), output_msg.Id)
 
 	assert.Equal(t, output_msg, msg)
 	assert.Equal(t, output_err, err)
@@ -141,9 +158,9 @@ func TestListMessage(t *testing.T) {
 		GetMessageResponse: output_msg,
 		GetMessageError:    output_err,
 	}
-	service, _ := NewService(types.Config{}, &db_stub)
+	service, _ := NewService(types.Config{}, &db_stub, logging.Discard())
 
-	msg, err := service.GetMessage(output_msg.Id)
+	msg, err := service.GetMessage(context.Background(), output_msg.Id)
 
 	assert.Equal(t, output_msg, msg)
 	assert.Equal(t, output_err, err)
@@ -154,31 +171,361 @@ func TestDeleteMessage(t *testing.T) {
 	id := 1
 	output_err := errors.New("error updating message")
 
-	db_stub := database.DatabaseStub{DeleteMessageError: output_err}
-	service, _ := NewService(types.Config{}, &db_stub)
+	db_stub := database.DatabaseStub{GetMessageResponse: types.Message{Id: 1, Owner: "user-1"}, DeleteMessageError: output_err}
+	service, _ := NewService(types.Config{}, &db_stub, logging.Discard())
 
-	err := service.DeleteMessage(id)
+	err := service.DeleteMessage(ownerContext(), id, 0)
 
 	assert.Equal(t, output_err, err)
 }
 
 // TestValidateMessageLength tests validation fails if Message is longer than 100 characters
 func TestValidateMessageLength(t *testing.T) {
-	output_err := errors.New("message cannot be longer than 100 characters")
+	output_err := &types.ValidationError{Field: "message", Message: "message cannot be longer than 100 characters"}
 	msg_text := "12345678901234567890123456789012345678901234567890123456789012345678901234567890123456789012345678901"
 	msg := types.Message{Id: 0, Message: msg_text, IsPalindrome: true}
 
-	err := validateMessage(msg)
+	err := validateMessage(msg, defaultRules)
 
 	assert.Equal(t, output_err, err)
 }
 
 // TestValidateMessageEmptyMessage tests validation fails if Message is longer than 100 characters
 func TestValidateMessageEmptyMessage(t *testing.T) {
-	output_err := errors.New("message cannot be an empty string")
+	output_err := &types.ValidationError{Field: "message", Message: "message cannot be an empty string"}
 	msg := types.Message{Id: 0, Message: "", IsPalindrome: true}
 
-	err := validateMessage(msg)
+	err := validateMessage(msg, defaultRules)
+
+	assert.Equal(t, output_err, err)
+}
+
+// TestListMessagesNextPage tests a cursor is returned when the data module returns more Messages than the limit
+func TestListMessagesNextPage(t *testing.T) {
+	db_msgs := []types.Message{{Id: 1, Message: "a"}, {Id: 2, Message: "b"}, {Id: 3, Message: "c"}}
+	db_stub := database.DatabaseStub{ListMessagesResponse: db_msgs}
+	service, _ := NewService(types.Config{}, &db_stub, logging.Discard())
+
+	page, err := service.ListMessages(context.Background(), types.ListOptions{Limit: 2, Sort: types.SortByMessage})
+
+	assert.Equal(t, db_msgs[:2], page.Messages)
+	assert.Equal(t, &types.Cursor{Id: 2, Value: "b", Sort: types.SortByMessage, Order: types.OrderAsc}, page.Next)
+	assert.Equal(t, nil, err)
+}
+
+// TestListMessagesCursorMismatch tests a cursor is rejected when used with a different sort or order than it was created for
+func TestListMessagesCursorMismatch(t *testing.T) {
+	db_stub := database.DatabaseStub{}
+	service, _ := NewService(types.Config{}, &db_stub, logging.Discard())
+	after := &types.Cursor{Id: 2, Value: "b", Sort: types.SortByMessage, Order: types.OrderAsc}
+
+	_, err := service.ListMessages(context.Background(), types.ListOptions{After: after, Sort: types.SortByMessage})
+	assert.Equal(t, nil, err)
+
+	for _, opts := range []types.ListOptions{{After: after}, {After: after, Sort: types.SortByMessage, Order: types.OrderDesc}, {After: &types.Cursor{Id: 2}}} {
+		_, err := service.ListMessages(context.Background(), opts)
+		assert.True(t, errors.Is(err, ErrValidation), "expected validation error, got %v", err)
+	}
+}
+
+// TestListMessagesLastPage tests no cursor is returned when there are no more Messages
+func TestListMessagesLastPage(t *testing.T) {
+	db_msgs := []types.Message{{Id: 1, Message: "a"}, {Id: 2, Message: "b"}}
+	db_stub := database.DatabaseStub{ListMessagesResponse: db_msgs}
+	service, _ := NewService(types.Config{}, &db_stub, logging.Discard())
+
+	page, err := service.ListMessages(context.Background(), types.ListOptions{Limit: 2})
+
+	assert.Equal(t, db_msgs, page.Messages)
+	assert.Nil(t, page.Next)
+	assert.Equal(t, nil, err)
+}
+
+// TestListMessagesError tests an error is returned when the call to the data module fails
+func TestListMessagesError(t *testing.T) {
+	output_err := errors.New("error listing messages")
+	db_stub := database.DatabaseStub{ListMessagesError: output_err}
+	service, _ := NewService(types.Config{}, &db_stub, logging.Discard())
+
+	page, err := service.ListMessages(context.Background(), types.ListOptions{})
+
+	assert.Equal(t, types.MessagePage{}, page)
+	assert.Equal(t, output_err, err)
+}
+
+// TestNormalizeListOptions tests the default list options are applied and limited by the config
+func TestNormalizeListOptions(t *testing.T) {
+	cfg := types.Config{List: types.ListConfig{DefaultLimit: 10, MaxLimit: 20}}
+	svc, _ := NewService(cfg, &database.DatabaseStub{}, logging.Discard())
+
+	opts, err := svc.(*service).normalizeListOptions(types.ListOptions{})
+	assert.Equal(t, types.ListOptions{Limit: 10, Sort: types.SortById, Order: types.OrderAsc}, opts)
+	assert.Equal(t, nil, err)
+
+	opts, err = svc.(*service).normalizeListOptions(types.ListOptions{Limit: 500, Order: types.OrderDesc})
+	assert.Equal(t, types.ListOptions{Limit: 20, Sort: types.SortById, Order: types.OrderDesc}, opts)
+	assert.Equal(t, nil, err)
+
+	_, err = svc.(*service).normalizeListOptions(types.ListOptions{Sort: "ispalindrome"})
+	assert.Equal(t, &types.ValidationError{Field: "sort", Message: `cannot sort by "ispalindrome"`}, err)
+
+	_, err = svc.(*service).normalizeListOptions(types.ListOptions{Order: "up"})
+	assert.Equal(t, &types.ValidationError{Field: "order", Message: `cannot order by "up"`}, err)
+}
+
+// TestValidateMessageIsValidationError tests validation failures can be identified as validation errors
+func TestValidateMessageIsValidationError(t *testing.T) {
+	err := validateMessage(types.Message{Message: ""}, defaultRules)
+
+	assert.True(t, errors.Is(err, ErrValidation))
+}
+
+// TestCreateMessagePalindromeMode tests the mode from the Message is used and passed to the data module
+func TestCreateMessagePalindromeMode(t *testing.T) {
+	input_msg := types.Message{Message: "Racecar", PalindromeMode: types.PalindromeCaseInsensitive}
+	db_stub := database.DatabaseStub{}
+	service, _ := NewService(types.Config{}, &db_stub, logging.Discard())
+
+	service.CreateMessage(ownerContext(), input_msg)
+
+	assert.Equal(t, types.Message{Message: "Racecar", IsPalindrome: true, PalindromeMode: types.PalindromeCaseInsensitive, Owner: "user-1"}, db_stub.CreateMessageInput)
+}
+
+// TestCreateMessageDefaultPalindromeMode tests the mode from the config is used when the Message has no mode
+func TestCreateMessageDefaultPalindromeMode(t *testing.T) {
+	input_msg := types.Message{Message: "A man, a plan, a canal: Panama"}
+	db_stub := database.DatabaseStub{}
+	cfg := types.Config{Palindrome: types.PalindromeConfig{DefaultMode: types.PalindromeAlphanumeric}}
+	service, _ := NewService(cfg, &db_stub, logging.Discard())
+
+	service.CreateMessage(ownerContext(), input_msg)
+
+	assert.Equal(t, types.Message{Message: input_msg.Message, IsPalindrome: true, PalindromeMode: types.PalindromeAlphanumeric, Owner: "user-1"}, db_stub.CreateMessageInput)
+}
+
+// TestCreateMessageInvalidPalindromeMode tests a validation error is returned for an unsupported mode
+func TestCreateMessageInvalidPalindromeMode(t *testing.T) {
+	input_msg := types.Message{Message: "racecar", PalindromeMode: "backwards"}
+	service, _ := NewService(types.Config{}, &database.DatabaseStub{}, logging.Discard())
+
+	_, err := service.CreateMessage(ownerContext(), input_msg)
+
+	assert.Equal(t, &types.ValidationError{Field: "palindromemode", Message: `palindrome mode "backwards" is not supported`}, err)
+}
+
+// TestNewServiceInvalidPalindromeMode tests an error is returned when the default mode is not supported
+func TestNewServiceInvalidPalindromeMode(t *testing.T) {
+	cfg := types.Config{Palindrome: types.PalindromeConfig{DefaultMode: "backwards"}}
+
+	_, err := NewService(cfg, &database.DatabaseStub{}, logging.Discard())
+
+	assert.Equal(t, errors.New(`palindrome mode "backwards" is not supported`), err)
+}
+
+// TestMessageLifecycle tests creating, reading, listing, updating and deleting Messages against a real data module
+func TestMessageLifecycle(t *testing.T) {
+	ctx := ownerContext()
+	service, _ := NewService(types.Config{}, database.NewMemoryDatabase(), logging.Discard())
+
+	created, err := service.CreateMessage(ctx, types.Message{Message: "test"})
+	assert.Equal(t, types.Message{Id: 1, Message: "test", IsPalindrome: false, PalindromeMode: types.PalindromeExact, Owner: "user-1", Version: 1}, created)
+	assert.Equal(t, nil, err)
+
+	service.CreateMessage(ctx, types.Message{Message: "racecar"})
+
+	updated, err := service.UpdateMessage(ctx, types.Message{Id: created.Id, Message: "level"})
+	assert.Equal(t, types.Message{Id: 1, Message: "level", IsPalindrome: true, PalindromeMode: types.PalindromeExact, Owner: "user-1", Version: 2}, updated)
+	assert.Equal(t, nil, err)
+
+	msg, err := service.GetMessage(ctx, created.Id)
+	assert.Equal(t, updated, msg)
+	assert.Equal(t, nil, err)
+
+	page, _ := service.ListMessages(ctx, types.ListOptions{Limit: 1})
+	assert.Equal(t, []types.Message{updated}, page.Messages)
+	assert.Equal(t, &types.Cursor{Id: 1, Sort: types.SortById, Order: types.OrderAsc}, page.Next)
+
+	page, _ = service.ListMessages(ctx, types.ListOptions{Limit: 1, After: page.Next})
+	assert.Equal(t, 2, page.Messages[0].Id)
+	assert.Nil(t, page.Next)
+
+	assert.Equal(t, nil, service.DeleteMessage(ctx, created.Id, 0))
+
+	_, err = service.GetMessage(ctx, created.Id)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	err = service.DeleteMessage(ctx, created.Id, 0)
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+// TestUpdateMessageNotFound tests a not found error is returned when updating a Message that does not exist
+func TestUpdateMessageNotFound(t *testing.T) {
+	service, _ := NewService(types.Config{}, database.NewMemoryDatabase(), logging.Discard())
+
+	_, err := service.UpdateMessage(ownerContext(), types.Message{Id: 5, Message: "racecar"})
+
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+// TestMessageOwnership tests only the owner of a Message or an admin can update or delete it
+func TestMessageOwnership(t *testing.T) {
+	service, _ := NewService(types.Config{}, database.NewMemoryDatabase(), logging.Discard())
+	other := types.WithIdentity(context.Background(), types.Identity{Subject: "user-2", Scopes: []types.Scope{types.ScopeWrite}})
+	admin := types.WithIdentity(context.Background(), types.Identity{Subject: "apikey:bootstrap", Scopes: []types.Scope{types.ScopeAdmin}})
+
+	created, _ := service.CreateMessage(ownerContext(), types.Message{Message: "test", Owner: "user-2"})
+	assert.Equal(t, "user-1", created.Owner)
+
+	_, err := service.UpdateMessage(other, types.Message{Id: created.Id, Message: "level"})
+	assert.True(t, errors.Is(err, ErrForbidden))
+
+	err = service.DeleteMessage(other, created.Id, 0)
+	assert.True(t, errors.Is(err, ErrForbidden))
+
+	updated, err := service.UpdateMessage(admin, types.Message{Id: created.Id, Message: "level"})
+	assert.Equal(t, "user-1", updated.Owner)
+	assert.Equal(t, nil, err)
+
+	_, err = service.UpdateMessage(other, types.Message{Id: 99, Message: "level"})
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	assert.Equal(t, nil, service.DeleteMessage(admin, created.Id, 0))
+}
+
+// TestMessageWithoutIdentity tests Messages cannot be changed without a caller Identity
+func TestMessageWithoutIdentity(t *testing.T) {
+	db_stub := database.DatabaseStub{GetMessageResponse: types.Message{Id: 1, Owner: "user-1"}}
+	service, _ := NewService(types.Config{}, &db_stub, logging.Discard())
+
+	_, err := service.CreateMessage(context.Background(), types.Message{Message: "racecar"})
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+
+	_, err = service.UpdateMessage(context.Background(), types.Message{Id: 1, Message: "racecar"})
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+
+	err = service.DeleteMessage(context.Background(), 1, 0)
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+}
+
+// TestTenantQuota tests Messages are rejected once the tenant stores as many as its quota allows
+func TestTenantQuota(t *testing.T) {
+	cfg := types.Config{Tenant: types.TenantConfig{MaxMessages: 2, Quotas: map[string]int{"team-b": 1, "team-c": 0}}}
+	service, _ := NewService(cfg, database.NewMemoryDatabase(), logging.Discard())
+	teamB := types.WithTenant(ownerContext(), "team-b")
+	teamC := types.WithTenant(ownerContext(), "team-c")
+
+	for i := 0; i < 2; i++ {
+		_, err := service.CreateMessage(ownerContext(), types.Message{Message: "racecar"})
+		assert.Equal(t, nil, err)
+	}
+
+	_, err := service.CreateMessage(ownerContext(), types.Message{Message: "racecar"})
+	assert.True(t, errors.Is(err, ErrQuota))
+	assert.EqualError(t, err, `quota exceeded: tenant "default" can store at most 2 messages`)
+
+	created, err := service.CreateMessage(teamB, types.Message{Message: "racecar"})
+	assert.Equal(t, nil, err)
+
+	_, err = service.CreateMessage(teamB, types.Message{Message: "racecar"})
+	assert.True(t, errors.Is(err, ErrQuota))
+
+	assert.Equal(t, nil, service.DeleteMessage(teamB, created.Id, 0))
+	_, err = service.CreateMessage(teamB, types.Message{Message: "racecar"})
+	assert.Equal(t, nil, err)
+
+	for i := 0; i < 3; i++ {
+		_, err := service.CreateMessage(teamC, types.Message{Message: "racecar"})
+		assert.Equal(t, nil, err)
+	}
+}
+
+// TestTenantQuotaConcurrent tests concurrent creates cannot take the tenant over its quota
+func TestTenantQuotaConcurrent(t *testing.T) {
+	db := database.NewMemoryDatabase()
+	service, _ := NewService(types.Config{Tenant: types.TenantConfig{MaxMessages: 5}}, db, logging.Discard())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			service.CreateMessage(ownerContext(), types.Message{Message: "racecar"})
+		}()
+	}
+	wg.Wait()
+
+	count, _ := db.CountMessages(context.Background())
+	assert.Equal(t, 5, count)
+}
+
+// TestCreateMessageQuotaTx tests the quota is checked in the same transaction as the create, and only when there is one
+func TestCreateMessageQuotaTx(t *testing.T) {
+	output_msg := types.Message{Id: 1, Message: "racecar", IsPalindrome: true, PalindromeMode: types.PalindromeExact, Owner: "user-1", Version: 1}
+	db_stub := database.DatabaseStub{CreateMessageResponse: output_msg, CountMessagesResponse: 1}
+	service, _ := NewService(types.Config{Tenant: types.TenantConfig{MaxMessages: 2}}, &db_stub, logging.Discard())
+
+	msg, err := service.CreateMessage(ownerContext(), types.Message{Message: "racecar"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, output_msg, msg)
+	assert.Equal(t, 1, db_stub.WithTxCalls)
 
+	output_err := errors.New("serialization failure")
+	db_stub.WithTxError = output_err
+	_, err = service.CreateMessage(ownerContext(), types.Message{Message: "racecar"})
 	assert.Equal(t, output_err, err)
+
+	db_stub = database.DatabaseStub{CreateMessageResponse: output_msg}
+	service, _ = NewService(types.Config{}, &db_stub, logging.Discard())
+	_, err = service.CreateMessage(ownerContext(), types.Message{Message: "racecar"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, db_stub.WithTxCalls)
+}
+
+// TestNewServiceNegativeQuota tests an error is returned when a tenant quota is negative
+func TestNewServiceNegativeQuota(t *testing.T) {
+	_, err := NewService(types.Config{Tenant: types.TenantConfig{Quotas: map[string]int{"team-a": -1}}}, &database.DatabaseStub{}, logging.Discard())
+	assert.EqualError(t, err, `quota for tenant "team-a" cannot be negative`)
+
+	_, err = NewService(types.Config{Tenant: types.TenantConfig{MaxMessages: -5}}, &database.DatabaseStub{}, logging.Discard())
+	assert.EqualError(t, err, "tenant max messages cannot be negative, got -5")
+}
+
+// TestServiceMetrics tests created palindromes and validation failures are counted
+func TestServiceMetrics(t *testing.T) {
+	db_stub := database.DatabaseStub{CreateMessageResponse: types.Message{Id: 1, Message: "racecar", IsPalindrome: true}}
+	svc, _ := NewService(types.Config{}, &db_stub, logging.Discard())
+	metrics := svc.(*service).metrics
+
+	svc.CreateMessage(ownerContext(), types.Message{Message: "racecar"})
+	svc.CreateMessage(ownerContext(), types.Message{Message: ""})
+	svc.CreateMessage(ownerContext(), types.Message{Message: "racecar", PalindromeMode: "backwards"})
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.messagesCreated.WithLabelValues("true")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.messagesCreated.WithLabelValues("false")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.validationFailures.WithLabelValues("message")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.validationFailures.WithLabelValues("palindromemode")))
+}
+
+// TestServiceSpans tests each service method starts a child span and records failures on it
+func TestServiceSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	db_stub := database.DatabaseStub{CreateMessageResponse: types.Message{Id: 4, Message: "racecar", IsPalindrome: true}}
+	svc, _ := NewService(types.Config{}, &db_stub, logging.Discard())
+
+	ctx, parent := otel.Tracer("test").Start(ownerContext(), "request")
+	svc.CreateMessage(ctx, types.Message{Message: "racecar"})
+	svc.CreateMessage(ctx, types.Message{Message: ""})
+	parent.End()
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 3)
+
+	assert.Equal(t, "service.CreateMessage", spans[0].Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Contains(t, spans[0].Attributes(), attribute.Int("message.id", 4))
+	assert.Equal(t, codes.Unset, spans[0].Status().Code)
+
+	assert.Equal(t, "service.CreateMessage", spans[1].Name())
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
 }
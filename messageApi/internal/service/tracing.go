@@ -0,0 +1,28 @@
+package service
+
+import (
+	"context"
+
+	"messageApi/internal/tracing"
+	"messageApi/internal/types"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName names the tracer creating spans for the service module methods
+const tracerName = "messageApi/internal/service"
+
+// startSpan starts a span for the service method as a child of any span in the context
+func startSpan(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracing.Tracer(tracerName).Start(ctx, "service."+method, trace.WithAttributes(attrs...))
+}
+
+// messageAttributes returns the span attributes describing a stored Message
+func messageAttributes(msg types.Message) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.Int("message.id", msg.Id),
+		attribute.Bool("message.ispalindrome", msg.IsPalindrome),
+		attribute.String("message.palindromemode", string(msg.PalindromeMode)),
+	}
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"fmt"
+	"messageApi/internal/types"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Length limits used when they are not set in the config
+const (
+	defaultMinLength = 1
+	defaultMaxLength = 100
+)
+
+// characterClasses maps the names of the character classes that can be allowed to their Unicode ranges
+var characterClasses = map[string]*unicode.RangeTable{
+	types.CharacterLetter: unicode.L,
+	types.CharacterMark:   unicode.M,
+	types.CharacterNumber: unicode.N,
+	types.CharacterPunct:  unicode.P,
+	types.CharacterSymbol: unicode.S,
+	types.CharacterSpace:  unicode.Z,
+}
+
+// validationRules applies the default validation rules and checks they can be enforced by the database
+func validationRules(rules types.ValidationConfig) (types.ValidationConfig, error) {
+	if rules.MinLength <= 0 {
+		rules.MinLength = defaultMinLength
+	}
+
+	if rules.MaxLength <= 0 {
+		rules.MaxLength = defaultMaxLength
+	}
+
+	if rules.MaxLength > types.MaxMessageLength {
+		return rules, fmt.Errorf("message max length %d is longer than the %d characters the database can store", rules.MaxLength, types.MaxMessageLength)
+	}
+
+	if rules.MinLength > rules.MaxLength {
+		return rules, fmt.Errorf("message min length %d is longer than the max length %d", rules.MinLength, rules.MaxLength)
+	}
+
+	for _, class := range rules.AllowedClasses {
+		if _, ok := characterClasses[class]; !ok {
+			return rules, fmt.Errorf("character class %q is not supported", class)
+		}
+	}
+
+	return rules, nil
+}
+
+// prepareMessage applies the changes the rules make to a message before it is validated
+func prepareMessage(msg string, rules types.ValidationConfig) string {
+	if rules.TrimSpace {
+		return strings.TrimSpace(msg)
+	}
+
+	return msg
+}
+
+// validateMessage checks that the Message matches the rules
+// Lengths are counted in runes to match how the database counts characters
+func validateMessage(msg types.Message, rules types.ValidationConfig) error {
+	length := utf8.RuneCountInString(msg.Message)
+
+	if length > rules.MaxLength {
+		return messageError(fmt.Sprintf("message cannot be longer than %d characters", rules.MaxLength))
+	}
+
+	if length == 0 {
+		return messageError("message cannot be an empty string")
+	}
+
+	if length < rules.MinLength {
+		return messageError(fmt.Sprintf("message cannot be shorter than %d characters", rules.MinLength))
+	}
+
+	if !utf8.ValidString(msg.Message) {
+		return messageError("message must be valid UTF-8")
+	}
+
+	for _, r := range msg.Message {
+		if unicode.IsControl(r) {
+			if rules.AllowControl {
+				continue
+			}
+			return messageError("message cannot contain control characters")
+		}
+
+		if len(rules.AllowedClasses) > 0 && !isAllowedCharacter(r, rules.AllowedClasses) {
+			return messageError(fmt.Sprintf("message can only contain %s characters", strings.Join(rules.AllowedClasses, ", ")))
+		}
+	}
+
+	return nil
+}
+
+// isAllowedCharacter checks if the rune belongs to one of the allowed character classes
+func isAllowedCharacter(r rune, classes []string) bool {
+	for _, class := range classes {
+		if unicode.Is(characterClasses[class], r) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// messageError creates a validation error for the message field
+func messageError(msg string) error {
+	return &types.ValidationError{Field: "message", Message: msg}
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"errors"
+	"messageApi/internal/database"
+	"messageApi/internal/logging"
+	"messageApi/internal/types"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// defaultRules are the validation rules used when none are set in the config
+var defaultRules = types.ValidationConfig{MinLength: defaultMinLength, MaxLength: defaultMaxLength}
+
+// TestValidateMessageRuneLength tests the length is counted in characters rather than bytes
+func TestValidateMessageRuneLength(t *testing.T) {
+	msg := types.Message{Message: strings.Repeat("日本語", 33)}
+
+	assert.Equal(t, nil, validateMessage(msg, defaultRules))
+
+	msg.Message += "本本"
+	assert.Equal(t, messageError("message cannot be longer than 100 characters"), validateMessage(msg, defaultRules))
+}
+
+// TestValidateMessageMinLength tests messages shorter than the min length are rejected
+func TestValidateMessageMinLength(t *testing.T) {
+	rules := types.ValidationConfig{MinLength: 3, MaxLength: 10}
+
+	assert.Equal(t, messageError("message cannot be shorter than 3 characters"), validateMessage(types.Message{Message: "ab"}, rules))
+	assert.Equal(t, nil, validateMessage(types.Message{Message: "abc"}, rules))
+}
+
+// TestValidateMessageControlCharacters tests control characters are rejected unless allowed
+func TestValidateMessageControlCharacters(t *testing.T) {
+	msg := types.Message{Message: "line\nbreak"}
+
+	assert.Equal(t, messageError("message cannot contain control characters"), validateMessage(msg, defaultRules))
+
+	rules := defaultRules
+	rules.AllowControl = true
+	assert.Equal(t, nil, validateMessage(msg, rules))
+}
+
+// TestValidateMessageAllowedClasses tests only characters from the allowed classes are accepted
+func TestValidateMessageAllowedClasses(t *testing.T) {
+	rules := defaultRules
+	rules.AllowedClasses = []string{types.CharacterLetter, types.CharacterSpace}
+
+	assert.Equal(t, nil, validateMessage(types.Message{Message: "été en ville"}, rules))
+	assert.Equal(t, messageError("message can only contain letter, space characters"), validateMessage(types.Message{Message: "route 66"}, rules))
+}
+
+// TestValidateMessageInvalidUTF8 tests messages that are not valid UTF-8 are rejected
+func TestValidateMessageInvalidUTF8(t *testing.T) {
+	assert.Equal(t, messageError("message must be valid UTF-8"), validateMessage(types.Message{Message: "ab\xff"}, defaultRules))
+}
+
+// TestPrepareMessage tests whitespace is only trimmed when enabled
+func TestPrepareMessage(t *testing.T) {
+	assert.Equal(t, "  racecar ", prepareMessage("  racecar ", defaultRules))
+	assert.Equal(t, "racecar", prepareMessage("  racecar ", types.ValidationConfig{TrimSpace: true}))
+}
+
+// TestValidationRules tests the default rules are applied and rules the database cannot enforce are rejected
+func TestValidationRules(t *testing.T) {
+	rules, err := validationRules(types.ValidationConfig{})
+	assert.Equal(t, defaultRules, rules)
+	assert.Equal(t, nil, err)
+
+	_, err = validationRules(types.ValidationConfig{MaxLength: types.MaxMessageLength + 1})
+	assert.Equal(t, errors.New("message max length 1001 is longer than the 1000 characters the database can store"), err)
+
+	_, err = validationRules(types.ValidationConfig{MinLength: 20, MaxLength: 10})
+	assert.Equal(t, errors.New("message min length 20 is longer than the max length 10"), err)
+
+	_, err = validationRules(types.ValidationConfig{AllowedClasses: []string{"emoji"}})
+	assert.Equal(t, errors.New(`character class "emoji" is not supported`), err)
+}
+
+// TestCreateMessageTrimSpace tests the trimmed message is validated and stored when trimming is enabled
+func TestCreateMessageTrimSpace(t *testing.T) {
+	db_stub := database.DatabaseStub{}
+	cfg := types.Config{Validation: types.ValidationConfig{TrimSpace: true}}
+	service, _ := NewService(cfg, &db_stub, logging.Discard())
+
+	_, err := service.CreateMessage(ownerContext(), types.Message{Message: "  racecar\n"})
+
+	assert.Equal(t, "racecar", db_stub.CreateMessageInput.Message)
+	assert.Equal(t, nil, err)
+
+	_, err = service.CreateMessage(ownerContext(), types.Message{Message: "   "})
+
+	assert.Equal(t, messageError("message cannot be an empty string"), err)
+}
@@ -0,0 +1,107 @@
+// Package tracing configures OpenTelemetry tracing shared by the application modules
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"messageApi/internal/types"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// tracesPath is the OTLP/HTTP path traces are sent to when the endpoint does not set one
+const tracesPath = "/v1/traces"
+
+// Shutdown flushes any spans that have not been exported and stops the tracer provider
+type Shutdown func(context.Context) error
+
+// Setup installs the global tracer provider and W3C trace context propagator
+// Spans are exported to the OTLP/HTTP collector in the config when tracing is enabled, otherwise they are dropped
+func Setup(cfg types.TracingConfig) (Shutdown, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if !cfg.Enabled {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		return func(context.Context) error { return nil }, nil
+	}
+
+	provider, err := NewTracerProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// NewTracerProvider creates a tracer provider that batches spans and exports them to the OTLP/HTTP collector
+func NewTracerProvider(cfg types.TracingConfig) (*sdktrace.TracerProvider, error) {
+	endpoint, err := endpointURL(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpointURL(endpoint)}
+	if len(cfg.Headers) > 0 {
+		opts = append(opts, otlptracehttp.WithHeaders(cfg.Headers))
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(cfg.ServiceName)))
+	if err != nil {
+		return nil, err
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	), nil
+}
+
+// endpointURL checks the collector endpoint is an http or https URL, adding the traces path when it has none
+func endpointURL(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("tracing endpoint %q is not a valid URL: %w", endpoint, err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("tracing endpoint %q must be an http or https URL", endpoint)
+	}
+
+	if strings.TrimSuffix(u.Path, "/") == "" {
+		u.Path = tracesPath
+	}
+
+	return u.String(), nil
+}
+
+// Tracer returns a tracer from the global tracer provider named after the module using it
+func Tracer(name string) trace.Tracer {
+	return otel.Tracer(name)
+}
+
+// EndSpan records the error on the span, if there is one, and ends the span
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,111 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"messageApi/internal/types"
+
+	"github.com/stretchr/testify/assert"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// newTestCollector starts an in-process OTLP/HTTP collector that sends each export request it receives to the channel
+func newTestCollector(t *testing.T) (*httptest.Server, chan *coltracepb.ExportTraceServiceRequest) {
+	requests := make(chan *coltracepb.ExportTraceServiceRequest, 10)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != tracesPath || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		request := &coltracepb.ExportTraceServiceRequest{}
+		if err := proto.Unmarshal(body, request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		requests <- request
+
+		response, _ := proto.Marshal(&coltracepb.ExportTraceServiceResponse{})
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Write(response)
+	}))
+	t.Cleanup(collector.Close)
+
+	return collector, requests
+}
+
+// TestSetupExportsToCollector tests spans are exported to the configured collector with the service name
+func TestSetupExportsToCollector(t *testing.T) {
+	collector, requests := newTestCollector(t)
+	cfg := types.TracingConfig{Enabled: true, Endpoint: collector.URL, ServiceName: "messageApi-test", SampleRatio: 1, Headers: map[string]string{"X-Api-Key": "secret"}}
+
+	shutdown, err := Setup(cfg)
+	assert.Equal(t, nil, err)
+
+	ctx, parent := Tracer("test").Start(context.Background(), "parent")
+	_, child := Tracer("test").Start(ctx, "child")
+	EndSpan(child, errors.New("failed"))
+	parent.End()
+
+	assert.Equal(t, nil, shutdown(context.Background()))
+
+	request := <-requests
+	resourceSpans := request.GetResourceSpans()
+	assert.Len(t, resourceSpans, 1)
+
+	serviceName := ""
+	for _, attr := range resourceSpans[0].GetResource().GetAttributes() {
+		if attr.GetKey() == "service.name" {
+			serviceName = attr.GetValue().GetStringValue()
+		}
+	}
+	assert.Equal(t, "messageApi-test", serviceName)
+
+	spans := map[string]string{}
+	for _, scopeSpans := range resourceSpans[0].GetScopeSpans() {
+		for _, span := range scopeSpans.GetSpans() {
+			spans[span.GetName()] = span.GetStatus().GetMessage()
+		}
+	}
+	assert.Equal(t, map[string]string{"parent": "", "child": "failed"}, spans)
+}
+
+// TestSetupDisabled tests spans are not recorded when tracing is disabled
+func TestSetupDisabled(t *testing.T) {
+	shutdown, err := Setup(types.TracingConfig{Enabled: false, Endpoint: "not a url"})
+	assert.Equal(t, nil, err)
+
+	_, span := Tracer("test").Start(context.Background(), "span")
+
+	assert.False(t, span.IsRecording())
+	assert.Equal(t, nil, shutdown(context.Background()))
+}
+
+// TestEndpointURL tests the traces path is only added when the endpoint has no path
+func TestEndpointURL(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		expected string
+		err      bool
+	}{
+		{"http://localhost:4318", "http://localhost:4318/v1/traces", false},
+		{"https://collector.example.com/", "https://collector.example.com/v1/traces", false},
+		{"http://localhost:4318/custom/traces", "http://localhost:4318/custom/traces", false},
+		{"localhost:4318", "", true},
+		{"grpc://localhost:4317", "", true},
+	}
+
+	for _, tt := range tests {
+		endpoint, err := endpointURL(tt.endpoint)
+
+		assert.Equal(t, tt.expected, endpoint, tt.endpoint)
+		assert.Equal(t, tt.err, err != nil, tt.endpoint)
+	}
+}
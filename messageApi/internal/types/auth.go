@@ -0,0 +1,103 @@
+package types
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// Scope represents a permission granted to a caller
+type Scope string
+
+// Scopes that can be granted to a caller
+const (
+	// ScopeRead allows Messages to be read and listed
+	ScopeRead Scope = "read"
+	// ScopeWrite allows Messages to be created, updated and deleted
+	ScopeWrite Scope = "write"
+	// ScopeAdmin allows API keys to be managed and grants every other scope
+	ScopeAdmin Scope = "admin"
+)
+
+// Scopes lists every Scope in order of increasing permission
+var Scopes = []Scope{ScopeRead, ScopeWrite, ScopeAdmin}
+
+// Modes supported for authenticating callers
+const (
+	// AuthNone allows every caller to read and write Messages without credentials
+	AuthNone = "none"
+	// AuthAPIKey requires callers to send an API key issued by an admin
+	AuthAPIKey = "apikey"
+	// AuthJWT requires callers to send a JWT signed by a key in the configured JWKS
+	AuthJWT = "jwt"
+)
+
+// AuthConfig represents how callers of the v1 routes are authenticated
+// BootstrapKey is accepted as an admin key so the first keys can be issued
+type AuthConfig struct {
+	Mode         string `env:"AUTH_MODE" envDefault:"none"`
+	BootstrapKey string `env:"AUTH_BOOTSTRAP_KEY"`
+	JWT          JWTConfig
+}
+
+// JWTConfig represents how bearer tokens are validated and mapped to an Identity when using the jwt auth mode
+// The signing keys are loaded from JWKSURL, or JWKSFile when no URL is set, and reloaded every JWKSRefreshInterval
+// Scopes are read from ScopeClaim, which can be a space separated string or a list, keeping values that start with ScopePrefix
+type JWTConfig struct {
+	JWKSURL             string        `env:"AUTH_JWKS_URL"`
+	JWKSFile            string        `env:"AUTH_JWKS_FILE"`
+	JWKSRefreshInterval time.Duration `env:"AUTH_JWKS_REFRESH_INTERVAL" envDefault:"15m"`
+	Issuer              string        `env:"AUTH_JWT_ISSUER"`
+	Audience            string        `env:"AUTH_JWT_AUDIENCE"`
+	SubjectClaim        string        `env:"AUTH_JWT_SUBJECT_CLAIM" envDefault:"sub"`
+	ScopeClaim          string        `env:"AUTH_JWT_SCOPE_CLAIM" envDefault:"scope"`
+	ScopePrefix         string        `env:"AUTH_JWT_SCOPE_PREFIX"`
+	TenantClaim         string        `env:"AUTH_JWT_TENANT_CLAIM" envDefault:"tenant"`
+	Leeway              time.Duration `env:"AUTH_JWT_LEEWAY" envDefault:"30s"`
+}
+
+// APIKey represents a key issued to a caller
+// Only the hash of the key is stored; Key holds the key itself just once, in the response to issuing it
+// Callers using the key always belong to its Tenant
+type APIKey struct {
+	Id        int        `db:"id" json:"id"`
+	Tenant    string     `db:"tenant" json:"tenant"`
+	Name      string     `db:"name" json:"name"`
+	Prefix    string     `db:"prefix" json:"prefix"`
+	Hash      string     `db:"hash" json:"-"`
+	Scopes    []Scope    `db:"scopes" json:"scopes"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
+	RevokedAt *time.Time `db:"revoked_at" json:"revoked_at,omitempty"`
+	Key       string     `db:"-" json:"key,omitempty"`
+}
+
+// AnonymousSubject is the Subject of every caller when authentication is disabled
+const AnonymousSubject = "anonymous"
+
+// Identity represents the authenticated caller of a request
+// Tenant is only set when the caller's credentials name the tenant they belong to
+type Identity struct {
+	Subject string
+	Scopes  []Scope
+	Tenant  string
+}
+
+// HasScope checks the Identity was granted the scope, which the admin scope always grants
+func (i Identity) HasScope(scope Scope) bool {
+	return slices.Contains(i.Scopes, scope) || slices.Contains(i.Scopes, ScopeAdmin)
+}
+
+// identityKey is the context key for the Identity
+type identityKey struct{}
+
+// WithIdentity returns a copy of the context holding the Identity of the caller
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFromContext returns the Identity of the caller held in the context
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(Identity)
+
+	return identity, ok
+}
@@ -0,0 +1,55 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+)
+
+// BatchOp names the change a BatchOperation makes to a Message
+type BatchOp string
+
+// Changes that can be made by a BatchOperation
+const (
+	BatchCreate BatchOp = "create"
+	BatchUpdate BatchOp = "update"
+	BatchDelete BatchOp = "delete"
+)
+
+// BatchConfig represents the limits on a batch of changes sent in a single request
+type BatchConfig struct {
+	MaxOperations int `env:"BATCH_MAX_OPERATIONS" envDefault:"5000"`
+}
+
+// BatchOperation represents a single change to a Message sent in a batch
+// The Message fields are read from the same JSON object as the op. Id and Version are only used by updates and deletes,
+// where a Version of 0 changes any version, and the message and palindromemode only by creates and updates
+type BatchOperation struct {
+	Op BatchOp `json:"op"`
+	Message
+}
+
+// BatchResult represents the outcome of a single BatchOperation
+// Message is the stored Message after a create or update, and only holds the id after a delete
+type BatchResult struct {
+	Message Message
+	Err     error
+}
+
+// ErrAborted is the error for a change in a transactional batch that was not made because another change failed
+var ErrAborted = errors.New("aborted")
+
+// BatchError represents the failure of a single change in a batch that has to be made in full or not at all
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+// Error returns the failure message with the position of the change in the batch
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("operation %d: %v", e.Index, e.Err)
+}
+
+// Unwrap allows a BatchError to be matched against the error of the change that failed
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
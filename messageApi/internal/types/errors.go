@@ -0,0 +1,33 @@
+package types
+
+import "errors"
+
+// Errors shared between the modules so the cause of a failure can be identified in any module
+var (
+	ErrNotFound             = errors.New("not found")
+	ErrValidation           = errors.New("validation failed")
+	ErrConflict             = errors.New("conflict")
+	ErrUnavailable          = errors.New("unavailable")
+	ErrUnauthorized         = errors.New("unauthorized")
+	ErrForbidden            = errors.New("forbidden")
+	ErrQuota                = errors.New("quota exceeded")
+	ErrPrecondition         = errors.New("precondition failed")
+	ErrPreconditionRequired = errors.New("precondition required")
+	ErrIdempotency          = errors.New("idempotency key reused")
+)
+
+// ValidationError represents a validation failure for a single field
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+// Error returns the validation failure message
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// Unwrap allows a ValidationError to be matched against ErrValidation
+func (e *ValidationError) Unwrap() error {
+	return ErrValidation
+}
@@ -0,0 +1,24 @@
+package types
+
+import "time"
+
+// MaxIdempotencyKeyLength is the longest Idempotency-Key, in bytes, that the database column can store
+const MaxIdempotencyKeyLength = 255
+
+// IdempotencyConfig represents how long the response to a request with an Idempotency-Key is kept for retries
+type IdempotencyConfig struct {
+	TTL time.Duration `env:"IDEMPOTENCY_TTL" envDefault:"24h"`
+}
+
+// IdempotencyRecord represents a request made with an Idempotency-Key by a caller and the response returned for it
+// RequestHash identifies the method, path, query, If-Match header and body of the request so a key reused for another request can be rejected
+// Status is 0 while the first request is still being handled
+type IdempotencyRecord struct {
+	Key         string
+	Caller      string
+	RequestHash string
+	Status      int
+	Headers     map[string]string
+	Body        []byte
+	ExpiresAt   time.Time
+}
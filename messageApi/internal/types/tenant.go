@@ -0,0 +1,35 @@
+package types
+
+import "context"
+
+// DefaultTenant owns every Message stored without a tenant, including those stored before tenants were added
+const DefaultTenant = "default"
+
+// MaxTenantLength is the longest tenant id, in bytes, that the database column can store
+const MaxTenantLength = 100
+
+// TenantConfig represents how the tenant of a request is resolved and how many Messages each tenant can store
+// A tenant from the caller's token always wins; Header is only used for callers without one
+// MaxMessages applies to every tenant without an entry in Quotas, and zero means no limit
+type TenantConfig struct {
+	Header      string         `env:"TENANT_HEADER" envDefault:"X-Tenant-ID"`
+	MaxMessages int            `env:"TENANT_MAX_MESSAGES" envDefault:"0"`
+	Quotas      map[string]int `env:"TENANT_QUOTAS"`
+}
+
+// tenantKey is the context key for the tenant
+type tenantKey struct{}
+
+// WithTenant returns a copy of the context holding the tenant
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant held in the context, or DefaultTenant when there is none
+func TenantFromContext(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantKey{}).(string); ok && tenant != "" {
+		return tenant
+	}
+
+	return DefaultTenant
+}
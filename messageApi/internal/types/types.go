@@ -1,22 +1,179 @@
 package types
 
+import (
+	"log/slog"
+	"time"
+)
+
 // Message represents a data struct for passing between modules
+// Owner is the Subject of the Identity that created the Message and is never taken from a request body
+// Version starts at 1 and is increased by every update, so it identifies the state of the Message for ETags
 type Message struct {
-	Id           int    `db:"id" json:"id"`
-	Message      string `db:"message" json:"message"`
-	IsPalindrome bool   `db:"ispalindrome" json:"ispalindrome"`
+	Id             int            `db:"id" json:"id"`
+	Message        string         `db:"message" json:"message"`
+	IsPalindrome   bool           `db:"ispalindrome" json:"ispalindrome"`
+	PalindromeMode PalindromeMode `db:"palindromemode" json:"palindromemode,omitempty"`
+	Owner          string         `db:"owner" json:"owner,omitempty"`
+	Version        int            `db:"version" json:"version"`
+}
+
+// PalindromeMode represents how a Message is compared when checking if it is a palindrome
+type PalindromeMode string
+
+// Modes supported when checking if a Message is a palindrome
+const (
+	// PalindromeExact requires an exact match, including casing, whitespace and punctuation
+	PalindromeExact PalindromeMode = "exact"
+	// PalindromeCaseInsensitive ignores differences in letter case
+	PalindromeCaseInsensitive PalindromeMode = "case-insensitive"
+	// PalindromeAlphanumeric ignores letter case and anything that is not a letter or number
+	PalindromeAlphanumeric PalindromeMode = "alphanumeric"
+	// PalindromeNFC compares the Message after Unicode canonical composition
+	PalindromeNFC PalindromeMode = "nfc"
+	// PalindromeNFKD compares the Message after Unicode compatibility decomposition
+	PalindromeNFKD PalindromeMode = "nfkd"
+)
+
+// Values used for sorting lists of Messages
+const (
+	SortById      = "id"
+	SortByMessage = "message"
+	OrderAsc      = "asc"
+	OrderDesc     = "desc"
+)
+
+// ListOptions represents the parameters used to page, filter and sort a list of Messages
+type ListOptions struct {
+	Limit        int
+	After        *Cursor
+	Sort         string
+	Order        string
+	IsPalindrome *bool
+	Owner        string
+}
+
+// Cursor represents a position in a sorted list of Messages used for keyset pagination
+// Value holds the sort column of the last Message seen when sorting by anything other than id
+// Sort and Order record how the list was sorted, as the position means nothing in a list sorted another way
+type Cursor struct {
+	Id    int    `json:"id"`
+	Value string `json:"value,omitempty"`
+	Sort  string `json:"sort"`
+	Order string `json:"order"`
+}
+
+// MessagePage represents a single page of Messages along with the cursor for the next page
+type MessagePage struct {
+	Messages []Message
+	Next     *Cursor
 }
 
 // Config represents the configuration for the service
 type Config struct {
-	Db DbConnection
+	Db          DbConnection
+	List        ListConfig
+	Server      ServerConfig
+	Palindrome  PalindromeConfig
+	Validation  ValidationConfig
+	Tracing     TracingConfig
+	Log         LogConfig
+	Auth        AuthConfig
+	Tenant      TenantConfig
+	RateLimit   RateLimitConfig
+	Idempotency IdempotencyConfig
+	Batch       BatchConfig
 }
 
+// MaxMessageLength is the longest Message, in characters, that the database column can store
+// Postgres counts varchar lengths in characters, so it matches a count of runes
+const MaxMessageLength = 1000
+
 // DbConnection represents the values needed to connect to a database
+// Path is only used by the sqlite driver, which stores the database in that file or in memory for :memory:
 type DbConnection struct {
-	Host     string `env:"DB_HOST"`
-	Port     int    `env:"DB_PORT" envDefault:"5432"`
-	User     string `env:"DB_USER"`
-	Password string `env:"DB_PASSWORD"`
-	Database string `env:"DB_DATABASE"`
+	Driver      string `env:"DB_DRIVER" envDefault:"postgres"`
+	Host        string `env:"DB_HOST"`
+	Port        int    `env:"DB_PORT" envDefault:"5432"`
+	User        string `env:"DB_USER"`
+	Password    string `env:"DB_PASSWORD"`
+	Database    string `env:"DB_DATABASE"`
+	Path        string `env:"DB_PATH" envDefault:"messages.db"`
+	AutoMigrate bool   `env:"DB_AUTO_MIGRATE" envDefault:"false"`
+}
+
+// ListConfig represents the page size limits applied when listing Messages
+type ListConfig struct {
+	DefaultLimit int `env:"LIST_DEFAULT_LIMIT" envDefault:"50"`
+	MaxLimit     int `env:"LIST_MAX_LIMIT" envDefault:"1000"`
+}
+
+// ServerConfig represents the configuration for listening for and handling requests
+// ShutdownDelay is how long /readyz reports the server as shutting down before it stops accepting connections
+// RequireIfMatch rejects updates and deletes of Messages that do not send an If-Match header
+type ServerConfig struct {
+	Host               string        `env:"HOST"`
+	Port               int           `env:"PORT" envDefault:"8080"`
+	RequestTimeout     time.Duration `env:"REQUEST_TIMEOUT" envDefault:"30s"`
+	ReadTimeout        time.Duration `env:"READ_TIMEOUT" envDefault:"15s"`
+	WriteTimeout       time.Duration `env:"WRITE_TIMEOUT" envDefault:"35s"`
+	IdleTimeout        time.Duration `env:"IDLE_TIMEOUT" envDefault:"60s"`
+	MaxHeaderBytes     int           `env:"MAX_HEADER_BYTES" envDefault:"1048576"`
+	MaxBodyBytes       int64         `env:"MAX_BODY_BYTES" envDefault:"10485760"`
+	ShutdownTimeout    time.Duration `env:"SHUTDOWN_TIMEOUT" envDefault:"20s"`
+	ShutdownDelay      time.Duration `env:"SHUTDOWN_DELAY" envDefault:"0s"`
+	HealthCheckTimeout time.Duration `env:"HEALTH_CHECK_TIMEOUT" envDefault:"2s"`
+	RequireIfMatch     bool          `env:"REQUIRE_IF_MATCH" envDefault:"false"`
+}
+
+// PalindromeConfig represents the configuration for checking if Messages are palindromes
+type PalindromeConfig struct {
+	DefaultMode PalindromeMode `env:"PALINDROME_MODE" envDefault:"exact"`
+}
+
+// Character classes that can be allowed in a Message
+const (
+	CharacterLetter = "letter"
+	CharacterMark   = "mark"
+	CharacterNumber = "number"
+	CharacterPunct  = "punct"
+	CharacterSymbol = "symbol"
+	CharacterSpace  = "space"
+)
+
+// ValidationConfig represents the rules a Message must meet before it is stored
+// Lengths are counted in characters (runes) after any trimming, and cannot exceed MaxMessageLength
+type ValidationConfig struct {
+	MinLength      int      `env:"MESSAGE_MIN_LENGTH" envDefault:"1"`
+	MaxLength      int      `env:"MESSAGE_MAX_LENGTH" envDefault:"100"`
+	TrimSpace      bool     `env:"MESSAGE_TRIM_SPACE" envDefault:"false"`
+	AllowedClasses []string `env:"MESSAGE_ALLOWED_CLASSES" envSeparator:","`
+	AllowControl   bool     `env:"MESSAGE_ALLOW_CONTROL" envDefault:"false"`
+}
+
+// TracingConfig represents where and how often OpenTelemetry traces are exported
+// Endpoint is the base URL of an OTLP/HTTP collector, for example http://localhost:4318
+type TracingConfig struct {
+	Enabled     bool              `env:"TRACING_ENABLED" envDefault:"false"`
+	Endpoint    string            `env:"TRACING_ENDPOINT" envDefault:"http://localhost:4318"`
+	Headers     map[string]string `env:"TRACING_HEADERS"`
+	ServiceName string            `env:"TRACING_SERVICE_NAME" envDefault:"messageApi"`
+	SampleRatio float64           `env:"TRACING_SAMPLE_RATIO" envDefault:"1"`
+}
+
+// RateLimitConfig represents how many requests each client can make to the v1 routes
+// Limits are in the form <requests>/<period>, such as 10/1m, and Rules are keyed by method and route template,
+// such as "POST /v1/messages" or "* /v1/messages/:id"; routes without a rule use Default, or are not limited when it is empty
+// PerIP limits all requests from an IP address before they are authenticated, or is not applied when it is empty
+type RateLimitConfig struct {
+	Enabled bool              `env:"RATE_LIMIT_ENABLED" envDefault:"false"`
+	Default string            `env:"RATE_LIMIT_DEFAULT" envDefault:"600/1m"`
+	Rules   map[string]string `env:"RATE_LIMIT_RULES" envSeparator:";" envKeyValSeparator:"="`
+	PerIP   string            `env:"RATE_LIMIT_PER_IP" envDefault:"1200/1m"`
+}
+
+// LogConfig represents the level and format of the structured logs
+// Level is one of debug, info, warn or error and Format is json or text
+type LogConfig struct {
+	Level  slog.Level `env:"LOG_LEVEL" envDefault:"info"`
+	Format string     `env:"LOG_FORMAT" envDefault:"json"`
 }
@@ -2,43 +2,140 @@
 package main
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
 
 	"messageApi/internal/database"
+	"messageApi/internal/logging"
 	"messageApi/internal/server"
 	"messageApi/internal/service"
+	"messageApi/internal/tracing"
 	"messageApi/internal/types"
 
 	"github.com/caarlos0/env/v11"
+	"github.com/gin-gonic/gin"
 )
 
 // main creates the application modules and runs the server
+// Running with the migrate argument manages the database schema instead
 func main() {
 	cfg := types.Config{}
 
 	// parse the environment variables
 	if err := env.Parse(&cfg); err != nil {
-		log.Fatal(err)
+		slog.Error("parsing config", "error", err)
+		os.Exit(1)
 	}
 
+	// create the structured logger shared by every module
+	logger, err := logging.NewLogger(cfg.Log, os.Stdout)
+	if err != nil {
+		slog.Error("creating logger", "error", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrate(cfg, os.Args[2:]); err != nil {
+			logger.Error("running migrations", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := run(cfg, logger); err != nil {
+		logger.Error("running server", "error", err)
+		os.Exit(1)
+	}
+}
+
+// run creates the application modules and serves requests until the server is shut down
+// Returning an error rather than exiting lets the deferred cleanup run
+func run(cfg types.Config, logger *slog.Logger) error {
+	if os.Getenv(gin.EnvGinMode) == "" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	// install the tracer provider used by every module
+	shutdownTracing, err := tracing.Setup(cfg.Tracing)
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing(context.Background())
+
 	// initialize the data module
-	db, err := database.NewDatabase(cfg)
+	db, err := database.NewDatabase(cfg, logger)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	defer db.Close()
 
 	// initialize the service module
-	service, err := service.NewService(cfg, db)
+	service, err := service.NewService(cfg, db, logger)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	// initialize the server module
-	srv, err := server.NewServer(cfg, service)
+	srv, err := server.NewServer(cfg, service, logger)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	// run the server until it is shut down
+	return srv.RunServer()
+}
+
+// migrate runs the migrate up, down or status command against the configured database
+func migrate(cfg types.Config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s migrate up|down|status", os.Args[0])
 	}
 
-	// run the server
-	srv.RunServer()
+	migrator, err := database.NewMigrator(cfg)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, migration := range applied {
+			fmt.Printf("applied %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("no migrations to apply")
+		}
+		return err
+	case "down":
+		reverted, err := migrator.Down(ctx)
+		if err == nil {
+			fmt.Printf("reverted %04d_%s\n", reverted.Version, reverted.Name)
+		}
+		return err
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Applied {
+				appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05 MST")
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected up, down or status", args[0])
+	}
 }